@@ -5,12 +5,21 @@
 package alarmlogger
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-var logger *zap.Logger
-
 type LogType string
 type AlarmSeverity string
 type AlarmVisibility string
@@ -28,18 +37,36 @@ const (
 	Info     AlarmSeverity = "INFO"
 
 	// visibility
-	Global 	   AlarmVisibility = "GLOBAL"		// default, visible "anywhere"
-	Operations AlarmVisibility = "OPERATIONS"   // not visisble in C-UI (of NDAC)
+	Global     AlarmVisibility = "GLOBAL"     // default, visible "anywhere"
+	Operations AlarmVisibility = "OPERATIONS" // not visisble in C-UI (of NDAC)
+
+	// state
+	StateCleared = 0
+	StateRaised  = 1
+	StateUpdated = 2 // severity or text change of an active alarm
 )
 
+// ErrAlarmNotActive is returned when updating an alarm which is not raised
+var ErrAlarmNotActive = errors.New("alarm is not active")
+
 type AlarmDetails struct {
-	Name       string `json:"name"`
-	ID         string `json:"id"`
-	Severity   AlarmSeverity `json:"severity"`
-	Text       string `json:"text"`
-	State      int    `json:"state"`
+	Name       string          `json:"name"`
+	ID         string          `json:"id"`
+	Severity   AlarmSeverity   `json:"severity"`
+	Text       string          `json:"text"`
+	State      int             `json:"state"`
 	Visibility AlarmVisibility `json:"visibility,omitempty"`
-	SubDN      string `json:"subdn,omitempty"`
+	SubDN      string          `json:"subdn,omitempty"`
+
+	// The following fields are optional, they appear in the alarm log only if set
+	ProbableCause   string            `json:"probablecause,omitempty"`
+	SpecificProblem string            `json:"specificproblem,omitempty"`
+	AdditionalInfo  map[string]string `json:"additionalinfo,omitempty"`
+	// EventTime is the time the problem was detected, it can be earlier than the time of the alarm log
+	EventTime *time.Time `json:"eventtime,omitempty"`
+	// NotificationID identifies this notification, CorrelationID links the notifications of related alarms
+	NotificationID string `json:"notificationid,omitempty"`
+	CorrelationID  string `json:"correlationid,omitempty"`
 }
 
 func (a *AlarmDetails) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -57,73 +84,562 @@ func (a *AlarmDetails) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		enc.AddString("subdn", a.SubDN)
 	}
 
+	if len(a.ProbableCause) > 0 {
+		enc.AddString("probablecause", a.ProbableCause)
+	}
+
+	if len(a.SpecificProblem) > 0 {
+		enc.AddString("specificproblem", a.SpecificProblem)
+	}
+
+	if len(a.AdditionalInfo) > 0 {
+		if err := enc.AddObject("additionalinfo", stringMap(a.AdditionalInfo)); err != nil {
+			return err
+		}
+	}
+
+	if a.EventTime != nil {
+		enc.AddString("eventtime", a.EventTime.UTC().Format(time.RFC3339Nano))
+	}
+
+	if len(a.NotificationID) > 0 {
+		enc.AddString("notificationid", a.NotificationID)
+	}
+
+	if len(a.CorrelationID) > 0 {
+		enc.AddString("correlationid", a.CorrelationID)
+	}
+
+	return nil
+}
+
+// clone returns a copy of the alarm not sharing the AdditionalInfo map and the EventTime
+func (a *AlarmDetails) clone() AlarmDetails {
+	out := *a
+	if a.EventTime != nil {
+		eventTime := *a.EventTime
+		out.EventTime = &eventTime
+	}
+	if a.AdditionalInfo != nil {
+		out.AdditionalInfo = make(map[string]string, len(a.AdditionalInfo))
+		for key, value := range a.AdditionalInfo {
+			out.AdditionalInfo[key] = value
+		}
+	}
+	return out
+}
+
+type stringMap map[string]string
+
+func (m stringMap) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		enc.AddString(key, m[key])
+	}
 	return nil
 }
 
-func init() {
-	cfg := zap.Config{
-		Encoding:         "json",
-		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
-		DisableCaller:    true,
-		OutputPaths:      []string{"stderr"},
-		ErrorOutputPaths: []string{"stderr"},
-		EncoderConfig: zapcore.EncoderConfig{
-			TimeKey:    "ts",
-			EncodeTime: zapcore.ISO8601TimeEncoder,
-			LineEnding: zapcore.DefaultLineEnding,
-		},
+// AlarmLogger produces alarm logs to the configured outputs. The zero value is
+// not usable, instances have to be created with New.
+type AlarmLogger struct {
+	logger          *zap.Logger
+	sink            Sink
+	registry        *Registry
+	catalogue       *Catalogue
+	cataloguePolicy CataloguePolicy
+	errorHandler    func(error)
+	damper          *damper
+	escalator       *escalator
+	store           Store
+	storeMu         sync.Mutex
+	// savePending is set while a save of the store is queued on the asynchronous sink
+	savePending int32
+	async       *asyncSink
+	stats       *Stats
+	// files are the outputs opened by WithFile, they are closed by Close
+	files     []*os.File
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type options struct {
+	writers         []io.Writer
+	files           []*os.File
+	cores           []zapcore.Core
+	sinks           []Sink
+	registry        *Registry
+	catalogue       *Catalogue
+	cataloguePolicy CataloguePolicy
+	errorHandler    func(error)
+	store           Store
+	queueSize       int
+	rateInterval    time.Duration
+	rateBurst       int
+	err             error
+}
+
+// Option configures an AlarmLogger created by New
+type Option func(*options)
+
+// WithWriter adds w as an output of the alarm log. Can be given multiple times.
+func WithWriter(w io.Writer) Option {
+	return func(o *options) {
+		o.writers = append(o.writers, w)
+	}
+}
+
+// WithFile appends the alarm log to the file on the given path, creating it if necessary
+func WithFile(path string) Option {
+	return func(o *options) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.writers = append(o.writers, f)
+		o.files = append(o.files, f)
 	}
+}
 
-	logr, err := cfg.Build()
-	if nil != err {
-		panic("unable to create a logger")
+// WithCore adds a custom zap core as an output of the alarm log. The core is
+// responsible for its own encoding.
+func WithCore(core zapcore.Core) Option {
+	return func(o *options) {
+		o.cores = append(o.cores, core)
 	}
+}
 
-	logger = logr
+// WithSink adds further destinations to the alarm log. Unlike the output options it does
+// not replace the default stderr output. Can be given multiple times.
+func WithSink(sinks ...Sink) Option {
+	return func(o *options) {
+		o.sinks = append(o.sinks, sinks...)
+	}
 }
 
-// RaiseAlarm produces alarm log with state 1
-func RaiseAlarm(logtype LogType, alarm *AlarmDetails) {
+// WithRegistry enables de-duplication: a raise of an already active alarm and a
+// clear of an inactive alarm are not logged. The registry can be shared between instances.
+func WithRegistry(registry *Registry) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithCatalogue checks the alarms against the definitions of the catalogue and completes their
+// missing ID, Severity and Visibility. The policy decides whether a mismatching alarm is still emitted.
+func WithCatalogue(catalogue *Catalogue, policy CataloguePolicy) Option {
+	return func(o *options) {
+		o.catalogue = catalogue
+		o.cataloguePolicy = policy
+	}
+}
+
+// WithErrorHandler sets the function receiving the problems which do not prevent emitting the alarm,
+// e.g. catalogue mismatches with WarnOnMismatch. By default they are discarded, they are never written to the alarm
+// log, so the consumers of the alarm log only see alarms.
+func WithErrorHandler(handler func(error)) Option {
+	return func(o *options) {
+		o.errorHandler = handler
+	}
+}
+
+// WithStore persists the active alarms to the store and restores them when the AlarmLogger is created,
+// so an alarm raised before a restart can still be cleared. Implies WithRegistry if not given.
+func WithStore(store Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithAsync writes the alarm log from a background goroutine through a queue of the given size, so the callers
+// are never blocked by slow outputs. While the queue is full, an entry is merged into the queued entry of the same
+// alarm, so the latest state of every alarm is written; the entries of the other alarms take the place of a queued
+// update or of a queued raise which is cleared later, otherwise they are dropped, counted in Stats and reported to the
+// error handler with ErrQueueFull. The registry and the error handler are still updated on the calling goroutine.
+func WithAsync(queueSize int) Option {
+	return func(o *options) {
+		if queueSize <= 0 {
+			o.err = fmt.Errorf("invalid queue size %d", queueSize)
+			return
+		}
+		o.queueSize = queueSize
+	}
+}
+
+// WithRateLimit allows every alarm instance to produce burst alarm log entries at once, then one per interval.
+// The entries over the limit are dropped and counted in Stats, except the ones changing the alarm between
+// raised and cleared.
+func WithRateLimit(interval time.Duration, burst int) Option {
+	return func(o *options) {
+		if interval <= 0 || burst <= 0 {
+			o.err = fmt.Errorf("invalid rate limit of %d per %v", burst, interval)
+			return
+		}
+		o.rateInterval = interval
+		o.rateBurst = burst
+	}
+}
+
+// New creates an AlarmLogger. Without any output option the alarm log is
+// written to stderr. Returns an error if an option is invalid or a configured
+// output cannot be opened.
+func New(opts ...Option) (*AlarmLogger, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if nil != o.err {
+		for _, f := range o.files {
+			f.Close()
+		}
+		return nil, fmt.Errorf("unable to create a logger: %w", o.err)
+	}
+
+	if nil != o.store && nil == o.registry {
+		o.registry = NewRegistry()
+	}
+
+	if len(o.writers) == 0 && len(o.cores) == 0 {
+		o.writers = append(o.writers, os.Stderr)
+	}
+
+	cores := o.cores
+	for _, w := range o.writers {
+		cores = append(cores, zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig()), zapcore.Lock(zapcore.AddSync(w)), zapcore.DebugLevel))
+	}
+
+	logger := zap.New(zapcore.NewTee(cores...))
+	l := &AlarmLogger{
+		logger:          logger,
+		sink:            MultiSink(append([]Sink{&logSink{logger: logger}}, o.sinks...)...),
+		registry:        o.registry,
+		catalogue:       o.catalogue,
+		cataloguePolicy: o.cataloguePolicy,
+		errorHandler:    o.errorHandler,
+		store:           o.store,
+		stats:           &Stats{},
+		files:           o.files,
+	}
+	l.damper = newDamper(l.emit)
+	l.escalator = newEscalator(l.catalogue, l.escalate)
+	if nil == l.errorHandler {
+		l.errorHandler = func(error) {}
+	}
+	if o.rateBurst > 0 {
+		l.sink = newRateLimitSink(l.sink, o.rateInterval, o.rateBurst, &l.stats.RateLimited)
+	}
+	if o.queueSize > 0 {
+		l.async = newAsyncSink(l.sink, o.queueSize, l.errorHandler, &l.stats.Dropped)
+		l.sink = l.async
+	}
+	l.restore()
+	return l, nil
+}
+
+// MustNew is like New but panics if the AlarmLogger cannot be created. It is intended for
+// fixed configurations, e.g. in tests.
+func MustNew(opts ...Option) *AlarmLogger {
+	l, err := New(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// restore loads the active alarms of the store into the registry and restarts their escalation
+func (l *AlarmLogger) restore() {
+	if nil == l.store {
+		return
+	}
+	alarms, err := l.store.Load()
+	if err != nil {
+		l.errorHandler(fmt.Errorf("failed to restore the active alarms: %w", err))
+		return
+	}
+	l.registry.restore(alarms)
+	for i := range alarms {
+		l.escalator.observe(alarms[i].LogType, &alarms[i].Alarm)
+	}
+}
+
+// persist saves the active alarms of the registry to the store. With WithAsync the store is saved by the
+// background goroutine, a queued save covers the changes made until it runs.
+func (l *AlarmLogger) persist() {
+	if nil == l.store {
+		return
+	}
+	if nil != l.async {
+		if !atomic.CompareAndSwapInt32(&l.savePending, 0, 1) {
+			return
+		}
+		if err := l.async.do(l.save); err == nil {
+			return
+		}
+	}
+	l.save()
+}
+
+// save writes the active alarms of the registry to the store
+func (l *AlarmLogger) save() {
+	atomic.StoreInt32(&l.savePending, 0)
+	l.storeMu.Lock()
+	defer l.storeMu.Unlock()
+
+	if err := l.store.Save(l.registry.ActiveAlarms()); err != nil {
+		l.errorHandler(fmt.Errorf("failed to persist the active alarms: %w", err))
+	}
+}
+
+func encoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:    "ts",
+		EncodeTime: zapcore.ISO8601TimeEncoder,
+		LineEnding: zapcore.DefaultLineEnding,
+	}
+}
+
+// Raise produces alarm log with state 1, unless the alarm is already active in the registry.
+// Raise and clear of the alarms having damping configured in the catalogue may be delayed or suppressed.
+// Returns an error only if the alarm has been rejected by the catalogue.
+func (l *AlarmLogger) Raise(logtype LogType, alarm *AlarmDetails) error {
+	def, err := l.checkCatalogue(alarm)
+	if err != nil {
+		return err
+	}
 	//check if state is correct
 	if 0 == len(alarm.Visibility) {
 		alarm.Visibility = Global
 	}
-	alarm.State = 1
-	print(logtype, alarm)
+	alarm.State = StateRaised
+	if nil != def && def.isDamped() {
+		l.damper.request(logtype, alarm, def)
+		return nil
+	}
+	l.emit(logtype, alarm)
+	return nil
 }
 
-// ClearAlarm produces alarm log with severity CLEARED and state 0
-func ClearAlarm(logtype LogType, alarm *AlarmDetails) {
+// Clear produces alarm log with state 0, unless the alarm is not active in the registry.
+// Returns an error only if the alarm has been rejected by the catalogue.
+func (l *AlarmLogger) Clear(logtype LogType, alarm *AlarmDetails) error {
+	def, err := l.checkCatalogue(alarm)
+	if err != nil {
+		return err
+	}
 	//check if state is correct
-	alarm.State = 0
-	print(logtype, alarm)
+	alarm.State = StateCleared
+	if nil != def && def.isDamped() {
+		l.damper.request(logtype, alarm, def)
+		return nil
+	}
+	l.emit(logtype, alarm)
+	return nil
 }
 
-func print(logtype LogType, alarm *AlarmDetails) {
-	logger.Info("", zap.String("log_type", string(logtype)), zap.Object("alarm", alarm))
+// Update produces alarm log with state 2 for an active alarm, e.g. to change its severity or text without
+// clearing it. Returns ErrAlarmNotActive if the alarm is not raised according to the registry, or for damped
+// alarms according to the damping, and an error if the alarm has been rejected by the catalogue.
+func (l *AlarmLogger) Update(logtype LogType, alarm *AlarmDetails) error {
+	def, err := l.checkCatalogue(alarm)
+	if err != nil {
+		return err
+	}
+	if 0 == len(alarm.Visibility) {
+		alarm.Visibility = Global
+	}
+	alarm.State = StateUpdated
+	if nil != def && def.isDamped() {
+		if !l.damper.update(logtype, alarm) {
+			return fmt.Errorf("%w: %v", ErrAlarmNotActive, alarm.Name)
+		}
+		return nil
+	}
+	if nil != l.registry && !l.registry.IsActive(logtype, alarm) {
+		return fmt.Errorf("%w: %v", ErrAlarmNotActive, alarm.Name)
+	}
+	l.emit(logtype, alarm)
+	return nil
+}
+
+// checkCatalogue completes the alarm and returns its definition, if the alarm is in the catalogue
+func (l *AlarmLogger) checkCatalogue(alarm *AlarmDetails) (*AlarmDefinition, error) {
+	if nil == l.catalogue {
+		return nil, nil
+	}
+	err := l.catalogue.Complete(alarm)
+	if err == nil {
+		def, _ := l.catalogue.Lookup(alarm.Name)
+		return &def, nil
+	}
+	if l.cataloguePolicy == RejectOnMismatch {
+		return nil, err
+	}
+	l.errorHandler(err)
+	return nil, nil
+}
+
+// emit logs the alarm unless the registry suppresses it, then (re)schedules its escalation
+func (l *AlarmLogger) emit(logtype LogType, alarm *AlarmDetails) {
+	if nil != l.registry {
+		switch alarm.State {
+		case StateCleared:
+			if !l.registry.clear(logtype, alarm) {
+				return
+			}
+		case StateUpdated:
+			if !l.registry.update(logtype, alarm, time.Now()) {
+				return
+			}
+		default:
+			if !l.registry.raise(logtype, alarm, time.Now()) {
+				return
+			}
+		}
+		l.persist()
+	}
+	l.print(logtype, alarm)
+	l.escalator.observe(logtype, alarm)
 }
 
-// ==============================
-// InitLogger is only for testing
+// escalate emits the escalated severity of the alarm. A damped alarm is escalated through the damper,
+// so its later emissions keep the escalated severity.
+func (l *AlarmLogger) escalate(logtype LogType, alarm *AlarmDetails) {
+	if l.damper.escalate(logtype, alarm) {
+		return
+	}
+	l.emit(logtype, alarm)
+}
+
+// IsActive reports whether the alarm is raised. Always false without a registry.
+func (l *AlarmLogger) IsActive(logtype LogType, alarm *AlarmDetails) bool {
+	if nil == l.registry {
+		return false
+	}
+	return l.registry.IsActive(logtype, alarm)
+}
+
+// ActiveAlarms returns the currently raised alarms. Always empty without a registry.
+func (l *AlarmLogger) ActiveAlarms() []ActiveAlarm {
+	if nil == l.registry {
+		return nil
+	}
+	return l.registry.ActiveAlarms()
+}
+
+// Resync emits the raise of every active alarm again with its latest details, e.g. when the
+// receiver of the alarms has lost its state. Does nothing without a registry.
+func (l *AlarmLogger) Resync() {
+	for _, active := range l.ActiveAlarms() {
+		alarm := active.Alarm
+		alarm.State = StateRaised
+		l.print(active.LogType, &alarm)
+	}
+}
+
+// Stats returns the number of the alarm log entries dropped by the queue and the rate limit
+func (l *AlarmLogger) Stats() Stats {
+	return Stats{
+		Dropped:     atomic.LoadUint64(&l.stats.Dropped),
+		RateLimited: atomic.LoadUint64(&l.stats.RateLimited),
+	}
+}
+
+// Flush waits until the alarm log entries queued by WithAsync are written to the outputs, or the context
+// is done. Returns immediately without WithAsync.
+func (l *AlarmLogger) Flush(ctx context.Context) error {
+	if nil == l.async {
+		return nil
+	}
+	return l.async.flush(ctx)
+}
+
+// Sync flushes any buffered alarm log
+func (l *AlarmLogger) Sync() error {
+	return l.logger.Sync()
+}
+
+// Close stops the pending delayed raises, clears and escalations, writes the queued alarm log entries,
+// then closes the sinks implementing io.Closer and the files opened by WithFile. Calling it again
+// returns the result of the first call.
+func (l *AlarmLogger) Close() error {
+	l.closeOnce.Do(func() {
+		l.damper.stop()
+		l.escalator.stop()
+		var err error
+		if closer, ok := l.sink.(io.Closer); ok {
+			err = closer.Close()
+		}
+		// the files are written by the log sink, so they are closed after the queued entries
+		for _, f := range l.files {
+			err = multierr.Append(err, f.Close())
+		}
+		l.closeErr = err
+	})
+	return l.closeErr
+}
+
+func (l *AlarmLogger) print(logtype LogType, alarm *AlarmDetails) {
+	record := &Record{
+		Time:    time.Now(),
+		LogType: logtype,
+		Alarm:   alarm.clone(),
+	}
+	if err := l.sink.Write(record); err != nil {
+		l.errorHandler(err)
+	}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = MustNew()
+)
+
+// Default returns the AlarmLogger used by the package level functions
+func Default() *AlarmLogger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the AlarmLogger used by the package level functions
+func SetDefault(l *AlarmLogger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// RaiseAlarm produces alarm log with state 1 using the default AlarmLogger
+func RaiseAlarm(logtype LogType, alarm *AlarmDetails) {
+	Default().Raise(logtype, alarm)
+}
+
+// ClearAlarm produces alarm log with state 0 using the default AlarmLogger
+func ClearAlarm(logtype LogType, alarm *AlarmDetails) {
+	Default().Clear(logtype, alarm)
+}
+
+// UpdateAlarm produces alarm log with state 2 for an active alarm using the default AlarmLogger
+func UpdateAlarm(logtype LogType, alarm *AlarmDetails) {
+	Default().Update(logtype, alarm)
+}
+
+// Resync emits the raise of every active alarm again using the default AlarmLogger
+func Resync() {
+	Default().Resync()
+}
+
+// InitLogger resets the default AlarmLogger to write to the current os.Stderr.
+//
+// Deprecated: create a dedicated instance with New and SetDefault instead.
 func InitLogger() error {
-	cfg := zap.Config{
-		Encoding:         "json",
-		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
-		DisableCaller:    true,
-		OutputPaths:      []string{"stderr"},
-		ErrorOutputPaths: []string{"stderr"},
-		EncoderConfig: zapcore.EncoderConfig{
-			TimeKey:    "ts",
-			EncodeTime: zapcore.ISO8601TimeEncoder,
-			LineEnding: zapcore.DefaultLineEnding,
-		},
-	}
-
-	logr, err := cfg.Build()
-	if nil != err {
+	l, err := New(WithWriter(os.Stderr))
+	if err != nil {
 		return err
 	}
-
-	logger = logr
+	SetDefault(l)
 	return nil
 }
@@ -5,11 +5,15 @@
 package alarmlogger_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	alog "github.com/nokia/industrial-application-framework/alarmlogger"
 )
@@ -43,133 +47,280 @@ func toJSONString(payload interface{}) string {
 
 }
 
+// useBufferAsDefault redirects the package level functions to a buffer for the
+// duration of the test
+func useBufferAsDefault(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	prev := alog.Default()
+	alog.SetDefault(alog.MustNew(alog.WithWriter(buf)))
+	t.Cleanup(func() {
+		alog.SetDefault(prev)
+	})
+	return buf
+}
+
 func TestRaiseAlarm(t *testing.T) {
-	osStderr := os.Stderr
-	r, w, _ := os.Pipe()
-	os.Stderr = w
+	buf := useBufferAsDefault(t)
 
 	expectedLog := &completeLog{
 		LogType: alog.AppAlarm,
 		Alarm: &alog.AlarmDetails{
-			State: 1,
+			State:      1,
 			Visibility: alog.Global,
 		},
 	}
 
-	alog.InitLogger()
 	alog.RaiseAlarm(alog.AppAlarm, &alog.AlarmDetails{})
-	w.Close()
-
-	alarmLog, _ := ioutil.ReadAll(r)
-	os.Stderr = osStderr
 
-	println("alarmlog = " + string(alarmLog))
+	t.Logf("alarmlog = %s", buf.String())
 
-	if !validateLogs(alarmLog, expectedLog) {
+	if !validateLogs(buf.Bytes(), expectedLog) {
 		t.Errorf("TestRaiseAlarm failed")
 	}
 }
 
 func TestRaiseAlarm2(t *testing.T) {
-	osStderr := os.Stderr
-	r, w, _ := os.Pipe()
-	os.Stderr = w
+	buf := useBufferAsDefault(t)
 
 	expectedLog := &completeLog{
 		LogType: alog.AppAlarm,
 		Alarm: &alog.AlarmDetails{
-			Name: "AppNotRunning",
-			ID: "1",
-			Severity: alog.Warning,
-			Text: "any",
-			State: 1,
+			Name:       "AppNotRunning",
+			ID:         "1",
+			Severity:   alog.Warning,
+			Text:       "any",
+			State:      1,
 			Visibility: alog.Operations,
-			SubDN: "/MODULE-servicesubmodule",
+			SubDN:      "/MODULE-servicesubmodule",
 		},
 	}
 
-	alog.InitLogger()
 	alog.RaiseAlarm(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning",
-		ID: "1",
-		Text: "any",
-		Severity: alog.Warning,
-		State: 1,
+		ID:         "1",
+		Text:       "any",
+		Severity:   alog.Warning,
+		State:      1,
 		Visibility: alog.Operations,
-		SubDN: "/MODULE-servicesubmodule",
+		SubDN:      "/MODULE-servicesubmodule",
 	})
-	w.Close()
-
-	alarmLog, _ := ioutil.ReadAll(r)
-	os.Stderr = osStderr
 
-	println("alarmlog = " + string(alarmLog))
+	t.Logf("alarmlog = %s", buf.String())
 
-	if !validateLogs(alarmLog, expectedLog) {
+	if !validateLogs(buf.Bytes(), expectedLog) {
 		t.Errorf("TestRaiseAlarm failed")
 	}
 }
 
 func TestClearAlarm(t *testing.T) {
-	osStderr := os.Stderr
-	r, w, _ := os.Pipe()
-	os.Stderr = w
+	buf := useBufferAsDefault(t)
 
 	expectedLog := &completeLog{
 		LogType: alog.AppAlarm,
 		Alarm: &alog.AlarmDetails{
-			State:    0,
+			State: 0,
 		},
 	}
 
-	alog.InitLogger()
 	alog.ClearAlarm(alog.AppAlarm, &alog.AlarmDetails{})
-	w.Close()
 
-	alarmLog, _ := ioutil.ReadAll(r)
-	os.Stderr = osStderr
+	t.Logf("alarmlog = %s", buf.String())
 
-	println("alarmlog = " + string(alarmLog))
-
-	if !validateLogs(alarmLog, expectedLog) {
+	if !validateLogs(buf.Bytes(), expectedLog) {
 		t.Errorf("TestClearAlarm failed")
 	}
 }
 
 func TestClearAlarm2(t *testing.T) {
-	osStderr := os.Stderr
-	r, w, _ := os.Pipe()
-	os.Stderr = w
+	buf := useBufferAsDefault(t)
 
 	expectedLog := &completeLog{
 		LogType: alog.AppAlarm,
 		Alarm: &alog.AlarmDetails{
-			Name: "AppNotRunning",
-			ID: "1",
-			Severity: alog.Warning,
-			Text: "any",
-			State: 0,
+			Name:       "AppNotRunning",
+			ID:         "1",
+			Severity:   alog.Warning,
+			Text:       "any",
+			State:      0,
 			Visibility: alog.Operations,
-			SubDN: "/MODULE-servicesubmodule",
+			SubDN:      "/MODULE-servicesubmodule",
 		},
 	}
 
-	alog.InitLogger()
 	alog.ClearAlarm(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning",
-		ID: "1",
-		Text: "any",
-		Severity: alog.Warning,
-		State: 0,
+		ID:         "1",
+		Text:       "any",
+		Severity:   alog.Warning,
+		State:      0,
 		Visibility: alog.Operations,
-		SubDN: "/MODULE-servicesubmodule",
+		SubDN:      "/MODULE-servicesubmodule",
 	})
+
+	t.Logf("alarmlog = %s", buf.String())
+
+	if !validateLogs(buf.Bytes(), expectedLog) {
+		t.Errorf("TestClearAlarm failed")
+	}
+}
+
+func TestRaiseAlarmWithAdditionalFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	eventTime := time.Date(2020, 6, 22, 4, 47, 22, 637000000, time.UTC)
+
+	alog.MustNew(alog.WithWriter(buf)).Raise(alog.AppAlarm, &alog.AlarmDetails{
+		Name:            "AppNotRunning",
+		ID:              "1",
+		Severity:        alog.Warning,
+		Text:            "any",
+		ProbableCause:   "softwareError",
+		SpecificProblem: "Pod example-consul-0 is not ready",
+		AdditionalInfo:  map[string]string{"pod": "example-consul-0", "namespace": "app"},
+		EventTime:       &eventTime,
+		NotificationID:  "42",
+		CorrelationID:   "7",
+	})
+
+	expectedLog := &completeLog{
+		LogType: alog.AppAlarm,
+		Alarm: &alog.AlarmDetails{
+			Name:            "AppNotRunning",
+			ID:              "1",
+			Severity:        alog.Warning,
+			Text:            "any",
+			State:           1,
+			Visibility:      alog.Global,
+			ProbableCause:   "softwareError",
+			SpecificProblem: "Pod example-consul-0 is not ready",
+			AdditionalInfo:  map[string]string{"pod": "example-consul-0", "namespace": "app"},
+			EventTime:       &eventTime,
+			NotificationID:  "42",
+			CorrelationID:   "7",
+		},
+	}
+	if !validateLogs(buf.Bytes(), expectedLog) {
+		t.Errorf("TestRaiseAlarmWithAdditionalFields failed")
+	}
+	if !strings.Contains(buf.String(), `"additionalinfo":{"namespace":"app","pod":"example-consul-0"},"eventtime":"2020-06-22T04:47:22.637Z"`) {
+		t.Errorf("unexpected alarm log %q", buf.String())
+	}
+}
+
+func TestOptionalFieldsOmitted(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	alog.MustNew(alog.WithWriter(buf)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, Text: "any"})
+
+	expected := `"alarm":{"name":"AppNotRunning","id":"1","severity":"WARNING","text":"any","state":1,"visibility":"GLOBAL"}}`
+	if !strings.HasSuffix(strings.TrimSpace(buf.String()), expected) {
+		t.Errorf("expected alarm log ending with %q, got %q", expected, buf.String())
+	}
+}
+
+func TestInstancesWriteToOwnOutputs(t *testing.T) {
+	first := &bytes.Buffer{}
+	second := &bytes.Buffer{}
+
+	alog.MustNew(alog.WithWriter(first)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "First"})
+	alog.MustNew(alog.WithWriter(second)).Clear(alog.AppFwAlarm, &alog.AlarmDetails{Name: "Second"})
+
+	if !validateLogs(first.Bytes(), &completeLog{
+		LogType: alog.AppAlarm,
+		Alarm:   &alog.AlarmDetails{Name: "First", State: 1, Visibility: alog.Global},
+	}) {
+		t.Errorf("first instance output is wrong")
+	}
+	if !validateLogs(second.Bytes(), &completeLog{
+		LogType: alog.AppFwAlarm,
+		Alarm:   &alog.AlarmDetails{Name: "Second", State: 0},
+	}) {
+		t.Errorf("second instance output is wrong")
+	}
+}
+
+func TestMultipleWriters(t *testing.T) {
+	first := &bytes.Buffer{}
+	second := &bytes.Buffer{}
+
+	alog.MustNew(alog.WithWriter(first), alog.WithWriter(second)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "Both"})
+
+	if first.String() == "" || first.String() != second.String() {
+		t.Errorf("expected the same alarm log on both writers, got %q and %q", first.String(), second.String())
+	}
+}
+
+func TestWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.log")
+
+	l := alog.MustNew(alog.WithFile(path))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "First"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "First"})
+	l.Sync()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the alarm log file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 alarm log lines, got %d: %q", len(lines), content)
+	}
+	if !validateLogs([]byte(lines[1]), &completeLog{
+		LogType: alog.AppAlarm,
+		Alarm:   &alog.AlarmDetails{Name: "First", State: 0},
+	}) {
+		t.Errorf("TestWithFile failed")
+	}
+}
+
+func TestWithFileInvalidPath(t *testing.T) {
+	l, err := alog.New(alog.WithFile(filepath.Join(t.TempDir(), "missing", "alarms.log")))
+	if err == nil || l != nil {
+		t.Errorf("expected New to fail on an unusable file, got %v", err)
+	}
+}
+
+func TestCloseClosesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.log")
+
+	l, err := alog.New(alog.WithFile(path), alog.WithAsync(10))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "First"})
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error on close: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("closing again should return the result of the first close, got %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the alarm log file: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 1 {
+		t.Errorf("expected the queued alarm log line to be written before the file is closed, got %q", content)
+	}
+}
+
+func TestInitLogger(t *testing.T) {
+	prev := alog.Default()
+	defer alog.SetDefault(prev)
+
+	osStderr := os.Stderr
+	r, w, _ := os.Pipe()
+	os.Stderr = w
+
+	alog.InitLogger()
+	alog.RaiseAlarm(alog.AppAlarm, &alog.AlarmDetails{})
 	w.Close()
 
 	alarmLog, _ := ioutil.ReadAll(r)
 	os.Stderr = osStderr
 
-	println("alarmlog = " + string(alarmLog))
-
-	if !validateLogs(alarmLog, expectedLog) {
-		t.Errorf("TestClearAlarm failed")
+	if !validateLogs(alarmLog, &completeLog{
+		LogType: alog.AppAlarm,
+		Alarm:   &alog.AlarmDetails{State: 1, Visibility: alog.Global},
+	}) {
+		t.Errorf("TestInitLogger failed")
 	}
 }
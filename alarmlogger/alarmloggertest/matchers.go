@@ -0,0 +1,114 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmloggertest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/onsi/gomega/format"
+	"github.com/onsi/gomega/types"
+)
+
+// HaveRaisedAlarm succeeds if an alarm with the given name has been raised, or updated, with the given severity.
+// The actual value can be a *Recorder, a []alarmlogger.Record, or the alarm log as a string or []byte, e.g.
+//
+//	Eventually(recorder).Should(alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Warning))
+//	Eventually(getPodLogs).Should(alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Warning))
+func HaveRaisedAlarm(name string, severity alarmlogger.AlarmSeverity) types.GomegaMatcher {
+	return &alarmMatcher{
+		description: fmt.Sprintf("to have raised alarm %v with severity %v", name, severity),
+		match: func(records []alarmlogger.Record) bool {
+			for _, record := range records {
+				if record.Alarm.Name == name && record.Alarm.State != alarmlogger.StateCleared && record.Alarm.Severity == severity {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// HaveClearedAlarm succeeds if an alarm with the given name has been cleared
+func HaveClearedAlarm(name string) types.GomegaMatcher {
+	return &alarmMatcher{
+		description: fmt.Sprintf("to have cleared alarm %v", name),
+		match: func(records []alarmlogger.Record) bool {
+			for _, record := range records {
+				if record.Alarm.Name == name && record.Alarm.State == alarmlogger.StateCleared {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// HaveActiveAlarm succeeds if the last entry of an alarm with the given name is a raise or an update
+func HaveActiveAlarm(name string) types.GomegaMatcher {
+	return &alarmMatcher{
+		description: fmt.Sprintf("to have active alarm %v", name),
+		match: func(records []alarmlogger.Record) bool {
+			for i := len(records) - 1; i >= 0; i-- {
+				if records[i].Alarm.Name == name {
+					return records[i].Alarm.State != alarmlogger.StateCleared
+				}
+			}
+			return false
+		},
+	}
+}
+
+type alarmMatcher struct {
+	description string
+	match       func([]alarmlogger.Record) bool
+	records     []alarmlogger.Record
+}
+
+func (m *alarmMatcher) Match(actual interface{}) (bool, error) {
+	records, err := toRecords(actual)
+	if err != nil {
+		return false, err
+	}
+	m.records = records
+	return m.match(records), nil
+}
+
+func (m *alarmMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected alarms\n%v\n%v", summary(m.records), m.description)
+}
+
+func (m *alarmMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected alarms\n%v\nnot %v", summary(m.records), m.description)
+}
+
+func toRecords(actual interface{}) ([]alarmlogger.Record, error) {
+	switch actual := actual.(type) {
+	case *Recorder:
+		return actual.Records(), nil
+	case []alarmlogger.Record:
+		return actual, nil
+	case string:
+		return ParseRecords(strings.NewReader(actual))
+	case []byte:
+		return ParseRecords(bytes.NewReader(actual))
+	default:
+		return nil, fmt.Errorf("alarm matcher expects a *Recorder, []alarmlogger.Record, string or []byte, got:\n%v", format.Object(actual, 1))
+	}
+}
+
+func summary(records []alarmlogger.Record) string {
+	if len(records) == 0 {
+		return "    <none>"
+	}
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		lines = append(lines, fmt.Sprintf("    %v %v (id: %v, severity: %v, state: %v): %v",
+			record.LogType, record.Alarm.Name, record.Alarm.ID, record.Alarm.Severity, record.Alarm.State, record.Alarm.Text))
+	}
+	return strings.Join(lines, "\n")
+}
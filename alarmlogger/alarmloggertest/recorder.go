@@ -0,0 +1,100 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package alarmloggertest provides utilities to verify the alarms raised by an application:
+// an in-memory recorder sink, a parser of the alarm log and gomega matchers.
+package alarmloggertest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+// Recorder is an alarm sink keeping the records in memory. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	records []alarmlogger.Record
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Write(record *alarmlogger.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := *record
+	if record.Alarm.AdditionalInfo != nil {
+		out.Alarm.AdditionalInfo = make(map[string]string, len(record.Alarm.AdditionalInfo))
+		for key, value := range record.Alarm.AdditionalInfo {
+			out.Alarm.AdditionalInfo[key] = value
+		}
+	}
+	r.records = append(r.records, out)
+	return nil
+}
+
+// Records returns the recorded alarm log entries in the order of their emission
+func (r *Recorder) Records() []alarmlogger.Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]alarmlogger.Record(nil), r.records...)
+}
+
+// Reset forgets the recorded entries
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+// SetDefault replaces the default AlarmLogger with one recording the alarms and discarding the alarm log.
+// The returned function restores the previous default AlarmLogger.
+func SetDefault(opts ...alarmlogger.Option) (*Recorder, func()) {
+	recorder := NewRecorder()
+	previous := alarmlogger.Default()
+	opts = append([]alarmlogger.Option{alarmlogger.WithWriter(ioutil.Discard), alarmlogger.WithSink(recorder)}, opts...)
+	alarmlogger.SetDefault(alarmlogger.MustNew(opts...))
+	return recorder, func() {
+		alarmlogger.SetDefault(previous)
+	}
+}
+
+type logLine struct {
+	Time    string                   `json:"ts"`
+	LogType alarmlogger.LogType      `json:"log_type"`
+	Alarm   alarmlogger.AlarmDetails `json:"alarm"`
+}
+
+// timeLayout is the format of the ts field written by the zap ISO8601 time encoder
+const timeLayout = "2006-01-02T15:04:05.000Z0700"
+
+// ParseRecords reads the alarm log entries from the JSON lines of r, e.g. from the logs of a pod.
+// Lines which are not alarm log entries are skipped.
+func ParseRecords(r io.Reader) ([]alarmlogger.Record, error) {
+	var records []alarmlogger.Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var entry logLine
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.LogType == "" || entry.Alarm.Name == "" {
+			continue
+		}
+		ts, _ := time.Parse(timeLayout, entry.Time)
+		records = append(records, alarmlogger.Record{Time: ts, LogType: entry.LogType, Alarm: entry.Alarm})
+	}
+	return records, scanner.Err()
+}
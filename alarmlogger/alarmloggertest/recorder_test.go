@@ -0,0 +1,88 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmloggertest_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/nokia/industrial-application-framework/alarmlogger/alarmloggertest"
+	. "github.com/onsi/gomega"
+)
+
+func TestRecorder(t *testing.T) {
+	g := NewWithT(t)
+	recorder, restore := alarmloggertest.SetDefault()
+	defer restore()
+
+	alarmlogger.RaiseAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+	g.Expect(recorder).To(alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Warning))
+	g.Expect(recorder).To(alarmloggertest.HaveActiveAlarm("AppNotRunning"))
+	g.Expect(recorder).NotTo(alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Major))
+	g.Expect(recorder).NotTo(alarmloggertest.HaveClearedAlarm("AppNotRunning"))
+
+	alarmlogger.UpdateAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Major})
+	g.Expect(recorder).To(alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Major))
+
+	alarmlogger.ClearAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+	g.Expect(recorder).To(alarmloggertest.HaveClearedAlarm("AppNotRunning"))
+	g.Expect(recorder).NotTo(alarmloggertest.HaveActiveAlarm("AppNotRunning"))
+	g.Expect(recorder.Records()).To(HaveLen(3))
+
+	recorder.Reset()
+	g.Expect(recorder.Records()).To(BeEmpty())
+}
+
+func TestRestoreDefault(t *testing.T) {
+	previous := alarmlogger.Default()
+	_, restore := alarmloggertest.SetDefault()
+	if alarmlogger.Default() == previous {
+		t.Errorf("default logger should be replaced")
+	}
+	restore()
+	if alarmlogger.Default() != previous {
+		t.Errorf("default logger should be restored")
+	}
+}
+
+func TestMatchAlarmLog(t *testing.T) {
+	g := NewWithT(t)
+	buf := &bytes.Buffer{}
+	buf.WriteString("2020-06-22T06:47:22.000Z INFO controller starting\n")
+	l := alarmlogger.MustNew(alarmlogger.WithWriter(buf))
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning, Text: "Not all components are ready"})
+	buf.WriteString(`{"level":"info","msg":"reconciled"}` + "\n")
+	l.Clear(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+
+	records, err := alarmloggertest.ParseRecords(strings.NewReader(buf.String()))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(records).To(HaveLen(2))
+	g.Expect(records[0].LogType).To(Equal(alarmlogger.AppAlarm))
+	g.Expect(records[0].Alarm.Text).To(Equal("Not all components are ready"))
+	g.Expect(records[0].Time.IsZero()).To(BeFalse())
+
+	g.Expect(buf.String()).To(alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Warning))
+	g.Expect(buf.Bytes()).To(alarmloggertest.HaveClearedAlarm("AppNotRunning"))
+}
+
+func TestMatcherFailureMessage(t *testing.T) {
+	recorder := alarmloggertest.NewRecorder()
+	recorder.Write(&alarmlogger.Record{LogType: alarmlogger.AppAlarm, Alarm: alarmlogger.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alarmlogger.Major, State: alarmlogger.StateRaised}})
+
+	matcher := alarmloggertest.HaveRaisedAlarm("AppNotRunning", alarmlogger.Warning)
+	if success, err := matcher.Match(recorder); success || err != nil {
+		t.Fatalf("unexpected match result %v, %v", success, err)
+	}
+	message := matcher.FailureMessage(recorder)
+	if !strings.Contains(message, "LicenceExpired (id: 2, severity: MAJOR, state: 1)") || !strings.Contains(message, "AppNotRunning with severity WARNING") {
+		t.Errorf("unexpected failure message %q", message)
+	}
+
+	if _, err := matcher.Match(42); err == nil {
+		t.Errorf("expected error for unsupported actual value")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrClosed is returned when writing to a closed AlarmLogger
+	ErrClosed = errors.New("alarm logger is closed")
+	// ErrQueueFull is reported to the error handler when an alarm log entry is dropped because the asynchronous
+	// queue is full
+	ErrQueueFull = errors.New("alarm queue is full")
+)
+
+// Stats counts the alarm log entries which have not been written to the sinks
+type Stats struct {
+	// Dropped counts the entries dropped because the asynchronous queue was full, or replaced by a later
+	// entry of the same alarm before they were written
+	Dropped uint64
+	// RateLimited counts the entries dropped by the rate limit
+	RateLimited uint64
+}
+
+// queued is an entry of the asynchronous queue, either a record, a task or a flush request
+type queued struct {
+	record *Record
+	key    AlarmKey
+	task   func()
+	flush  chan struct{}
+}
+
+// asyncSink writes the records to the underlying sink from a background goroutine. The callers are never
+// blocked and the queue never holds more records than its size: while the queue is full, a record is merged into
+// the last queued record of the same alarm, so the writer drains the latest state of the alarm. The updates
+// (state 2) of an alarm without a queued record are dropped, its raise or clear takes the place of the oldest
+// queued update or raise which is cleared later in the queue. A raise without a later clear and the clears are
+// never evicted, the new record is dropped and reported to the error handler instead.
+type asyncSink struct {
+	sink    Sink
+	size    int
+	onError func(error)
+	dropped *uint64
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []*queued
+	last   map[AlarmKey]*queued
+	closed bool
+	done   chan struct{}
+}
+
+func newAsyncSink(sink Sink, size int, onError func(error), dropped *uint64) *asyncSink {
+	s := &asyncSink{
+		sink:    sink,
+		size:    size,
+		onError: onError,
+		dropped: dropped,
+		last:    make(map[AlarmKey]*queued),
+		done:    make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.run()
+	return s
+}
+
+func (s *asyncSink) run() {
+	defer close(s.done)
+	for {
+		item, ok := s.next()
+		if !ok {
+			return
+		}
+		switch {
+		case item.flush != nil:
+			close(item.flush)
+		case item.task != nil:
+			item.task()
+		default:
+			if err := s.sink.Write(item.record); err != nil {
+				s.onError(err)
+			}
+		}
+	}
+}
+
+// next waits for the next entry of the queue, returns false when the sink is closed and the queue is drained.
+// The returned record is not merged any more.
+func (s *asyncSink) next() (queued, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.items) == 0 {
+		if s.closed {
+			return queued{}, false
+		}
+		s.cond.Wait()
+	}
+	item := s.items[0]
+	s.items[0] = nil
+	s.items = s.items[1:]
+	if item.record != nil && s.last[item.key] == item {
+		delete(s.last, item.key)
+	}
+	return *item, true
+}
+
+// enqueue appends the entry to the queue, the caller holds the lock
+func (s *asyncSink) enqueue(item *queued) {
+	s.items = append(s.items, item)
+	s.cond.Signal()
+}
+
+func (s *asyncSink) Write(record *Record) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	key := KeyOf(record.LogType, &record.Alarm)
+	if len(s.items) >= s.size {
+		if item, found := s.last[key]; found {
+			s.merge(item, record)
+			s.mu.Unlock()
+			return nil
+		}
+		if record.Alarm.State == StateUpdated || !s.evict() {
+			atomic.AddUint64(s.dropped, 1)
+			s.mu.Unlock()
+			s.onError(fmt.Errorf("%w: dropped the entry of %v in state %d", ErrQueueFull, record.Alarm.Name, record.Alarm.State))
+			return nil
+		}
+	}
+	item := &queued{record: record, key: key}
+	s.last[key] = item
+	s.enqueue(item)
+	s.mu.Unlock()
+	return nil
+}
+
+// evict drops the oldest queued update, or raise followed by a queued clear of its alarm, to make room for a
+// record. Reports false if the queue holds no such record, the other raises and the clears are never evicted. The
+// caller holds the lock.
+func (s *asyncSink) evict() bool {
+	for i, item := range s.items {
+		if item.record == nil || item.record.Alarm.State == StateCleared {
+			continue
+		}
+		if item.record.Alarm.State == StateRaised && !s.clearedLater(i) {
+			continue
+		}
+		if s.last[item.key] == item {
+			delete(s.last, item.key)
+		}
+		copy(s.items[i:], s.items[i+1:])
+		s.items[len(s.items)-1] = nil
+		s.items = s.items[:len(s.items)-1]
+		atomic.AddUint64(s.dropped, 1)
+		return true
+	}
+	return false
+}
+
+// clearedLater reports whether the alarm of the queued record at the index is cleared by a later queued record.
+// The caller holds the lock.
+func (s *asyncSink) clearedLater(index int) bool {
+	key := s.items[index].key
+	for _, item := range s.items[index+1:] {
+		if item.record != nil && item.key == key && item.record.Alarm.State == StateCleared {
+			return true
+		}
+	}
+	return false
+}
+
+// merge replaces the queued record of the alarm with the later record. A raise or clear replaces the queued
+// record, an update is merged into a queued raise keeping its state and dropped after a queued clear.
+func (s *asyncSink) merge(item *queued, record *Record) {
+	switch {
+	case record.Alarm.State != StateUpdated:
+		if item.record.Alarm.State == StateUpdated {
+			atomic.AddUint64(s.dropped, 1)
+		}
+		item.record = record
+	case item.record.Alarm.State == StateRaised:
+		merged := *record
+		merged.Time = item.record.Time
+		merged.Alarm.State = StateRaised
+		item.record = &merged
+	default:
+		atomic.AddUint64(s.dropped, 1)
+		if item.record.Alarm.State == StateUpdated {
+			item.record = record
+		}
+	}
+}
+
+// do runs the task on the background goroutine, after the records queued before it. Returns ErrClosed if the
+// sink is closed.
+func (s *asyncSink) do(task func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	s.enqueue(&queued{task: task})
+	return nil
+}
+
+// flush waits until the records queued before the call are written to the sink
+func (s *asyncSink) flush(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	s.enqueue(&queued{flush: done})
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close writes the queued records, then closes the underlying sink
+func (s *asyncSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	<-s.done
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestAsyncQueueBound(t *testing.T) {
+	var dropped uint64
+	reported := 0
+	// the queue is not drained, there is no writer goroutine
+	s := newTestAsyncSink(3, &dropped, func(error) {
+		reported++
+	})
+	record := func(id string, state int) *Record {
+		return &Record{LogType: AppAlarm, Alarm: AlarmDetails{Name: "AppNotRunning", ID: id, State: state}}
+	}
+
+	written := 0
+	for i := 0; i < 10; i++ {
+		for _, state := range []int{StateRaised, StateUpdated, StateCleared} {
+			if err := s.Write(record(fmt.Sprint(i), state)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			written++
+			if len(s.items) > s.size {
+				t.Fatalf("expected at most %d queued entries, got %d", s.size, len(s.items))
+			}
+		}
+	}
+	if len(s.last) > len(s.items) {
+		t.Errorf("expected only the queued alarms to be tracked, got %d alarms for %d entries", len(s.last), len(s.items))
+	}
+
+	// the raises followed by their clears and the updates are evicted or replaced by the clears, once the queue
+	// holds clears only the entries of the later alarms are dropped and reported
+	for i, item := range s.items {
+		if item.record.Alarm.State != StateCleared || item.record.Alarm.ID != fmt.Sprint(i) {
+			t.Errorf("expected the clear of %d at %d, got %+v", i, i, item.record.Alarm)
+		}
+	}
+	if int(dropped)+len(s.items) > written {
+		t.Errorf("expected the dropped entries to be counted once, dropped %d, queued %d", dropped, len(s.items))
+	}
+	if reported != 3*(10-s.size) {
+		t.Errorf("expected the entries of the alarms not queued to be reported, got %d", reported)
+	}
+}
+
+// newTestAsyncSink returns an asynchronous sink without writer goroutine, so the queue is not drained
+func newTestAsyncSink(size int, dropped *uint64, onError func(error)) *asyncSink {
+	s := &asyncSink{size: size, onError: onError, dropped: dropped, last: make(map[AlarmKey]*queued)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func TestAsyncNeverEvictsRaise(t *testing.T) {
+	var dropped uint64
+	var rejected bool
+	s := newTestAsyncSink(4, &dropped, func(err error) {
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+		rejected = true
+	})
+	record := func(id string, state int) *Record {
+		return &Record{LogType: AppAlarm, Alarm: AlarmDetails{Name: "Alarm" + id, ID: id, State: state}}
+	}
+
+	// the raise and clear of every alarm accepted by the queue, keyed by the alarm
+	accepted := make(map[AlarmKey]bool)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		r := record(fmt.Sprint(random.Intn(8)), []int{StateRaised, StateUpdated, StateCleared}[random.Intn(3)])
+		rejected = false
+		if err := s.Write(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !rejected && r.Alarm.State != StateUpdated {
+			accepted[KeyOf(r.LogType, &r.Alarm)] = true
+		}
+
+		// an accepted raise stays queued, unless a later clear of its alarm is queued, and the clears are never
+		// evicted, so every accepted alarm keeps a queued entry
+		queued := make(map[AlarmKey]bool)
+		for _, item := range s.items {
+			queued[item.key] = true
+		}
+		for key := range accepted {
+			if !queued[key] {
+				t.Fatalf("the entries of the accepted alarm %v have been evicted at write %d", key, i)
+			}
+		}
+	}
+
+	// the raises fill the queue, the later raise is dropped and reported instead of evicting them
+	s = newTestAsyncSink(2, &dropped, func(err error) {
+		rejected = true
+	})
+	rejected = false
+	for _, id := range []string{"1", "2", "3"} {
+		if err := s.Write(record(id, StateRaised)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !rejected {
+		t.Error("expected the dropped raise to be reported")
+	}
+	if len(s.items) != 2 || s.items[0].record.Alarm.ID != "1" || s.items[1].record.Alarm.ID != "2" {
+		t.Errorf("expected the queued raises to be kept, got %d entries", len(s.items))
+	}
+}
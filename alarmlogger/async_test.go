@@ -0,0 +1,214 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+// blockingSink blocks the writes until released
+type blockingSink struct {
+	sliceSink
+	release chan struct{}
+}
+
+func (s *blockingSink) Write(record *alog.Record) error {
+	<-s.release
+	return s.sliceSink.Write(record)
+}
+
+func TestAsync(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithAsync(2))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	// the raise is taken from the queue and blocks in the sink
+	time.Sleep(50 * time.Millisecond)
+
+	// the caller is not blocked by the sink, the updates over the queue size are dropped
+	for i := 0; i < 10; i++ {
+		l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Major})
+	}
+	if stats := l.Stats(); stats.Dropped < 7 {
+		t.Errorf("expected at least 7 dropped entries, got %+v", stats)
+	}
+
+	// the clear does not wait for space in the queue, it replaces the last queued update of the alarm
+	cleared := make(chan struct{})
+	go func() {
+		l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+		close(cleared)
+	}()
+	select {
+	case <-cleared:
+	case <-time.After(time.Second):
+		t.Fatalf("clear blocked by the full queue")
+	}
+
+	close(sink.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records := sink.Records()
+	if records[0].Alarm.State != alog.StateRaised || records[len(records)-1].Alarm.State != alog.StateCleared {
+		t.Errorf("the raise and the clear should be written, got states %v", states(records))
+	}
+	if uint64(len(records))+l.Stats().Dropped != 12 {
+		t.Errorf("every entry should be either written or dropped, written %d, stats %+v", len(records), l.Stats())
+	}
+}
+
+func TestAsyncFullQueue(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	var reported []error
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithAsync(3),
+		alog.WithErrorHandler(func(err error) {
+			reported = append(reported, err)
+		}))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	time.Sleep(50 * time.Millisecond)
+
+	// the queue is filled by a raise followed by its clear and by an update
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired", ID: "2"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired", ID: "2"})
+	l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Critical})
+
+	// the raises of other alarms evict the raise followed by its clear, then the update. The queued raises and
+	// the clear are kept, the entries of the fifth alarm are dropped and reported, the update of a queued raise is
+	// merged into it.
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "DiskFull", ID: "3"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "MemoryLow", ID: "4"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "CpuHigh", ID: "5"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "CpuHigh", ID: "5"})
+	l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "DiskFull", ID: "3", Severity: alog.Critical})
+
+	close(sink.release)
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records := sink.Records()
+	expectStates(t, &sink.sliceSink, alog.StateRaised, alog.StateCleared, alog.StateRaised, alog.StateRaised)
+	var names []string
+	for _, record := range records {
+		names = append(names, record.Alarm.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"AppNotRunning", "LicenceExpired", "DiskFull", "MemoryLow"}) {
+		t.Errorf("unexpected records %v", names)
+	}
+	if records[2].Alarm.Severity != alog.Critical {
+		t.Errorf("expected the update merged into the queued raise, got %+v", records[2].Alarm)
+	}
+	if stats := l.Stats(); stats.Dropped != 4 {
+		t.Errorf("expected the evicted raise and update and the entries of the fifth alarm dropped, got %+v", stats)
+	}
+	if len(reported) != 2 {
+		t.Fatalf("expected the dropped raise and clear to be reported, got %v", reported)
+	}
+	for _, err := range reported {
+		if !errors.Is(err, alog.ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+	}
+}
+
+// blockingStore blocks the saves until released
+type blockingStore struct {
+	release chan struct{}
+	mu      sync.Mutex
+	saved   []alog.ActiveAlarm
+}
+
+func (s *blockingStore) Save(alarms []alog.ActiveAlarm) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = alarms
+	return nil
+}
+
+func (s *blockingStore) Load() ([]alog.ActiveAlarm, error) {
+	return nil, nil
+}
+
+func TestAsyncStore(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithStore(store), alog.WithAsync(10))
+
+	// the store is saved by the background goroutine, the callers are not blocked by it
+	done := make(chan struct{})
+	go func() {
+		l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+		l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired", ID: "2"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("raise blocked by the store")
+	}
+
+	close(store.release)
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.saved) != 2 {
+		t.Errorf("expected both alarms saved, got %+v", store.saved)
+	}
+}
+
+func TestAsyncFlushTimeout(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	defer close(sink.release)
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithAsync(1))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestAsyncClose(t *testing.T) {
+	sink := &sliceSink{}
+	var reported []error
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithAsync(10), alog.WithErrorHandler(func(err error) {
+		reported = append(reported, err)
+	}))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectStates(t, sink, alog.StateRaised, alog.StateCleared)
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	if len(reported) != 1 || !errors.Is(reported[0], alog.ErrClosed) {
+		t.Errorf("expected closed error, got %v", reported)
+	}
+	if err := l.Flush(context.Background()); err != nil {
+		t.Errorf("flush after close should do nothing, got %v", err)
+	}
+}
+
+func TestInvalidAsyncOptions(t *testing.T) {
+	for _, opt := range []alog.Option{alog.WithAsync(0), alog.WithRateLimit(0, 1), alog.WithRateLimit(time.Second, 0)} {
+		if _, err := alog.New(opt); err == nil {
+			t.Errorf("New should fail with invalid option")
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// ErrUnknownAlarm is reported for alarms whose name is not in the catalogue
+	ErrUnknownAlarm = errors.New("unknown alarm")
+	// ErrConflictingAlarm is reported for alarms whose ID differs from the one in the catalogue
+	ErrConflictingAlarm = errors.New("conflicting alarm")
+)
+
+// AlarmDefinition describes an alarm the application may raise
+type AlarmDefinition struct {
+	Name        string          `json:"name"`
+	ID          string          `json:"id"`
+	Severity    AlarmSeverity   `json:"severity"`
+	Visibility  AlarmVisibility `json:"visibility,omitempty"`
+	Description string          `json:"description,omitempty"`
+
+	// RaiseDelay postpones the raise, the alarm is not emitted at all if it is cleared within the delay
+	RaiseDelay Duration `json:"raiseDelay,omitempty"`
+	// ClearDelay postpones the clear (hold-down), the alarm stays raised if it is raised again within the delay
+	ClearDelay Duration `json:"clearDelay,omitempty"`
+	// FlapThreshold is the number of raise/clear transitions within FlapWindow after which the alarm is
+	// considered flapping. A flapping alarm is held raised with a "(flapping)" text suffix until no
+	// transition happens for FlapWindow, then its last requested state is emitted.
+	FlapThreshold int      `json:"flapThreshold,omitempty"`
+	FlapWindow    Duration `json:"flapWindow,omitempty"`
+
+	// Escalations raise the severity of the alarms staying active for too long, e.g. from WARNING to MAJOR
+	// after 1h. Each severity can escalate at most once, the rules can be chained.
+	Escalations []EscalationRule `json:"escalations,omitempty"`
+}
+
+func (d *AlarmDefinition) isDamped() bool {
+	return d.RaiseDelay.Duration > 0 || d.ClearDelay.Duration > 0 || d.FlapThreshold > 0
+}
+
+// Duration is a time.Duration represented as a string, e.g. "1m30s", in YAML and JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// Validate checks the mandatory fields and the recommended values of the definition
+func (d *AlarmDefinition) Validate() error {
+	if d.Name == "" {
+		return errors.New("alarm definition without name")
+	}
+	if d.ID == "" {
+		return fmt.Errorf("alarm definition %v without id", d.Name)
+	}
+	if !validSeverity(d.Severity) {
+		return fmt.Errorf("alarm definition %v has invalid severity %q", d.Name, d.Severity)
+	}
+	switch d.Visibility {
+	case "", Global, Operations:
+	default:
+		return fmt.Errorf("alarm definition %v has invalid visibility %q", d.Name, d.Visibility)
+	}
+	if d.RaiseDelay.Duration < 0 || d.ClearDelay.Duration < 0 {
+		return fmt.Errorf("alarm definition %v has negative delay", d.Name)
+	}
+	if d.FlapThreshold < 0 || (d.FlapThreshold > 0 && d.FlapWindow.Duration <= 0) {
+		return fmt.Errorf("alarm definition %v needs a positive flap threshold and window", d.Name)
+	}
+	return validateEscalations(d.Name, d.Escalations)
+}
+
+// CataloguePolicy defines how an AlarmLogger handles alarms not matching the catalogue
+type CataloguePolicy int
+
+const (
+	// WarnOnMismatch emits the alarm and reports the mismatch to the error handler
+	WarnOnMismatch CataloguePolicy = iota
+	// RejectOnMismatch reports the mismatch and does not emit the alarm
+	RejectOnMismatch
+)
+
+type catalogueFile struct {
+	Alarms []AlarmDefinition `json:"alarms"`
+}
+
+// Catalogue is the set of alarm definitions of an application, names and IDs are unique in it.
+// It is safe for concurrent use.
+type Catalogue struct {
+	mu     sync.RWMutex
+	byName map[string]AlarmDefinition
+	byID   map[string]AlarmDefinition
+}
+
+func NewCatalogue() *Catalogue {
+	return &Catalogue{
+		byName: make(map[string]AlarmDefinition),
+		byID:   make(map[string]AlarmDefinition),
+	}
+}
+
+// LoadCatalogue reads the alarm definitions from a YAML or JSON file with the following structure:
+//
+//	alarms:
+//	- name: AppNotRunning
+//	  id: "1"
+//	  severity: WARNING
+//	  description: Not all components of the application are ready
+func LoadCatalogue(path string) (*Catalogue, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file catalogueFile
+	switch filepath.Ext(path) {
+	case ".json", ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, &file)
+	default:
+		return nil, fmt.Errorf("unsupported alarm catalogue format: %v", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse alarm catalogue %v: %w", path, err)
+	}
+
+	c := NewCatalogue()
+	if err := c.Register(file.Alarms...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Register adds the definitions to the catalogue. Registering an identical definition again is allowed,
+// a definition reusing the name or the ID of another one is rejected. Nothing is registered on error.
+func (c *Catalogue) Register(defs ...AlarmDefinition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byName := make(map[string]AlarmDefinition, len(defs))
+	byID := make(map[string]AlarmDefinition, len(defs))
+	for _, def := range defs {
+		if err := def.Validate(); err != nil {
+			return err
+		}
+		for _, registered := range []map[string]AlarmDefinition{c.byName, byName} {
+			if other, found := registered[def.Name]; found && !reflect.DeepEqual(other, def) {
+				return fmt.Errorf("%w: %v is already defined with id %v", ErrConflictingAlarm, def.Name, other.ID)
+			}
+		}
+		for _, registered := range []map[string]AlarmDefinition{c.byID, byID} {
+			if other, found := registered[def.ID]; found && other.Name != def.Name {
+				return fmt.Errorf("%w: id %v is already used by %v", ErrConflictingAlarm, def.ID, other.Name)
+			}
+		}
+		byName[def.Name] = def
+		byID[def.ID] = def
+	}
+
+	for _, def := range defs {
+		c.byName[def.Name] = def
+		c.byID[def.ID] = def
+	}
+	return nil
+}
+
+// Lookup returns the definition registered with the given name
+func (c *Catalogue) Lookup(name string) (AlarmDefinition, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	def, found := c.byName[name]
+	return def, found
+}
+
+// Definitions returns the registered definitions ordered by name
+func (c *Catalogue) Definitions() []AlarmDefinition {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	defs := make([]AlarmDefinition, 0, len(c.byName))
+	for _, def := range c.byName {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// NewAlarm creates the details of a registered alarm with the given text
+func (c *Catalogue) NewAlarm(name string, text string) (*AlarmDetails, error) {
+	def, found := c.Lookup(name)
+	if !found {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownAlarm, name)
+	}
+	return &AlarmDetails{
+		Name:       def.Name,
+		ID:         def.ID,
+		Severity:   def.Severity,
+		Text:       text,
+		Visibility: def.Visibility,
+	}, nil
+}
+
+// Complete fills the empty ID, Severity and Visibility of the alarm from its definition,
+// then checks the alarm against the definition
+func (c *Catalogue) Complete(alarm *AlarmDetails) error {
+	def, found := c.Lookup(alarm.Name)
+	if !found {
+		return fmt.Errorf("%w: %v", ErrUnknownAlarm, alarm.Name)
+	}
+
+	if alarm.ID == "" {
+		alarm.ID = def.ID
+	}
+	if alarm.Severity == "" {
+		alarm.Severity = def.Severity
+	}
+	if alarm.Visibility == "" {
+		alarm.Visibility = def.Visibility
+	}
+
+	if alarm.ID != def.ID {
+		return fmt.Errorf("%w: %v has id %v instead of %v", ErrConflictingAlarm, alarm.Name, alarm.ID, def.ID)
+	}
+	return nil
+}
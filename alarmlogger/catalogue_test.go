@@ -0,0 +1,217 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+var (
+	appNotRunning = alog.AlarmDefinition{
+		Name:        "AppNotRunning",
+		ID:          "1",
+		Severity:    alog.Warning,
+		Description: "Not all components are ready",
+	}
+	licenceExpired = alog.AlarmDefinition{
+		Name:       "LicenceExpired",
+		ID:         "2",
+		Severity:   alog.Major,
+		Visibility: alog.Operations,
+	}
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %v: %v", path, err)
+	}
+	return path
+}
+
+func TestCatalogueRegister(t *testing.T) {
+	c := alog.NewCatalogue()
+	if err := c.Register(appNotRunning, licenceExpired); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Register(appNotRunning); err != nil {
+		t.Errorf("registering the same definition again should be allowed: %v", err)
+	}
+
+	sameNameOtherID := appNotRunning
+	sameNameOtherID.ID = "3"
+	if err := c.Register(sameNameOtherID); !errors.Is(err, alog.ErrConflictingAlarm) {
+		t.Errorf("expected conflict on reused name, got %v", err)
+	}
+
+	sameIDOtherName := appNotRunning
+	sameIDOtherName.Name = "Other"
+	if err := c.Register(sameIDOtherName); !errors.Is(err, alog.ErrConflictingAlarm) {
+		t.Errorf("expected conflict on reused id, got %v", err)
+	}
+	if _, found := c.Lookup("Other"); found {
+		t.Errorf("rejected definition must not be registered")
+	}
+
+	if len(c.Definitions()) != 2 {
+		t.Errorf("expected 2 definitions, got %v", c.Definitions())
+	}
+}
+
+func TestCatalogueRegisterInvalid(t *testing.T) {
+	invalid := []alog.AlarmDefinition{
+		{ID: "1", Severity: alog.Minor},
+		{Name: "NoID", Severity: alog.Minor},
+		{Name: "BadSeverity", ID: "1", Severity: "HIGH"},
+		{Name: "BadVisibility", ID: "1", Severity: alog.Minor, Visibility: "NOWHERE"},
+	}
+	for _, def := range invalid {
+		if err := alog.NewCatalogue().Register(def); err == nil {
+			t.Errorf("expected error for %+v", def)
+		}
+	}
+}
+
+func TestLoadCatalogue(t *testing.T) {
+	yamlPath := writeFile(t, "alarms.yaml", `
+alarms:
+- name: AppNotRunning
+  id: "1"
+  severity: WARNING
+  description: Not all components are ready
+- name: LicenceExpired
+  id: "2"
+  severity: MAJOR
+  visibility: OPERATIONS
+`)
+	jsonPath := writeFile(t, "alarms.json", `{"alarms": [{"name": "AppNotRunning", "id": "1", "severity": "WARNING", "description": "Not all components are ready"}]}`)
+
+	c, err := alog.LoadCatalogue(yamlPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def, _ := c.Lookup("LicenceExpired"); !reflect.DeepEqual(def, licenceExpired) {
+		t.Errorf("unexpected definition %+v", def)
+	}
+
+	c, err = alog.LoadCatalogue(jsonPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def, _ := c.Lookup("AppNotRunning"); !reflect.DeepEqual(def, appNotRunning) {
+		t.Errorf("unexpected definition %+v", def)
+	}
+}
+
+func TestLoadCatalogueErrors(t *testing.T) {
+	paths := []string{
+		writeFile(t, "alarms.txt", `alarms: []`),
+		writeFile(t, "unknown-field.yaml", "alarms:\n- name: A\n  id: \"1\"\n  severity: MINOR\n  colour: red\n"),
+		writeFile(t, "conflict.yaml", "alarms:\n- {name: A, id: \"1\", severity: MINOR}\n- {name: B, id: \"1\", severity: MINOR}\n"),
+		filepath.Join(t.TempDir(), "missing.yaml"),
+	}
+	for _, path := range paths {
+		if _, err := alog.LoadCatalogue(path); err == nil {
+			t.Errorf("expected error for %v", path)
+		}
+	}
+}
+
+func TestCatalogueNewAlarm(t *testing.T) {
+	c := alog.NewCatalogue()
+	c.Register(licenceExpired)
+
+	alarm, err := c.NewAlarm("LicenceExpired", "Application licence is invalid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := alog.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alog.Major, Text: "Application licence is invalid", Visibility: alog.Operations}
+	if !reflect.DeepEqual(*alarm, expected) {
+		t.Errorf("unexpected alarm %+v", alarm)
+	}
+
+	if _, err := c.NewAlarm("Unknown", ""); !errors.Is(err, alog.ErrUnknownAlarm) {
+		t.Errorf("expected unknown alarm error, got %v", err)
+	}
+}
+
+func TestRaiseCompletesAlarmFromCatalogue(t *testing.T) {
+	c := alog.NewCatalogue()
+	c.Register(appNotRunning)
+	buf := &bytes.Buffer{}
+
+	err := alog.MustNew(alog.WithWriter(buf), alog.WithCatalogue(c, alog.RejectOnMismatch)).
+		Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "any"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !validateLogs(buf.Bytes(), &completeLog{
+		LogType: alog.AppAlarm,
+		Alarm:   &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, Text: "any", State: 1, Visibility: alog.Global},
+	}) {
+		t.Errorf("TestRaiseCompletesAlarmFromCatalogue failed")
+	}
+}
+
+func TestCatalogueRejectPolicy(t *testing.T) {
+	c := alog.NewCatalogue()
+	c.Register(appNotRunning)
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithCatalogue(c, alog.RejectOnMismatch))
+
+	if err := l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "Unknown"}); !errors.Is(err, alog.ErrUnknownAlarm) {
+		t.Errorf("expected unknown alarm error, got %v", err)
+	}
+	if err := l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "5"}); !errors.Is(err, alog.ErrConflictingAlarm) {
+		t.Errorf("expected conflicting alarm error, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("rejected alarms must not be logged, got %q", buf.String())
+	}
+}
+
+func TestCatalogueWarnPolicy(t *testing.T) {
+	c := alog.NewCatalogue()
+	c.Register(appNotRunning)
+	buf := &bytes.Buffer{}
+	var reported []error
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithCatalogue(c, alog.WarnOnMismatch), alog.WithErrorHandler(func(err error) {
+		reported = append(reported, err)
+	}))
+
+	if err := l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "Unknown"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], alog.ErrUnknownAlarm) {
+		t.Errorf("expected the unknown alarm to be reported, got %v", reported)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("alarm should be logged with warn policy")
+	}
+}
+
+func TestDefaultErrorHandler(t *testing.T) {
+	c := alog.NewCatalogue()
+	c.Register(appNotRunning)
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithCatalogue(c, alog.WarnOnMismatch))
+
+	if err := l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "Unknown"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// the mismatch is not written to the alarm log, it holds the alarm only
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"name":"Unknown"`) {
+		t.Errorf("expected the alarm only in the alarm log, got %v", buf.String())
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"sync"
+	"time"
+)
+
+const flappingTextSuffix = " (flapping)"
+
+// dampState is the damping bookkeeping of a single alarm instance
+type dampState struct {
+	logType LogType
+	alarm   AlarmDetails
+	def     AlarmDefinition
+
+	// requested is the last state asked by the application, emitted is the last logged one
+	requested bool
+	emitted   bool
+
+	// pending is the timer of the delayed raise or clear, generation invalidates the fired
+	// callbacks of the stopped timers
+	pending    *time.Timer
+	generation int
+
+	transitions []time.Time
+	flapping    bool
+	flapTimer   *time.Timer
+
+	// escalated keeps the severity set by the escalation until the alarm is cleared
+	escalated bool
+}
+
+// emission is an alarm log entry decided with the damper lock held and emitted after its release
+type emission struct {
+	logType LogType
+	alarm   AlarmDetails
+}
+
+// damper applies the raise delay, clear delay and flap detection of the alarm definitions
+type damper struct {
+	mu     sync.Mutex
+	states map[AlarmKey]*dampState
+	emit   func(LogType, *AlarmDetails)
+
+	// queued are the emissions of the current lock holder. emitMu is taken before mu is released,
+	// so the emissions keep their order without blocking the damper during a slow sink.
+	queued []emission
+	emitMu sync.Mutex
+}
+
+func newDamper(emit func(LogType, *AlarmDetails)) *damper {
+	return &damper{
+		states: make(map[AlarmKey]*dampState),
+		emit:   emit,
+	}
+}
+
+// request handles a raise (alarm.State != 0) or clear asked by the application
+func (d *damper) request(logtype LogType, alarm *AlarmDetails, def *AlarmDefinition) {
+	d.mu.Lock()
+	defer d.unlockAndEmit()
+
+	key := KeyOf(logtype, alarm)
+	st, found := d.states[key]
+	if !found {
+		st = &dampState{logType: logtype}
+		d.states[key] = st
+	}
+	severity := st.alarm.Severity
+	st.alarm = alarm.clone()
+	if st.escalated {
+		st.alarm.Severity = severity
+	}
+	st.def = *def
+
+	raise := alarm.State != StateCleared
+	now := time.Now()
+	if raise != st.requested {
+		st.requested = raise
+		st.transitions = append(st.transitions, now)
+	}
+	st.pruneTransitions(now)
+
+	if st.flapping {
+		d.armFlapTimer(key, st)
+		return
+	}
+
+	if def.FlapThreshold > 0 && len(st.transitions) >= def.FlapThreshold {
+		d.startFlapping(key, st)
+		return
+	}
+
+	if raise {
+		switch {
+		case st.pending != nil && !st.emitted:
+			// delayed raise is already scheduled
+		case st.pending != nil:
+			// raised again within the clear delay, keep the alarm raised
+			d.stopPending(st)
+		case !st.emitted && def.RaiseDelay.Duration > 0:
+			d.schedule(key, st, def.RaiseDelay.Duration)
+		default:
+			d.emitState(st, true)
+		}
+	} else {
+		switch {
+		case st.pending != nil && st.emitted:
+			// delayed clear is already scheduled
+		case st.pending != nil:
+			// cleared within the raise delay, the alarm is never emitted
+			d.stopPending(st)
+		case st.emitted && def.ClearDelay.Duration > 0:
+			d.schedule(key, st, def.ClearDelay.Duration)
+		default:
+			d.emitState(st, false)
+		}
+	}
+	d.cleanup(key, st)
+}
+
+// update changes the details of a requested alarm, the update is emitted only if the raise has
+// already been emitted. An escalated alarm keeps the higher of the escalated and the updated severity.
+// Returns false if the alarm is not requested to be raised.
+func (d *damper) update(logtype LogType, alarm *AlarmDetails) bool {
+	d.mu.Lock()
+	defer d.unlockAndEmit()
+
+	st, found := d.states[KeyOf(logtype, alarm)]
+	if !found || !st.requested {
+		return false
+	}
+	severity := st.alarm.Severity
+	st.alarm = alarm.clone()
+	if st.escalated && severityRank(severity) > severityRank(alarm.Severity) {
+		st.alarm.Severity = severity
+	}
+	if st.emitted {
+		d.emitUpdate(st)
+	}
+	return true
+}
+
+// escalate changes the severity of a damped alarm and emits the update if its raise has been
+// emitted. Returns false if the alarm is not damped.
+func (d *damper) escalate(logtype LogType, alarm *AlarmDetails) bool {
+	d.mu.Lock()
+	defer d.unlockAndEmit()
+
+	st, found := d.states[KeyOf(logtype, alarm)]
+	if !found {
+		return false
+	}
+	st.alarm.Severity = alarm.Severity
+	st.escalated = true
+	if st.emitted {
+		d.emitUpdate(st)
+	}
+	return true
+}
+
+// unlockAndEmit releases the lock and emits the alarms queued while it was held
+func (d *damper) unlockAndEmit() {
+	queued := d.queued
+	d.queued = nil
+	if len(queued) == 0 {
+		d.mu.Unlock()
+		return
+	}
+	d.emitMu.Lock()
+	defer d.emitMu.Unlock()
+	d.mu.Unlock()
+
+	for i := range queued {
+		d.emit(queued[i].logType, &queued[i].alarm)
+	}
+}
+
+func (st *dampState) pruneTransitions(now time.Time) {
+	if st.def.FlapThreshold == 0 {
+		st.transitions = nil
+		return
+	}
+	first := 0
+	for first < len(st.transitions) && now.Sub(st.transitions[first]) > st.def.FlapWindow.Duration {
+		first++
+	}
+	st.transitions = st.transitions[first:]
+}
+
+// emitState queues the alarm with the given state, must be called with the lock held
+func (d *damper) emitState(st *dampState, raise bool) {
+	alarm := st.alarm.clone()
+	alarm.State = StateCleared
+	if raise {
+		alarm.State = StateRaised
+		if st.flapping {
+			alarm.Text += flappingTextSuffix
+		}
+	}
+	st.emitted = raise
+	if !raise {
+		st.escalated = false
+	}
+	d.queued = append(d.queued, emission{logType: st.logType, alarm: alarm})
+}
+
+// emitUpdate queues the update of the raised alarm, marked while it is flapping. Must be called
+// with the lock held.
+func (d *damper) emitUpdate(st *dampState) {
+	alarm := st.alarm.clone()
+	alarm.State = StateUpdated
+	if st.flapping {
+		alarm.Text += flappingTextSuffix
+	}
+	d.queued = append(d.queued, emission{logType: st.logType, alarm: alarm})
+}
+
+func (d *damper) schedule(key AlarmKey, st *dampState, delay time.Duration) {
+	st.generation++
+	generation := st.generation
+	st.pending = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		defer d.unlockAndEmit()
+
+		if st.generation != generation || d.states[key] != st {
+			return
+		}
+		st.pending = nil
+		d.emitState(st, st.requested)
+		d.cleanup(key, st)
+	})
+}
+
+func (d *damper) stopPending(st *dampState) {
+	if st.pending != nil {
+		st.pending.Stop()
+		st.pending = nil
+	}
+	st.generation++
+}
+
+// startFlapping holds the alarm raised and marks it as flapping. An already raised alarm is
+// updated, its repeated raise would be suppressed by the registry.
+func (d *damper) startFlapping(key AlarmKey, st *dampState) {
+	d.stopPending(st)
+	st.flapping = true
+	if st.emitted {
+		d.emitUpdate(st)
+	} else {
+		d.emitState(st, true)
+	}
+	d.armFlapTimer(key, st)
+}
+
+// armFlapTimer (re)starts the timer ending the flapping after a quiet flap window
+func (d *damper) armFlapTimer(key AlarmKey, st *dampState) {
+	if st.flapTimer != nil {
+		st.flapTimer.Stop()
+	}
+	st.generation++
+	generation := st.generation
+	st.flapTimer = time.AfterFunc(st.def.FlapWindow.Duration, func() {
+		d.mu.Lock()
+		defer d.unlockAndEmit()
+
+		if st.generation != generation || d.states[key] != st {
+			return
+		}
+		st.flapping = false
+		st.flapTimer = nil
+		st.transitions = nil
+		if st.requested {
+			// the alarm stays raised, the flapping mark is withdrawn
+			d.emitUpdate(st)
+		} else {
+			d.emitState(st, false)
+		}
+		d.cleanup(key, st)
+	})
+}
+
+// cleanup forgets the alarm once it is cleared and nothing is pending for it
+func (d *damper) cleanup(key AlarmKey, st *dampState) {
+	if !st.requested && !st.emitted && st.pending == nil && !st.flapping && len(st.transitions) == 0 {
+		delete(d.states, key)
+	}
+}
+
+// stop cancels all of the pending timers
+func (d *damper) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, st := range d.states {
+		d.stopPending(st)
+		if st.flapTimer != nil {
+			st.flapTimer.Stop()
+		}
+		delete(d.states, key)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+func newDampedLogger(t *testing.T, def alog.AlarmDefinition) (*alog.AlarmLogger, *sliceSink) {
+	c := alog.NewCatalogue()
+	if err := c.Register(def); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink := &sliceSink{}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithCatalogue(c, alog.RejectOnMismatch))
+	t.Cleanup(func() {
+		l.Close()
+	})
+	return l, sink
+}
+
+func states(records []alog.Record) []int {
+	var out []int
+	for _, record := range records {
+		out = append(out, record.Alarm.State)
+	}
+	return out
+}
+
+func expectStates(t *testing.T, sink *sliceSink, expected ...int) {
+	t.Helper()
+	actual := states(sink.Records())
+	if len(actual) != len(expected) {
+		t.Fatalf("expected states %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected states %v, got %v", expected, actual)
+		}
+	}
+}
+
+func duration(d time.Duration) alog.Duration {
+	return alog.Duration{Duration: d}
+}
+
+func TestRaiseDelay(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, RaiseDelay: duration(50 * time.Millisecond)})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	expectStates(t, sink)
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, 1)
+
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	expectStates(t, sink, 1, 0)
+}
+
+func TestRaiseDelayClearedWithinDelay(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, RaiseDelay: duration(50 * time.Millisecond)})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink)
+}
+
+func TestClearDelay(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, ClearDelay: duration(50 * time.Millisecond)})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	expectStates(t, sink, 1)
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, 1, 0)
+}
+
+func TestClearDelayRaisedWithinDelay(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, ClearDelay: duration(50 * time.Millisecond)})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, 1)
+}
+
+func TestFlapping(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning,
+		FlapThreshold: 4, FlapWindow: duration(100 * time.Millisecond)})
+
+	// 3 transitions: raise, clear, raise are emitted
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "Not all components are ready"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "All components are now ready"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "Not all components are ready"})
+	expectStates(t, sink, 1, 0, 1)
+
+	// the 4th transition makes the raised alarm flapping, it is updated as flapping and held raised
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "All components are now ready"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "Not all components are ready"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "All components are now ready"})
+	expectStates(t, sink, 1, 0, 1, 2)
+
+	// after a quiet flap window the last requested state is emitted
+	time.Sleep(200 * time.Millisecond)
+	expectStates(t, sink, 1, 0, 1, 2, 0)
+}
+
+func TestFlappingRaisesSingleAlarm(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning,
+		FlapThreshold: 2, FlapWindow: duration(100 * time.Millisecond)})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "down"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "up"})
+	expectStates(t, sink, 1, 2)
+
+	records := sink.Records()
+	if !strings.HasSuffix(records[1].Alarm.Text, "(flapping)") {
+		t.Errorf("flapping alarm should be marked, got %q", records[1].Alarm.Text)
+	}
+
+	// raised at the end of the flapping, stays raised and the mark is withdrawn
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "down"})
+	time.Sleep(200 * time.Millisecond)
+	expectStates(t, sink, 1, 2, 2)
+
+	records = sink.Records()
+	if records[2].Alarm.Text != "down" {
+		t.Errorf("flapping mark should be withdrawn, got %q", records[2].Alarm.Text)
+	}
+}
+
+func TestFlappingStartsWithRaise(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning,
+		RaiseDelay: duration(time.Hour), FlapThreshold: 3, FlapWindow: duration(100 * time.Millisecond)})
+
+	// the alarm is not emitted within the raise delay, the flapping raises it
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "down"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "up"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "down"})
+	expectStates(t, sink, 1)
+
+	if text := sink.Records()[0].Alarm.Text; text != "down (flapping)" {
+		t.Errorf("flapping alarm should be marked, got %q", text)
+	}
+}
+
+func TestDampedEmitOutsideLock(t *testing.T) {
+	c := alog.NewCatalogue()
+	for _, def := range []alog.AlarmDefinition{
+		{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, RaiseDelay: duration(10 * time.Millisecond)},
+		{Name: "LicenceExpired", ID: "2", Severity: alog.Major, RaiseDelay: duration(time.Hour)},
+	} {
+		if err := c.Register(def); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	sink := &blockingSink{release: make(chan struct{})}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithCatalogue(c, alog.RejectOnMismatch))
+	defer l.Close()
+	defer close(sink.release)
+
+	// the delayed raise blocks in the sink
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	time.Sleep(50 * time.Millisecond)
+
+	// the damping of another alarm is not blocked meanwhile
+	done := make(chan struct{})
+	go func() {
+		l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("damped request blocked by the sink")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EscalationRule changes the severity of an alarm which has been active with the From severity for
+// the given duration. The change is emitted as an update of the alarm (state 2).
+type EscalationRule struct {
+	From  AlarmSeverity `json:"from"`
+	To    AlarmSeverity `json:"to"`
+	After Duration      `json:"after"`
+}
+
+func validSeverity(severity AlarmSeverity) bool {
+	switch severity {
+	case Minor, Major, Critical, Warning, Info:
+		return true
+	}
+	return false
+}
+
+// severityRank orders the severities from INFO to CRITICAL
+func severityRank(severity AlarmSeverity) int {
+	switch severity {
+	case Info:
+		return 1
+	case Warning:
+		return 2
+	case Minor:
+		return 3
+	case Major:
+		return 4
+	case Critical:
+		return 5
+	}
+	return 0
+}
+
+// validateEscalations checks that every severity escalates at most once and the rules do not form a cycle
+func validateEscalations(name string, rules []EscalationRule) error {
+	next := make(map[AlarmSeverity]AlarmSeverity, len(rules))
+	for _, rule := range rules {
+		if !validSeverity(rule.From) || !validSeverity(rule.To) || rule.From == rule.To {
+			return fmt.Errorf("alarm definition %v has invalid escalation from %q to %q", name, rule.From, rule.To)
+		}
+		if rule.After.Duration <= 0 {
+			return fmt.Errorf("alarm definition %v needs a positive escalation delay", name)
+		}
+		if _, found := next[rule.From]; found {
+			return fmt.Errorf("alarm definition %v has multiple escalations from %v", name, rule.From)
+		}
+		next[rule.From] = rule.To
+	}
+	for _, rule := range rules {
+		severity := rule.To
+		for steps := 0; steps < len(rules); steps++ {
+			if severity == rule.From {
+				return fmt.Errorf("alarm definition %v has cyclic escalations", name)
+			}
+			to, found := next[severity]
+			if !found {
+				break
+			}
+			severity = to
+		}
+	}
+	return nil
+}
+
+// escalation is the pending escalation of a single alarm instance
+type escalation struct {
+	logType    LogType
+	alarm      AlarmDetails
+	timer      *time.Timer
+	generation int
+}
+
+// escalator applies the escalation rules of the alarm definitions to the emitted alarms
+type escalator struct {
+	mu         sync.Mutex
+	catalogue  *Catalogue
+	pending    map[AlarmKey]*escalation
+	generation int
+	emit       func(LogType, *AlarmDetails)
+}
+
+func newEscalator(catalogue *Catalogue, emit func(LogType, *AlarmDetails)) *escalator {
+	return &escalator{
+		catalogue: catalogue,
+		pending:   make(map[AlarmKey]*escalation),
+		emit:      emit,
+	}
+}
+
+// observe schedules the escalation of an emitted raise or update and cancels it on clear. A repeated
+// raise with unchanged severity keeps the already running escalation.
+func (e *escalator) observe(logtype LogType, alarm *AlarmDetails) {
+	if nil == e.catalogue {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := KeyOf(logtype, alarm)
+	esc, found := e.pending[key]
+	if found {
+		if alarm.State != StateCleared && esc.alarm.Severity == alarm.Severity {
+			esc.alarm = alarm.clone()
+			return
+		}
+		esc.timer.Stop()
+		delete(e.pending, key)
+	}
+	if alarm.State == StateCleared {
+		return
+	}
+
+	def, found := e.catalogue.Lookup(alarm.Name)
+	if !found {
+		return
+	}
+	for _, rule := range def.Escalations {
+		if rule.From == alarm.Severity {
+			e.schedule(key, logtype, alarm, rule)
+			return
+		}
+	}
+}
+
+func (e *escalator) schedule(key AlarmKey, logtype LogType, alarm *AlarmDetails, rule EscalationRule) {
+	e.generation++
+	esc := &escalation{
+		logType:    logtype,
+		alarm:      alarm.clone(),
+		generation: e.generation,
+	}
+	e.pending[key] = esc
+	esc.timer = time.AfterFunc(rule.After.Duration, func() {
+		e.mu.Lock()
+		if current, found := e.pending[key]; !found || current.generation != esc.generation {
+			e.mu.Unlock()
+			return
+		}
+		delete(e.pending, key)
+		escalated := esc.alarm.clone()
+		e.mu.Unlock()
+
+		// emitted without the lock, the emitted update schedules the next escalation
+		escalated.Severity = rule.To
+		escalated.State = StateUpdated
+		e.emit(esc.logType, &escalated)
+	})
+}
+
+// stop cancels all of the pending escalations
+func (e *escalator) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key, esc := range e.pending {
+		esc.timer.Stop()
+		delete(e.pending, key)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+func severities(records []alog.Record) []alog.AlarmSeverity {
+	var out []alog.AlarmSeverity
+	for _, record := range records {
+		out = append(out, record.Alarm.Severity)
+	}
+	return out
+}
+
+func TestUpdate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithRegistry(alog.NewRegistry()))
+
+	err := l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Major})
+	if !errors.Is(err, alog.ErrAlarmNotActive) {
+		t.Errorf("expected not active error, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("update of an inactive alarm must not be logged, got %q", buf.String())
+	}
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, Text: "1 of 3 pods are not ready"})
+	buf.Reset()
+	if err := l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Major, Text: "3 of 3 pods are not ready"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !validateLogs(buf.Bytes(), &completeLog{
+		LogType: alog.AppAlarm,
+		Alarm:   &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Major, Text: "3 of 3 pods are not ready", State: alog.StateUpdated, Visibility: alog.Global},
+	}) {
+		t.Errorf("unexpected alarm log %q", buf.String())
+	}
+
+	active := l.ActiveAlarms()
+	if len(active) != 1 || active[0].Alarm.Severity != alog.Major || active[0].UpdatedAt.IsZero() {
+		t.Errorf("registry should hold the updated alarm, got %+v", active)
+	}
+}
+
+func TestUpdateDamped(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, RaiseDelay: duration(50 * time.Millisecond)})
+
+	if err := l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"}); !errors.Is(err, alog.ErrAlarmNotActive) {
+		t.Errorf("expected not active error, got %v", err)
+	}
+
+	// the update of a pending raise changes the alarm to be raised
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	if err := l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Severity: alog.Major}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectStates(t, sink)
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, alog.StateRaised)
+	if sink.Records()[0].Alarm.Severity != alog.Major {
+		t.Errorf("delayed raise should have the updated severity")
+	}
+
+	l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Severity: alog.Critical})
+	expectStates(t, sink, alog.StateRaised, alog.StateUpdated)
+}
+
+func TestEscalation(t *testing.T) {
+	c := alog.NewCatalogue()
+	err := c.Register(alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, Escalations: []alog.EscalationRule{
+		{From: alog.Warning, To: alog.Major, After: duration(100 * time.Millisecond)},
+		{From: alog.Major, To: alog.Critical, After: duration(100 * time.Millisecond)},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink := &sliceSink{}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithCatalogue(c, alog.RejectOnMismatch), alog.WithRegistry(alog.NewRegistry()))
+	defer l.Close()
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "Not all components are ready"})
+	time.Sleep(150 * time.Millisecond)
+	expectStates(t, sink, alog.StateRaised, alog.StateUpdated)
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, alog.StateRaised, alog.StateUpdated, alog.StateUpdated)
+
+	records := sink.Records()
+	if s := severities(records); s[1] != alog.Major || s[2] != alog.Critical {
+		t.Errorf("unexpected severities %v", s)
+	}
+	if records[2].Alarm.Text != "Not all components are ready" {
+		t.Errorf("escalation should keep the text, got %q", records[2].Alarm.Text)
+	}
+	if active := l.ActiveAlarms(); len(active) != 1 || active[0].Alarm.Severity != alog.Critical {
+		t.Errorf("unexpected active alarms %+v", active)
+	}
+}
+
+func TestEscalationDamped(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning,
+		ClearDelay: duration(time.Hour), Escalations: []alog.EscalationRule{
+			{From: alog.Warning, To: alog.Major, After: duration(50 * time.Millisecond)},
+		}})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, alog.StateRaised, alog.StateUpdated)
+
+	// the repeated raise of the damped alarm keeps the escalated severity
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	expectStates(t, sink, alog.StateRaised, alog.StateUpdated, alog.StateRaised)
+	if s := severities(sink.Records()); s[1] != alog.Major || s[2] != alog.Major {
+		t.Errorf("unexpected severities %v", s)
+	}
+}
+
+func TestEscalationDampedUpdate(t *testing.T) {
+	l, sink := newDampedLogger(t, alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning,
+		ClearDelay: duration(time.Hour), Escalations: []alog.EscalationRule{
+			{From: alog.Warning, To: alog.Major, After: duration(50 * time.Millisecond)},
+		}})
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	time.Sleep(100 * time.Millisecond)
+
+	// the update with the original severity does not de-escalate the alarm, a higher severity is kept
+	if err := l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: "still down"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Severity: alog.Critical}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectStates(t, sink, alog.StateRaised, alog.StateUpdated, alog.StateUpdated, alog.StateUpdated)
+	records := sink.Records()
+	if s := severities(records); s[2] != alog.Major || s[3] != alog.Critical {
+		t.Errorf("unexpected severities %v", s)
+	}
+	if records[2].Alarm.Text != "still down" {
+		t.Errorf("update should change the text, got %q", records[2].Alarm.Text)
+	}
+}
+
+func TestEscalationCancelledByClear(t *testing.T) {
+	c := alog.NewCatalogue()
+	c.Register(alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, Escalations: []alog.EscalationRule{
+		{From: alog.Warning, To: alog.Major, After: duration(50 * time.Millisecond)},
+	}})
+	sink := &sliceSink{}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithCatalogue(c, alog.RejectOnMismatch))
+	defer l.Close()
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+
+	// a raise with other severity is not escalated by the WARNING rule
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Severity: alog.Minor})
+
+	time.Sleep(100 * time.Millisecond)
+	expectStates(t, sink, alog.StateRaised, alog.StateCleared, alog.StateRaised)
+}
+
+func TestEscalationValidation(t *testing.T) {
+	invalid := [][]alog.EscalationRule{
+		{{From: alog.Warning, To: alog.Major}},
+		{{From: alog.Warning, To: "HIGH", After: duration(time.Minute)}},
+		{{From: alog.Warning, To: alog.Warning, After: duration(time.Minute)}},
+		{{From: alog.Warning, To: alog.Major, After: duration(time.Minute)}, {From: alog.Warning, To: alog.Critical, After: duration(time.Hour)}},
+		{{From: alog.Warning, To: alog.Major, After: duration(time.Minute)}, {From: alog.Major, To: alog.Warning, After: duration(time.Minute)}},
+	}
+	for _, rules := range invalid {
+		def := alog.AlarmDefinition{Name: "AppNotRunning", ID: "1", Severity: alog.Warning, Escalations: rules}
+		if err := def.Validate(); err == nil {
+			t.Errorf("expected error for %+v", rules)
+		}
+	}
+
+	path := writeFile(t, "alarms.yaml", `
+alarms:
+- name: AppNotRunning
+  id: "1"
+  severity: WARNING
+  escalations:
+  - {from: WARNING, to: MAJOR, after: 1h}
+`)
+	c, err := alog.LoadCatalogue(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if def, _ := c.Lookup("AppNotRunning"); len(def.Escalations) != 1 || def.Escalations[0].After.Duration != time.Hour {
+		t.Errorf("unexpected definition %+v", def)
+	}
+}
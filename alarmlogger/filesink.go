@@ -0,0 +1,134 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink appends the alarm log entries to a file. When the file would exceed MaxSize
+// it is rotated to <path>.1, the older backups are shifted and only MaxBackups are kept.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens the file on the given path for appending. A maxSize of 0 disables rotation.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends the entry to the file. If the rotation fails the entry is appended to
+// the current file, the rotation is tried again at the next write.
+func (s *FileSink) Write(record *Record) error {
+	line, err := record.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.file {
+		return fmt.Errorf("alarm log file %v is closed", s.path)
+	}
+	var rotateErr error
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			rotateErr = fmt.Errorf("failed to rotate alarm log file %v: %w", s.path, err)
+			if nil == s.file {
+				return rotateErr
+			}
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate moves the file to the first backup and opens a new file on the path. The file
+// on the path is opened again even if the backups could not be shifted, so the sink is
+// not left closed.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.shiftBackups()
+	}
+	if openErr := s.open(); openErr != nil {
+		if err != nil {
+			return fmt.Errorf("%v, failed to reopen: %w", err, openErr)
+		}
+		return openErr
+	}
+	return err
+}
+
+func (s *FileSink) shiftBackups() error {
+	if s.maxBackups > 0 {
+		os.Remove(s.backupPath(s.maxBackups))
+		for i := s.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(s.backupPath(i), s.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *FileSink) backupPath(index int) string {
+	return fmt.Sprintf("%v.%d", s.path, index)
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nil == s.file {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
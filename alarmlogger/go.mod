@@ -1,5 +1,13 @@
 module github.com/nokia/industrial-application-framework/alarmlogger
 
-go 1.13
+go 1.16
 
-require go.uber.org/zap v1.15.0
+require (
+	github.com/onsi/gomega v1.16.0
+	github.com/prometheus/client_golang v1.11.0
+	go.uber.org/multierr v1.5.0
+	go.uber.org/zap v1.15.0
+	k8s.io/api v0.21.2
+	k8s.io/apimachinery v0.21.2
+	sigs.k8s.io/yaml v1.2.0
+)
@@ -0,0 +1,97 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigMapDataKey is the key of the active alarms in the data of the ConfigMap
+const ConfigMapDataKey = "alarms.json"
+
+// ConfigMapClient is the subset of the client-go ConfigMapInterface used by the ConfigMapStore,
+// e.g. clientset.CoreV1().ConfigMaps(namespace)
+type ConfigMapClient interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.ConfigMap, error)
+	Create(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.CreateOptions) (*corev1.ConfigMap, error)
+	Update(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.UpdateOptions) (*corev1.ConfigMap, error)
+}
+
+// ConfigMapStore keeps the active alarms in a ConfigMap, so they survive the restart of the pod.
+// The ConfigMap is created on the first save.
+type ConfigMapStore struct {
+	client ConfigMapClient
+	name   string
+	// Timeout limits the duration of the API calls
+	Timeout time.Duration
+}
+
+func NewConfigMapStore(client ConfigMapClient, name string) *ConfigMapStore {
+	return &ConfigMapStore{
+		client:  client,
+		name:    name,
+		Timeout: 10 * time.Second,
+	}
+}
+
+func (s *ConfigMapStore) Save(alarms []alarmlogger.ActiveAlarm) error {
+	content, err := json.Marshal(alarms)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	configMap, err := s.client.Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.name},
+			Data:       map[string]string{ConfigMapDataKey: string(content)},
+		}
+		_, err = s.client.Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[ConfigMapDataKey] = string(content)
+	_, err = s.client.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *ConfigMapStore) Load() ([]alarmlogger.ActiveAlarm, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	configMap, err := s.client.Get(ctx, s.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content, found := configMap.Data[ConfigMapDataKey]
+	if !found {
+		return nil, nil
+	}
+	var alarms []alarmlogger.ActiveAlarm
+	if err := json.Unmarshal([]byte(content), &alarms); err != nil {
+		return nil, err
+	}
+	return alarms, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package k8s_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/nokia/industrial-application-framework/alarmlogger/k8s"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeConfigMaps struct {
+	configMaps map[string]*corev1.ConfigMap
+	creates    int
+	updates    int
+}
+
+func (c *fakeConfigMaps) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.ConfigMap, error) {
+	configMap, found := c.configMaps[name]
+	if !found {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return configMap.DeepCopy(), nil
+}
+
+func (c *fakeConfigMaps) Create(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.CreateOptions) (*corev1.ConfigMap, error) {
+	c.creates++
+	c.configMaps[configMap.Name] = configMap.DeepCopy()
+	return configMap, nil
+}
+
+func (c *fakeConfigMaps) Update(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.UpdateOptions) (*corev1.ConfigMap, error) {
+	c.updates++
+	c.configMaps[configMap.Name] = configMap.DeepCopy()
+	return configMap, nil
+}
+
+func TestConfigMapStore(t *testing.T) {
+	client := &fakeConfigMaps{configMaps: make(map[string]*corev1.ConfigMap)}
+	store := k8s.NewConfigMapStore(client, "consul-operator-alarms")
+
+	alarms, err := store.Load()
+	if err != nil || len(alarms) != 0 {
+		t.Fatalf("expected nothing stored, got %v, %v", alarms, err)
+	}
+
+	raisedAt := time.Date(2020, 6, 22, 6, 47, 22, 0, time.UTC)
+	saved := []alarmlogger.ActiveAlarm{{
+		LogType:      alarmlogger.AppAlarm,
+		Alarm:        alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning, State: alarmlogger.StateRaised},
+		RaisedAt:     raisedAt,
+		LastRaisedAt: raisedAt,
+		RaiseCount:   1,
+	}}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.creates != 1 || client.updates != 1 {
+		t.Errorf("expected the ConfigMap to be created then updated, got %d creates, %d updates", client.creates, client.updates)
+	}
+
+	alarms, err = store.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(alarms) != 1 || alarms[0].Alarm.Name != "AppNotRunning" || !alarms[0].RaisedAt.Equal(raisedAt) {
+		t.Errorf("unexpected stored alarms %+v", alarms)
+	}
+}
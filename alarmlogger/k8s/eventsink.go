@@ -0,0 +1,62 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package k8s contains the Kubernetes specific extensions of the alarmlogger
+package k8s
+
+import (
+	"fmt"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	EventTypeNormal  = "Normal"
+	EventTypeWarning = "Warning"
+)
+
+// EventRecorder is the subset of the client-go record.EventRecorder used by the EventSink
+type EventRecorder interface {
+	Event(object runtime.Object, eventtype, reason, message string)
+}
+
+// EventSink records the alarms as Kubernetes Events on the involved object, so they are
+// visible with kubectl describe. Raised and updated alarms are Warning events, except the ones
+// with INFO severity, cleared alarms are Normal events. The reason of the event is the alarm name.
+type EventSink struct {
+	recorder       EventRecorder
+	involvedObject runtime.Object
+}
+
+func NewEventSink(recorder EventRecorder, involvedObject runtime.Object) *EventSink {
+	return &EventSink{
+		recorder:       recorder,
+		involvedObject: involvedObject,
+	}
+}
+
+func (s *EventSink) Write(record *alarmlogger.Record) error {
+	alarm := &record.Alarm
+	eventType := EventTypeNormal
+	action := "cleared"
+	if alarm.State != alarmlogger.StateCleared {
+		action = "raised"
+		if alarm.State == alarmlogger.StateUpdated {
+			action = "updated"
+		}
+		if alarm.Severity != alarmlogger.Info {
+			eventType = EventTypeWarning
+		}
+	}
+
+	message := fmt.Sprintf("%v alarm %v (id: %v, severity: %v) %v: %v",
+		record.LogType, alarm.Name, alarm.ID, alarm.Severity, action, alarm.Text)
+	if alarm.SubDN != "" {
+		message += ", subdn: " + alarm.SubDN
+	}
+
+	s.recorder.Event(s.involvedObject, eventType, alarm.Name, message)
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package k8s_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/nokia/industrial-application-framework/alarmlogger/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type event struct {
+	object    runtime.Object
+	eventType string
+	reason    string
+	message   string
+}
+
+type fakeRecorder struct {
+	events []event
+}
+
+func (r *fakeRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.events = append(r.events, event{object, eventtype, reason, message})
+}
+
+func TestEventSink(t *testing.T) {
+	recorder := &fakeRecorder{}
+	object := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "example-consul", Namespace: "app"}}
+	l := alarmlogger.MustNew(alarmlogger.WithWriter(&bytes.Buffer{}), alarmlogger.WithSink(k8s.NewEventSink(recorder, object)))
+
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning, Text: "Not all components are ready"})
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "Notice", ID: "3", Severity: alarmlogger.Info})
+	l.Clear(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning, Text: "All components are now ready", SubDN: "/MODULE-a"})
+	l.Update(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alarmlogger.Major, Text: "Licence expired a week ago"})
+
+	if len(recorder.events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(recorder.events))
+	}
+
+	raised := recorder.events[0]
+	if raised.object != object || raised.eventType != k8s.EventTypeWarning || raised.reason != "AppNotRunning" {
+		t.Errorf("unexpected event %+v", raised)
+	}
+	if !strings.Contains(raised.message, "raised: Not all components are ready") {
+		t.Errorf("unexpected message %q", raised.message)
+	}
+
+	if recorder.events[1].eventType != k8s.EventTypeNormal {
+		t.Errorf("alarm with INFO severity should be a Normal event")
+	}
+
+	cleared := recorder.events[2]
+	if cleared.eventType != k8s.EventTypeNormal || !strings.Contains(cleared.message, "cleared: All components are now ready, subdn: /MODULE-a") {
+		t.Errorf("unexpected event %+v", cleared)
+	}
+
+	updated := recorder.events[3]
+	if updated.eventType != k8s.EventTypeWarning || !strings.Contains(updated.message, "severity: MAJOR) updated: Licence expired a week ago") {
+		t.Errorf("unexpected event %+v", updated)
+	}
+}
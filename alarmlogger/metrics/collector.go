@@ -0,0 +1,116 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package metrics exposes the alarms emitted by an AlarmLogger as Prometheus metrics
+package metrics
+
+import (
+	"sync"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	TransitionRaised  = "raised"
+	TransitionCleared = "cleared"
+	TransitionUpdated = "updated"
+)
+
+type activeLabels struct {
+	name     string
+	id       string
+	severity string
+	logType  string
+}
+
+// Collector is an alarm sink and a Prometheus collector at the same time. It exposes
+//
+//	alarm_active{name,id,severity,log_type}: number of active alarm instances
+//	alarm_transitions_total{name,id,log_type,transition}: number of emitted raises, updates and clears
+//
+// An updated alarm stays active, with its new severity.
+//
+// Register it in the registry served by the metrics endpoint, e.g. the controller-runtime one:
+//
+//	collector := metrics.NewCollector()
+//	ctrlmetrics.Registry.MustRegister(collector)
+//	alarmlogger.SetDefault(alarmlogger.MustNew(alarmlogger.WithSink(collector)))
+type Collector struct {
+	mu          sync.Mutex
+	active      map[alarmlogger.AlarmKey]activeLabels
+	activeDesc  *prometheus.Desc
+	transitions *prometheus.CounterVec
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		active: make(map[alarmlogger.AlarmKey]activeLabels),
+		activeDesc: prometheus.NewDesc(
+			"alarm_active",
+			"Number of the active alarms.",
+			[]string{"name", "id", "severity", "log_type"},
+			nil,
+		),
+		transitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "alarm_transitions_total",
+				Help: "Number of the emitted alarm raises, updates and clears.",
+			},
+			[]string{"name", "id", "log_type", "transition"},
+		),
+	}
+}
+
+// Write updates the metrics with the alarm log entry
+func (c *Collector) Write(record *alarmlogger.Record) error {
+	alarm := &record.Alarm
+	key := alarmlogger.KeyOf(record.LogType, alarm)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var transition string
+	switch alarm.State {
+	case alarmlogger.StateCleared:
+		transition = TransitionCleared
+		delete(c.active, key)
+	case alarmlogger.StateUpdated:
+		transition = TransitionUpdated
+	default:
+		transition = TransitionRaised
+	}
+	if alarm.State != alarmlogger.StateCleared {
+		c.active[key] = activeLabels{
+			name:     alarm.Name,
+			id:       alarm.ID,
+			severity: string(alarm.Severity),
+			logType:  string(record.LogType),
+		}
+	}
+	c.transitions.WithLabelValues(alarm.Name, alarm.ID, string(record.LogType), transition).Inc()
+	return nil
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeDesc
+	c.transitions.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	counts := make(map[activeLabels]int)
+	for _, labels := range c.active {
+		counts[labels]++
+	}
+	c.mu.Unlock()
+
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.activeDesc, prometheus.GaugeValue, float64(count),
+			labels.name, labels.id, labels.severity, labels.logType)
+	}
+	c.transitions.Collect(ch)
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/nokia/industrial-application-framework/alarmlogger/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	collector := metrics.NewCollector()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	l := alarmlogger.MustNew(alarmlogger.WithWriter(&bytes.Buffer{}), alarmlogger.WithSink(collector))
+
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning, SubDN: "/MODULE-a"})
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning, SubDN: "/MODULE-b"})
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alarmlogger.Major})
+	l.Clear(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alarmlogger.Major})
+
+	expected := `
+# HELP alarm_active Number of the active alarms.
+# TYPE alarm_active gauge
+alarm_active{id="1",log_type="APP_ALARM",name="AppNotRunning",severity="WARNING"} 2
+# HELP alarm_transitions_total Number of the emitted alarm raises, updates and clears.
+# TYPE alarm_transitions_total counter
+alarm_transitions_total{id="1",log_type="APP_ALARM",name="AppNotRunning",transition="raised"} 2
+alarm_transitions_total{id="2",log_type="APP_ALARM",name="LicenceExpired",transition="cleared"} 1
+alarm_transitions_total{id="2",log_type="APP_ALARM",name="LicenceExpired",transition="raised"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorWithRegistry(t *testing.T) {
+	collector := metrics.NewCollector()
+	l := alarmlogger.MustNew(alarmlogger.WithWriter(&bytes.Buffer{}), alarmlogger.WithSink(collector), alarmlogger.WithRegistry(alarmlogger.NewRegistry()))
+
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+
+	// suppressed duplicates are not transitions
+	expected := `
+# HELP alarm_transitions_total Number of the emitted alarm raises, updates and clears.
+# TYPE alarm_transitions_total counter
+alarm_transitions_total{id="1",log_type="APP_ALARM",name="AppNotRunning",transition="raised"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "alarm_transitions_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectorUpdate(t *testing.T) {
+	collector := metrics.NewCollector()
+	l := alarmlogger.MustNew(alarmlogger.WithWriter(&bytes.Buffer{}), alarmlogger.WithSink(collector))
+
+	l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+	l.Update(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Major})
+
+	// the updated alarm is still active, with the new severity
+	expected := `
+# HELP alarm_active Number of the active alarms.
+# TYPE alarm_active gauge
+alarm_active{id="1",log_type="APP_ALARM",name="AppNotRunning",severity="MAJOR"} 1
+# HELP alarm_transitions_total Number of the emitted alarm raises, updates and clears.
+# TYPE alarm_transitions_total counter
+alarm_transitions_total{id="1",log_type="APP_ALARM",name="AppNotRunning",transition="raised"} 1
+alarm_transitions_total{id="1",log_type="APP_ALARM",name="AppNotRunning",transition="updated"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics
+
+import (
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ReasonQueueFull   = "queue_full"
+	ReasonRateLimited = "rate_limited"
+)
+
+// StatsCollector exposes the alarm log entries dropped by an AlarmLogger
+//
+//	alarm_log_dropped_total{reason}: number of the dropped entries, reason is queue_full or rate_limited
+type StatsCollector struct {
+	logger      *alarmlogger.AlarmLogger
+	droppedDesc *prometheus.Desc
+}
+
+func NewStatsCollector(logger *alarmlogger.AlarmLogger) *StatsCollector {
+	return &StatsCollector{
+		logger: logger,
+		droppedDesc: prometheus.NewDesc(
+			"alarm_log_dropped_total",
+			"Number of the alarm log entries dropped by the queue or the rate limit.",
+			[]string{"reason"},
+			nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *StatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.droppedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *StatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.logger.Stats()
+	ch <- prometheus.MustNewConstMetric(c.droppedDesc, prometheus.CounterValue, float64(stats.Dropped), ReasonQueueFull)
+	ch <- prometheus.MustNewConstMetric(c.droppedDesc, prometheus.CounterValue, float64(stats.RateLimited), ReasonRateLimited)
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/nokia/industrial-application-framework/alarmlogger/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatsCollector(t *testing.T) {
+	l := alarmlogger.MustNew(alarmlogger.WithWriter(&bytes.Buffer{}), alarmlogger.WithRateLimit(time.Hour, 1))
+
+	for i := 0; i < 3; i++ {
+		l.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alarmlogger.Warning})
+	}
+
+	expected := `
+# HELP alarm_log_dropped_total Number of the alarm log entries dropped by the queue or the rate limit.
+# TYPE alarm_log_dropped_total counter
+alarm_log_dropped_total{reason="queue_full"} 0
+alarm_log_dropped_total{reason="rate_limited"} 2
+`
+	if err := testutil.CollectAndCompare(metrics.NewStatsCollector(l), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bucket is the token bucket of a single alarm instance
+type bucket struct {
+	tokens float64
+	last   time.Time
+	raised bool
+}
+
+// rateLimitSink limits the records of every alarm instance with a token bucket. A record changing the
+// alarm between raised and cleared is always written, losing it would leave a stale alarm behind. The buckets
+// idle for longer than the refill period are full, they are evicted to keep the map bounded by the active
+// alarm instances.
+type rateLimitSink struct {
+	sink     Sink
+	interval time.Duration
+	burst    int
+	limited  *uint64
+
+	mu        sync.Mutex
+	buckets   map[AlarmKey]*bucket
+	lastPrune time.Time
+}
+
+func newRateLimitSink(sink Sink, interval time.Duration, burst int, limited *uint64) *rateLimitSink {
+	return &rateLimitSink{
+		sink:     sink,
+		interval: interval,
+		burst:    burst,
+		limited:  limited,
+		buckets:  make(map[AlarmKey]*bucket),
+	}
+}
+
+func (s *rateLimitSink) Write(record *Record) error {
+	if !s.allow(record, time.Now()) {
+		atomic.AddUint64(s.limited, 1)
+		return nil
+	}
+	return s.sink.Write(record)
+}
+
+func (s *rateLimitSink) allow(record *Record, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+	key := KeyOf(record.LogType, &record.Alarm)
+	b, found := s.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(s.burst), last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(s.interval)
+	if b.tokens > float64(s.burst) {
+		b.tokens = float64(s.burst)
+	}
+	b.last = now
+
+	raised := record.Alarm.State != StateCleared
+	transition := raised != b.raised
+	b.raised = raised
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return transition
+}
+
+// prune evicts the buckets idle for longer than the refill period at most once per refill period. An evicted
+// bucket has been refilled, the next record of its alarm instance gets a full bucket again, so nothing changes.
+func (s *rateLimitSink) prune(now time.Time) {
+	refill := time.Duration(s.burst) * s.interval
+	if now.Sub(s.lastPrune) < refill {
+		return
+	}
+	s.lastPrune = now
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > refill {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *rateLimitSink) Close() error {
+	if closer, ok := s.sink.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitPrune(t *testing.T) {
+	var limited uint64
+	s := newRateLimitSink(nil, time.Second, 2, &limited)
+	now := time.Now()
+	record := func(id string, state int) *Record {
+		return &Record{LogType: AppAlarm, Alarm: AlarmDetails{Name: "AppNotRunning", ID: id, State: state}}
+	}
+
+	s.allow(record("1", StateRaised), now)
+	s.allow(record("2", StateRaised), now.Add(1900*time.Millisecond))
+	s.allow(record("2", StateUpdated), now.Add(1900*time.Millisecond))
+	if len(s.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(s.buckets))
+	}
+
+	// the bucket of 1 is idle for longer than the refill period of 2s, the bucket of 2 used 0.6s ago is kept
+	s.allow(record("3", StateRaised), now.Add(2500*time.Millisecond))
+	if _, found := s.buckets[KeyOf(AppAlarm, &AlarmDetails{Name: "AppNotRunning", ID: "1"})]; found || len(s.buckets) != 2 {
+		t.Errorf("expected the idle bucket evicted, got %d buckets", len(s.buckets))
+	}
+	// the bucket of 2 is empty, its next update is still limited
+	if s.allow(record("2", StateUpdated), now.Add(2500*time.Millisecond)) {
+		t.Errorf("expected the update limited by the kept bucket")
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+func TestRateLimit(t *testing.T) {
+	sink := &sliceSink{}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithRateLimit(50*time.Millisecond, 2))
+
+	for i := 0; i < 5; i++ {
+		l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	}
+	// other alarm instances have their own limit
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", SubDN: "/MODULE-a"})
+	expectStates(t, sink, alog.StateRaised, alog.StateRaised, alog.StateRaised)
+	if stats := l.Stats(); stats.RateLimited != 3 {
+		t.Errorf("expected 3 rate limited entries, got %+v", stats)
+	}
+
+	// the clear is a transition, it is never dropped
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	expectStates(t, sink, alog.StateRaised, alog.StateRaised, alog.StateRaised, alog.StateCleared)
+
+	// the whole burst is available again after burst intervals
+	time.Sleep(150 * time.Millisecond)
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	expectStates(t, sink, alog.StateRaised, alog.StateRaised, alog.StateRaised, alog.StateCleared, alog.StateRaised, alog.StateRaised)
+}
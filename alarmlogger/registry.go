@@ -0,0 +1,148 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlarmKey identifies an alarm instance, raise and clear of the same alarm
+// must result in the same key
+type AlarmKey struct {
+	LogType LogType
+	Name    string
+	ID      string
+	SubDN   string
+}
+
+// KeyOf returns the registry key of the given alarm
+func KeyOf(logtype LogType, alarm *AlarmDetails) AlarmKey {
+	return AlarmKey{
+		LogType: logtype,
+		Name:    alarm.Name,
+		ID:      alarm.ID,
+		SubDN:   alarm.SubDN,
+	}
+}
+
+// ActiveAlarm is an alarm which has been raised and not yet cleared
+type ActiveAlarm struct {
+	LogType LogType `json:"logType"`
+	// Alarm is the latest emitted raise or update of the alarm
+	Alarm AlarmDetails `json:"alarm"`
+	// RaisedAt is the time of the first, emitted raise
+	RaisedAt time.Time `json:"raisedAt"`
+	// LastRaisedAt is the time of the latest raise, including the suppressed duplicates
+	LastRaisedAt time.Time `json:"lastRaisedAt"`
+	// UpdatedAt is the time of the latest update, zero if the alarm has not been updated
+	UpdatedAt time.Time `json:"updatedAt"`
+	// RaiseCount counts the raises since the alarm became active, including the suppressed duplicates
+	RaiseCount int `json:"raiseCount"`
+}
+
+// Registry keeps track of the active alarms, it can be shared between AlarmLogger instances.
+// It is safe for concurrent use.
+type Registry struct {
+	mu     sync.Mutex
+	active map[AlarmKey]*ActiveAlarm
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		active: make(map[AlarmKey]*ActiveAlarm),
+	}
+}
+
+// raise records the raise of the alarm, returns false if the alarm was already active
+func (r *Registry) raise(logtype LogType, alarm *AlarmDetails, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := KeyOf(logtype, alarm)
+	if active, found := r.active[key]; found {
+		active.LastRaisedAt = now
+		active.RaiseCount++
+		return false
+	}
+
+	r.active[key] = &ActiveAlarm{
+		LogType:      logtype,
+		Alarm:        alarm.clone(),
+		RaisedAt:     now,
+		LastRaisedAt: now,
+		RaiseCount:   1,
+	}
+	return true
+}
+
+// clear records the clear of the alarm, returns false if the alarm was not active
+func (r *Registry) clear(logtype LogType, alarm *AlarmDetails) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := KeyOf(logtype, alarm)
+	if _, found := r.active[key]; !found {
+		return false
+	}
+	delete(r.active, key)
+	return true
+}
+
+// update replaces the details of the active alarm, returns false if the alarm is not active
+func (r *Registry) update(logtype LogType, alarm *AlarmDetails, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	active, found := r.active[KeyOf(logtype, alarm)]
+	if !found {
+		return false
+	}
+	active.Alarm = alarm.clone()
+	active.UpdatedAt = now
+	return true
+}
+
+// restore adds the alarms loaded from a store, the already active alarms are kept
+func (r *Registry) restore(alarms []ActiveAlarm) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range alarms {
+		active := alarms[i]
+		key := KeyOf(active.LogType, &active.Alarm)
+		if _, found := r.active[key]; !found {
+			active.Alarm = active.Alarm.clone()
+			r.active[key] = &active
+		}
+	}
+}
+
+// IsActive reports whether the given alarm is raised
+func (r *Registry) IsActive(logtype LogType, alarm *AlarmDetails) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, found := r.active[KeyOf(logtype, alarm)]
+	return found
+}
+
+// ActiveAlarms returns a snapshot of the active alarms ordered by their raise time
+func (r *Registry) ActiveAlarms() []ActiveAlarm {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	alarms := make([]ActiveAlarm, 0, len(r.active))
+	for _, active := range r.active {
+		snapshot := *active
+		snapshot.Alarm = active.Alarm.clone()
+		alarms = append(alarms, snapshot)
+	}
+	sort.SliceStable(alarms, func(i, j int) bool {
+		return alarms[i].RaisedAt.Before(alarms[j].RaisedAt)
+	})
+	return alarms
+}
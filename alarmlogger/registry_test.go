@@ -0,0 +1,115 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+func countLines(buf *bytes.Buffer) int {
+	return len(strings.Split(strings.TrimSpace(buf.String()), "\n"))
+}
+
+func TestRegistrySuppressesDuplicateRaise(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithRegistry(alog.NewRegistry()))
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	if lines := countLines(buf); lines != 1 {
+		t.Errorf("expected 1 alarm log line, got %d", lines)
+	}
+
+	active := l.ActiveAlarms()
+	if len(active) != 1 {
+		t.Fatalf("expected 1 active alarm, got %d", len(active))
+	}
+	if active[0].RaiseCount != 2 {
+		t.Errorf("expected raise count 2, got %d", active[0].RaiseCount)
+	}
+	if active[0].RaisedAt.After(active[0].LastRaisedAt) {
+		t.Errorf("first raise time is after the last raise time")
+	}
+}
+
+func TestRegistrySuppressesClearOfInactiveAlarm(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithRegistry(alog.NewRegistry()))
+
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	if buf.Len() != 0 {
+		t.Errorf("expected no alarm log, got %q", buf.String())
+	}
+}
+
+func TestRegistryRaiseClearRaise(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithRegistry(alog.NewRegistry()))
+	alarm := &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"}
+
+	l.Raise(alog.AppAlarm, alarm)
+	if !l.IsActive(alog.AppAlarm, alarm) {
+		t.Errorf("alarm should be active after raise")
+	}
+	l.Clear(alog.AppAlarm, alarm)
+	if l.IsActive(alog.AppAlarm, alarm) {
+		t.Errorf("alarm should not be active after clear")
+	}
+	l.Clear(alog.AppAlarm, alarm)
+	l.Raise(alog.AppAlarm, alarm)
+
+	if lines := countLines(buf); lines != 3 {
+		t.Errorf("expected 3 alarm log lines, got %d", lines)
+	}
+}
+
+func TestRegistryKey(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithRegistry(alog.NewRegistry()))
+
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	l.Raise(alog.AppFwAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", SubDN: "/MODULE-a"})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "2"})
+	// severity and text are not part of the key
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Major, Text: "other"})
+
+	if n := len(l.ActiveAlarms()); n != 4 {
+		t.Errorf("expected 4 active alarms, got %d", n)
+	}
+	if lines := countLines(buf); lines != 4 {
+		t.Errorf("expected 4 alarm log lines, got %d", lines)
+	}
+}
+
+func TestSharedRegistry(t *testing.T) {
+	registry := alog.NewRegistry()
+	first := &bytes.Buffer{}
+	second := &bytes.Buffer{}
+
+	alog.MustNew(alog.WithWriter(first), alog.WithRegistry(registry)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "Shared"})
+	alog.MustNew(alog.WithWriter(second), alog.WithRegistry(registry)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "Shared"})
+
+	if first.Len() == 0 || second.Len() != 0 {
+		t.Errorf("expected the alarm to be logged only by the first instance")
+	}
+}
+
+func TestWithoutRegistry(t *testing.T) {
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}))
+	alarm := &alog.AlarmDetails{Name: "AppNotRunning"}
+
+	l.Raise(alog.AppAlarm, alarm)
+
+	if l.IsActive(alog.AppAlarm, alarm) || len(l.ActiveAlarms()) != 0 {
+		t.Errorf("alarms must not be tracked without a registry")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"io"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Record is a single alarm log entry
+type Record struct {
+	Time    time.Time
+	LogType LogType
+	Alarm   AlarmDetails
+}
+
+// Sink receives every emitted alarm log entry. Sinks implementing io.Closer are closed
+// by AlarmLogger.Close.
+type Sink interface {
+	Write(record *Record) error
+}
+
+// MarshalJSON encodes the record in the same format as the alarm log line
+func (r *Record) MarshalJSON() ([]byte, error) {
+	buf, err := zapcore.NewJSONEncoder(encoderConfig()).EncodeEntry(
+		zapcore.Entry{Time: r.Time},
+		[]zapcore.Field{zap.String("log_type", string(r.LogType)), zap.Object("alarm", &r.Alarm)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Free()
+
+	out := make([]byte, buf.Len())
+	copy(out, buf.Bytes())
+	return out, nil
+}
+
+type multiSink []Sink
+
+// MultiSink duplicates the records to all of the given sinks. A failing sink does
+// not prevent writing to the others, the errors are combined.
+func MultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Write(record *Record) error {
+	var err error
+	for _, sink := range m {
+		err = multierr.Append(err, sink.Write(record))
+	}
+	return err
+}
+
+func (m multiSink) Close() error {
+	var err error
+	for _, sink := range m {
+		if closer, ok := sink.(io.Closer); ok {
+			err = multierr.Append(err, closer.Close())
+		}
+	}
+	return err
+}
+
+// logSink writes the JSON alarm log through a zap logger
+type logSink struct {
+	logger *zap.Logger
+}
+
+func (s *logSink) Write(record *Record) error {
+	if ce := s.logger.Check(zapcore.InfoLevel, ""); ce != nil {
+		ce.Time = record.Time
+		ce.Write(zap.String("log_type", string(record.LogType)), zap.Object("alarm", &record.Alarm))
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+type sliceSink struct {
+	mu      sync.Mutex
+	records []alog.Record
+	err     error
+}
+
+func (s *sliceSink) Write(record *alog.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, *record)
+	return s.err
+}
+
+func (s *sliceSink) Records() []alog.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]alog.Record(nil), s.records...)
+}
+
+func TestWithSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := &sliceSink{}
+
+	alog.MustNew(alog.WithWriter(buf), alog.WithSink(sink)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	if buf.Len() == 0 {
+		t.Errorf("alarm log should still be written")
+	}
+	records := sink.Records()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if records[0].LogType != alog.AppAlarm || records[0].Alarm.Name != "AppNotRunning" || records[0].Alarm.State != 1 {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+	if records[0].Time.IsZero() {
+		t.Errorf("record time is not set")
+	}
+}
+
+func TestMultiSinkWritesAllSinks(t *testing.T) {
+	failing := &sliceSink{err: errors.New("failed")}
+	working := &sliceSink{}
+	var reported []error
+
+	alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(failing, working), alog.WithErrorHandler(func(err error) {
+		reported = append(reported, err)
+	})).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning"})
+
+	if len(failing.Records()) != 1 || len(working.Records()) != 1 {
+		t.Errorf("expected the record on both sinks")
+	}
+	if len(reported) != 1 {
+		t.Errorf("expected the sink error to be reported, got %v", reported)
+	}
+}
+
+func TestRecordMarshalJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := &sliceSink{}
+	alog.MustNew(alog.WithWriter(buf), alog.WithSink(sink)).Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	record := sink.Records()[0]
+	out, err := record.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != buf.String() {
+		t.Errorf("record encoding %q differs from alarm log %q", out, buf.String())
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.log")
+	sink, err := alog.NewFileSink(path, 300, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink))
+
+	for i := 0; i < 10; i++ {
+		l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", Text: strings.Repeat("x", 50)})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error on close: %v", err)
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("expected file %v: %v", p, err)
+		}
+		if info.Size() > 300 {
+			t.Errorf("file %v exceeds the max size: %d", p, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups should be kept")
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(content), `"name":"AppNotRunning"`) {
+		t.Errorf("unexpected file content %q", content)
+	}
+}
+
+func TestFileSinkRotationFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.log")
+	// the backup path is a non-empty directory, the file cannot be renamed to it
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink, err := alog.NewFileSink(path, 100, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sink.Close()
+
+	record := &alog.Record{LogType: alog.AppAlarm, Alarm: alog.AlarmDetails{Name: "AppNotRunning", Text: strings.Repeat("x", 50)}}
+	if err := sink.Write(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sink.Write(record); err == nil {
+		t.Errorf("expected the rotation error")
+	}
+
+	// the sink keeps writing to the file on the path
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sink.Write(record); err != nil {
+		t.Errorf("unexpected error after the rotation recovered: %v", err)
+	}
+	backup, _ := ioutil.ReadFile(path + ".1")
+	if strings.Count(string(backup), `"name":"AppNotRunning"`) != 2 {
+		t.Errorf("expected both entries written before the rotation in the backup, got %q", backup)
+	}
+}
+
+func TestFileSinkClosed(t *testing.T) {
+	sink, err := alog.NewFileSink(filepath.Join(t.TempDir(), "alarms.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink.Close()
+
+	if err := sink.Write(&alog.Record{}); err == nil {
+		t.Errorf("expected error when writing a closed sink")
+	}
+}
+
+func TestWebhookSinkRetry(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sink := alog.NewWebhookSink(server.URL)
+	sink.Backoff = time.Millisecond
+	sink.Header.Set("X-Token", "secret")
+
+	if err := sink.Write(&alog.Record{LogType: alog.AppAlarm, Alarm: alog.AlarmDetails{Name: "AppNotRunning", State: 1}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(bodies))
+	}
+	if !strings.Contains(bodies[2], `"log_type":"APP_ALARM"`) || !strings.Contains(bodies[2], `"name":"AppNotRunning"`) {
+		t.Errorf("unexpected body %q", bodies[2])
+	}
+}
+
+func TestWebhookSinkClientErrorNotRetried(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := alog.NewWebhookSink(server.URL)
+	sink.Backoff = time.Millisecond
+
+	if err := sink.Write(&alog.Record{}); err == nil {
+		t.Errorf("expected error")
+	}
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+}
+
+func TestWebhookSinkGivesUp(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sink := alog.NewWebhookSink(server.URL)
+	sink.Backoff = time.Millisecond
+	sink.MaxRetries = 2
+
+	if err := sink.Write(&alog.Record{}); err == nil {
+		t.Errorf("expected error")
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWebhookSinkTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink := alog.NewWebhookSink(server.URL)
+	sink.Backoff = time.Second
+	sink.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if err := sink.Write(&alog.Record{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the write should give up after the timeout, took %v", elapsed)
+	}
+}
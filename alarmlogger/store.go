@@ -0,0 +1,67 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store persists the active alarms, so they survive the restart of the application.
+// Implementations do not have to be safe for concurrent use, the AlarmLogger serializes the calls.
+type Store interface {
+	// Save replaces the stored alarms with the given ones
+	Save(alarms []ActiveAlarm) error
+	// Load returns the stored alarms, nothing if nothing has been saved yet
+	Load() ([]ActiveAlarm, error)
+}
+
+// FileStore keeps the active alarms in a local JSON file, e.g. on a persistent volume
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Save writes the alarms to a temporary file and renames it, so a crash never leaves a partial file behind
+func (s *FileStore) Save(alarms []ActiveAlarm) error {
+	content, err := json.Marshal(alarms)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStore) Load() ([]ActiveAlarm, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var alarms []ActiveAlarm
+	if err := json.Unmarshal(content, &alarms); err != nil {
+		return nil, err
+	}
+	return alarms, nil
+}
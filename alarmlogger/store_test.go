@@ -0,0 +1,111 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	alog "github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+type failingStore struct{}
+
+func (failingStore) Save([]alog.ActiveAlarm) error {
+	return errors.New("save failed")
+}
+
+func (failingStore) Load() ([]alog.ActiveAlarm, error) {
+	return nil, errors.New("load failed")
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alarms.json")
+	store := alog.NewFileStore(path)
+	alarms, err := store.Load()
+	if err != nil || len(alarms) != 0 {
+		t.Fatalf("expected nothing stored, got %v, %v", alarms, err)
+	}
+
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithStore(store))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Warning})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alog.Major, AdditionalInfo: map[string]string{"licence": "abc"}})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	alarms, err = store.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(alarms) != 1 || alarms[0].Alarm.Name != "LicenceExpired" || alarms[0].Alarm.AdditionalInfo["licence"] != "abc" {
+		t.Errorf("unexpected stored alarms %+v", alarms)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(content), "eventtime") {
+		t.Errorf("unset event time should not be stored, got %s", content)
+	}
+}
+
+func TestRestoreAfterRestart(t *testing.T) {
+	store := alog.NewFileStore(filepath.Join(t.TempDir(), "alarms.json"))
+	alarm := &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Warning}
+	alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithStore(store)).Raise(alog.AppAlarm, alarm)
+
+	// the restarted instance knows the alarm raised by the previous one
+	buf := &bytes.Buffer{}
+	l := alog.MustNew(alog.WithWriter(buf), alog.WithStore(store))
+	if !l.IsActive(alog.AppAlarm, alarm) {
+		t.Fatalf("alarm should be restored as active")
+	}
+	l.Raise(alog.AppAlarm, alarm)
+	if buf.Len() != 0 {
+		t.Errorf("raise of a restored alarm should be suppressed, got %q", buf.String())
+	}
+	l.Clear(alog.AppAlarm, alarm)
+	if countLines(buf) != 1 {
+		t.Errorf("clear of a restored alarm should be logged, got %q", buf.String())
+	}
+}
+
+func TestResync(t *testing.T) {
+	sink := &sliceSink{}
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithSink(sink), alog.WithRegistry(alog.NewRegistry()))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Warning})
+	l.Update(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1", Severity: alog.Major})
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired", ID: "2", Severity: alog.Major})
+	l.Clear(alog.AppAlarm, &alog.AlarmDetails{Name: "LicenceExpired", ID: "2"})
+
+	l.Resync()
+
+	records := sink.Records()[4:]
+	if len(records) != 1 {
+		t.Fatalf("expected 1 resynced alarm, got %+v", records)
+	}
+	if records[0].Alarm.Name != "AppNotRunning" || records[0].Alarm.State != alog.StateRaised || records[0].Alarm.Severity != alog.Major {
+		t.Errorf("unexpected resynced alarm %+v", records[0].Alarm)
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	var reported []error
+	l := alog.MustNew(alog.WithWriter(&bytes.Buffer{}), alog.WithStore(failingStore{}), alog.WithErrorHandler(func(err error) {
+		reported = append(reported, err)
+	}))
+	l.Raise(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"})
+
+	if len(reported) != 2 {
+		t.Errorf("expected the load and save errors to be reported, got %v", reported)
+	}
+	if !l.IsActive(alog.AppAlarm, &alog.AlarmDetails{Name: "AppNotRunning", ID: "1"}) {
+		t.Errorf("alarm should be active despite the store failure")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarmlogger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// WebhookSink posts every alarm log entry as JSON to an HTTP endpoint. Failed
+// requests are retried with exponential backoff, client errors (4xx) are not retried.
+// The attempts and the backoff of a record are bounded by Timeout, the write runs on
+// the goroutine raising the alarm unless the logger is created WithAsync.
+type WebhookSink struct {
+	URL        string
+	Client     *http.Client
+	Header     http.Header
+	MaxRetries int
+	Backoff    time.Duration
+	// Timeout bounds the total time of the write including the retries, 0 means no bound
+	Timeout time.Duration
+}
+
+// NewWebhookSink creates a WebhookSink with 3 retries starting with 500ms backoff, a
+// write gives up after 5s. The POST is synchronous: an unreachable endpoint blocks the
+// goroutine raising the alarm for up to 5s per entry, e.g. an informer event handler.
+// Create the logger WithAsync when the sink is used, so the POSTs and the retries run on
+// its background goroutine:
+//
+//	alarmlogger.New(alarmlogger.WithSink(alarmlogger.NewWebhookSink(url)), alarmlogger.WithAsync(1000))
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		URL:        url,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Header:     http.Header{},
+		MaxRetries: 3,
+		Backoff:    500 * time.Millisecond,
+		Timeout:    5 * time.Second,
+	}
+}
+
+func (w *WebhookSink) Write(record *Record) error {
+	body, err := record.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.MaxRetries {
+			return fmt.Errorf("failed to post alarm to %v: %w", w.URL, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("failed to post alarm to %v: %v, giving up: %w", w.URL, err, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// post sends the body once, returns whether a failure is worth retrying
+func (w *WebhookSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range w.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("unexpected response status: %v", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
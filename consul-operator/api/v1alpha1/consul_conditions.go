@@ -0,0 +1,78 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition types of the Consul status
+const (
+	// ConditionResourcesGranted is true when the NDAC platform resource requests have been approved
+	ConditionResourcesGranted = "ResourcesGranted"
+	// ConditionDeployed is true when the application has been deployed for the current generation of the spec
+	ConditionDeployed = "Deployed"
+	// ConditionReady is true when all of the monitored pods of the application are ready
+	ConditionReady = "Ready"
+	// ConditionLicenceValid is false while the licence of the application is expired
+	ConditionLicenceValid = "LicenceValid"
+	// ConditionBackupSucceeded is false when the last backup job failed
+	ConditionBackupSucceeded = "BackupSucceeded"
+	// ConditionTerminating is true while the resources of the deleted application are cleaned up
+	ConditionTerminating = "Terminating"
+	// ConditionDegraded is true when the last reconciliation failed
+	ConditionDegraded = "Degraded"
+)
+
+// Condition reasons of the Consul status, they are the reasons of the events of the Consul as well
+const (
+	ReasonTemplatingFailed      = "TemplatingFailed"
+	ReasonResourceRequestFailed = "ResourceRequestFailed"
+	ReasonResourcesRequested    = "ResourcesRequested"
+	ReasonResourcesPending      = "ResourcesPending"
+	ReasonResourcesNotGranted   = "ResourcesNotGranted"
+	ReasonResourcesGranted      = "ResourcesGranted"
+	ReasonDeployFailed          = "DeployFailed"
+	ReasonDeployed              = "Deployed"
+	ReasonInstalled             = "Installed"
+	ReasonUpgraded              = "Upgraded"
+	ReasonUninstalled           = "Uninstalled"
+	ReasonSecretsFailed         = "SecretsFailed"
+	ReasonACLBootstrapFailed    = "ACLBootstrapFailed"
+	ReasonACLTokenRejected      = "ACLTokenRejected"
+	ReasonRestoreFailed         = "RestoreFailed"
+	ReasonBackupSucceeded       = "BackupSucceeded"
+	ReasonBackupFailed          = "BackupFailed"
+	ReasonBackupInvalid         = "BackupInvalid"
+	ReasonUndeployFailed        = "UndeployFailed"
+	ReasonUpdateRejected        = "UpdateRejected"
+	ReasonRedeploying           = "Redeploying"
+	ReasonDeleting              = "Deleting"
+	ReasonWaitingForRelease     = "WaitingForRelease"
+	ReasonCleanedUp             = "CleanedUp"
+	ReasonForceDeleted          = "ForceDeleted"
+	ReasonPodsReady             = "PodsReady"
+	ReasonPodsNotReady          = "PodsNotReady"
+	ReasonLicenceActive         = "LicenceActive"
+	ReasonLicenceExpired        = "LicenceExpired"
+	ReasonReconciled            = "Reconciled"
+)
+
+// SetCondition adds or updates the condition of the given type, observed at the current generation of the Consul
+func (c *Consul) SetCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&c.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: c.GetGeneration(),
+	})
+}
+
+// IsConditionTrue reports whether the condition of the given type is present and true
+func (c *Consul) IsConditionTrue(conditionType string) bool {
+	return meta.IsStatusConditionTrue(c.Status.Conditions, conditionType)
+}
@@ -18,6 +18,21 @@ const (
 	AppStatusFrozen     = "FROZEN"
 )
 
+// Phase is the step of the deployment the operator is working on
+// +kubebuilder:validation:Enum=Templating;RequestingResources;WaitingForGrant;Deploying;BootstrappingACL;Restoring;Running;Failed
+type Phase string
+
+const (
+	PhaseTemplating          Phase = "Templating"
+	PhaseRequestingResources Phase = "RequestingResources"
+	PhaseWaitingForGrant     Phase = "WaitingForGrant"
+	PhaseDeploying           Phase = "Deploying"
+	PhaseBootstrappingACL    Phase = "BootstrappingACL"
+	PhaseRestoring           Phase = "Restoring"
+	PhaseRunning             Phase = "Running"
+	PhaseFailed              Phase = "Failed"
+)
+
 type PrivateNetworkAccess struct {
 	ApnUUID          string    `json:"apnUUID,omitempty"`
 	Networks         []Network `json:"networks,omitempty"`
@@ -33,10 +48,49 @@ type ConsulSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make generate" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+
+	// ReplicaCount is the number of the Consul servers, it defaults to 1. It must be a positive odd number, the
+	// servers need a majority to elect a leader and an even number adds no fault tolerance.
 	ReplicaCount         int                   `json:"replicaCount"`
 	Ports                Ports                 `json:"ports"`
 	MetricsDomainName    string                `json:"metricsDomainName,omitempty"`
 	PrivateNetworkAccess *PrivateNetworkAccess `json:"privateNetworkAccess,omitempty"`
+	Security             *Security             `json:"security,omitempty"`
+	Backup               *Backup               `json:"backup,omitempty"`
+	Restore              *Restore              `json:"restore,omitempty"`
+}
+
+// Security enables the security features of Consul, the keys, certificates and tokens are generated by the operator
+type Security struct {
+	// ACL enables the access control lists, the operator bootstraps the ACL system and stores the bootstrap token
+	// in a Secret
+	ACL bool `json:"acl,omitempty"`
+	// GossipEncryption encrypts the gossip traffic with a key generated into a Secret
+	GossipEncryption bool `json:"gossipEncryption,omitempty"`
+	// TLS encrypts and verifies the RPC traffic with a CA and server certificate generated into a Secret
+	TLS bool `json:"tls,omitempty"`
+}
+
+// Backup schedules the snapshots of the Consul state, the snapshots are saved to a requested platform Storage
+type Backup struct {
+	// Interval between the snapshots, eg. 6h
+	// +kubebuilder:validation:Required
+	Interval metav1.Duration `json:"interval"`
+	// Retention is the number of the snapshots kept on the Storage, the older ones are removed
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=7
+	Retention int `json:"retention,omitempty"`
+	// StorageSize is the size of the Storage requested for the snapshots
+	// +kubebuilder:default="1Gi"
+	StorageSize string `json:"storageSize,omitempty"`
+}
+
+// Restore restores a snapshot into the newly deployed cluster, before it is reported running
+type Restore struct {
+	// Snapshot is the file name of the snapshot, eg. consul-20210901120000.snap
+	Snapshot string `json:"snapshot"`
+	// ClaimName is the claim holding the snapshot, the claim of the backup Storage if it is not set
+	ClaimName string `json:"claimName,omitempty"`
 }
 
 type AppReporteData struct {
@@ -44,6 +98,8 @@ type AppReporteData struct {
 	MetricsClusterIp string `json:"metricsClusterIp,omitempty"`
 	//Ip addresses of the services that received IP address from the private network
 	PrivateNetworkIpAddress map[string]string `json:"privateNetworkIpAddresses,omitempty"`
+	//Name of the Secret holding the ACL bootstrap token
+	ACLBootstrapTokenSecret string `json:"aclBootstrapTokenSecret,omitempty"`
 }
 
 // ConsulStatus defines the observed state of Consul
@@ -55,6 +111,33 @@ type ConsulStatus struct {
 	AppStatus        AppStatus                       `json:"appStatus,omitempty"`
 	AppReportedData  AppReporteData                  `json:"appReportedData,omitempty"`
 	AppliedResources []k8sdynamic.ResourceDescriptor `json:"appliedResources,omitempty"`
+	// Phase is the step of the deployment the operator is working on, every reconciliation advances it by one
+	Phase Phase `json:"phase,omitempty"`
+	// HelmRelease is the name of the helm release of the application
+	HelmRelease string `json:"helmRelease,omitempty"`
+	// Backup reports the snapshots of the Consul state
+	Backup BackupStatus `json:"backup,omitempty"`
+	// ObservedGeneration is the generation of the spec that has been deployed
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions represent the latest available observations of the Consul state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// BackupStatus is the state of the backups and of the restore
+type BackupStatus struct {
+	// LastScheduleTime is the start time of the last backup job
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// LastSuccessfulBackup is the completion time of the last successful backup
+	LastSuccessfulBackup *metav1.Time `json:"lastSuccessfulBackup,omitempty"`
+	// LastSnapshot is the file name of the snapshot of the last successful backup
+	LastSnapshot string `json:"lastSnapshot,omitempty"`
+	// RestoredSnapshot is the snapshot which has been restored into the cluster
+	RestoredSnapshot string `json:"restoredSnapshot,omitempty"`
 }
 
 type Ports struct {
@@ -74,6 +157,8 @@ type Ports struct {
 // Consul is the Schema for the consuls API
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:path=consuls,scope=Namespaced
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // +k8s:openapi-gen=true
 type Consul struct {
 	metav1.TypeMeta   `json:",inline"`
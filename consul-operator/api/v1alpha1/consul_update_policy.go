@@ -0,0 +1,103 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package v1alpha1
+
+import (
+	"reflect"
+)
+
+// UpdatePolicy tells how the change of a spec field is applied to the deployed application. The policies are
+// ordered by their impact, an update is executed with the strongest policy of the changed fields.
+type UpdatePolicy int
+
+const (
+	// UpdateInPlace upgrades the helm release with the re-templated app-deployment
+	UpdateInPlace UpdatePolicy = iota
+	// UpdateRequestResources requests the platform resources again before upgrading the helm release
+	UpdateRequestResources
+	// UpdateRedeploy uninstalls the helm release and deploys the application again
+	UpdateRedeploy
+	// UpdateImmutable rejects the change of the field
+	UpdateImmutable
+)
+
+func (p UpdatePolicy) String() string {
+	switch p {
+	case UpdateInPlace:
+		return "InPlace"
+	case UpdateRequestResources:
+		return "RequestResources"
+	case UpdateRedeploy:
+		return "Redeploy"
+	case UpdateImmutable:
+		return "Immutable"
+	}
+	return "Unknown"
+}
+
+// FieldUpdatePolicy is the update policy of a spec field
+type FieldUpdatePolicy struct {
+	// Path of the field in the spec
+	Path    string
+	Policy  UpdatePolicy
+	changed func(old, new *ConsulSpec) bool
+}
+
+// FieldUpdatePolicies declares the update policy of every spec field. A field without policy is redeployed.
+var FieldUpdatePolicies = []FieldUpdatePolicy{
+	//A Storage is requested for every replica, the removed servers leave the cluster before the scale down
+	{"replicaCount", UpdateRequestResources, func(old, new *ConsulSpec) bool { return old.ReplicaCount != new.ReplicaCount }},
+	{"metricsDomainName", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.MetricsDomainName != new.MetricsDomainName }},
+	{"ports.uiPort", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.Ports.UiPort != new.Ports.UiPort }},
+	{"ports.altPort", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.Ports.AltPort != new.Ports.AltPort }},
+	{"ports.udpPort", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.Ports.UdpPort != new.Ports.UdpPort }},
+	{"ports.httpPort", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.Ports.HttpPort != new.Ports.HttpPort }},
+	{"ports.httpsPort", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.Ports.HttpsPort != new.Ports.HttpsPort }},
+	{"ports.consulDns", UpdateInPlace, func(old, new *ConsulSpec) bool { return old.Ports.ConsulDns != new.Ports.ConsulDns }},
+	//The members of the cluster cannot talk to each other during a rolling update of these ports
+	{"ports.serflan", UpdateRedeploy, func(old, new *ConsulSpec) bool { return old.Ports.Serflan != new.Ports.Serflan }},
+	{"ports.serfwan", UpdateRedeploy, func(old, new *ConsulSpec) bool { return old.Ports.Serfwan != new.Ports.Serfwan }},
+	{"ports.server", UpdateRedeploy, func(old, new *ConsulSpec) bool { return old.Ports.Server != new.Ports.Server }},
+	{"privateNetworkAccess", UpdateRequestResources, func(old, new *ConsulSpec) bool {
+		return !reflect.DeepEqual(old.PrivateNetworkAccess, new.PrivateNetworkAccess)
+	}},
+	//The agents have to be restarted together, the members with and without encryption cannot talk to each other
+	{"security", UpdateRedeploy, func(old, new *ConsulSpec) bool { return !reflect.DeepEqual(old.Security, new.Security) }},
+	//The Storage of the snapshots is requested again, the schedule is applied by the next backup
+	{"backup", UpdateRequestResources, func(old, new *ConsulSpec) bool { return !reflect.DeepEqual(old.Backup, new.Backup) }},
+	//A snapshot is restored only into a fresh cluster
+	{"restore", UpdateImmutable, func(old, new *ConsulSpec) bool { return !reflect.DeepEqual(old.Restore, new.Restore) }},
+}
+
+// ChangedFields returns the update policies of the fields which differ in the old spec
+func (s *ConsulSpec) ChangedFields(old *ConsulSpec) []FieldUpdatePolicy {
+	var changed []FieldUpdatePolicy
+	for _, field := range FieldUpdatePolicies {
+		if field.changed(old, s) {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
+
+// UpdatePolicy returns the policy which applies all of the changes since the old spec
+func (s *ConsulSpec) UpdatePolicy(old *ConsulSpec) UpdatePolicy {
+	changed := s.ChangedFields(old)
+	if len(changed) == 0 {
+		if reflect.DeepEqual(*s, *old) {
+			return UpdateInPlace
+		}
+		//A field has been added to the spec without declaring its policy
+		return UpdateRedeploy
+	}
+
+	policy := UpdateInPlace
+	for _, field := range changed {
+		if field.Policy > policy {
+			policy = field.Policy
+		}
+	}
+	return policy
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdatePolicy(t *testing.T) {
+	tests := []struct {
+		modify  func(*ConsulSpec)
+		changed []string
+		policy  UpdatePolicy
+	}{
+		{func(s *ConsulSpec) {}, nil, UpdateInPlace},
+		{func(s *ConsulSpec) { s.ReplicaCount = 3 }, []string{"replicaCount"}, UpdateRequestResources},
+		{func(s *ConsulSpec) { s.MetricsDomainName = "metrics.example.com" }, []string{"metricsDomainName"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.UiPort++ }, []string{"ports.uiPort"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.AltPort++ }, []string{"ports.altPort"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.UdpPort++ }, []string{"ports.udpPort"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.HttpPort++ }, []string{"ports.httpPort"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.HttpsPort++ }, []string{"ports.httpsPort"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.ConsulDns++ }, []string{"ports.consulDns"}, UpdateInPlace},
+		{func(s *ConsulSpec) { s.Ports.Serflan++ }, []string{"ports.serflan"}, UpdateRedeploy},
+		{func(s *ConsulSpec) { s.Ports.Serfwan++ }, []string{"ports.serfwan"}, UpdateRedeploy},
+		{func(s *ConsulSpec) { s.Ports.Server++ }, []string{"ports.server"}, UpdateRedeploy},
+		{func(s *ConsulSpec) { s.PrivateNetworkAccess = &PrivateNetworkAccess{ApnUUID: "apn"} },
+			[]string{"privateNetworkAccess"}, UpdateRequestResources},
+		{func(s *ConsulSpec) { s.Security = &Security{ACL: true} }, []string{"security"}, UpdateRedeploy},
+		{func(s *ConsulSpec) { s.Backup = &Backup{Interval: metav1.Duration{Duration: time.Hour}} },
+			[]string{"backup"}, UpdateRequestResources},
+		{func(s *ConsulSpec) { s.Restore = &Restore{Snapshot: "consul.snap"} }, []string{"restore"}, UpdateImmutable},
+		//The strongest policy of the changed fields is applied
+		{func(s *ConsulSpec) {
+			s.MetricsDomainName = "metrics.example.com"
+			s.ReplicaCount = 3
+		}, []string{"replicaCount", "metricsDomainName"}, UpdateRequestResources},
+		{func(s *ConsulSpec) {
+			s.Ports.UiPort++
+			s.Ports.Server++
+			s.Backup = &Backup{}
+		}, []string{"ports.uiPort", "ports.server", "backup"}, UpdateRedeploy},
+	}
+	for _, test := range tests {
+		name := strings.Join(test.changed, ",")
+		if name == "" {
+			name = "unchanged"
+		}
+		t.Run(name, func(t *testing.T) {
+			old := newConsul().Spec
+			spec := old.DeepCopy()
+			test.modify(spec)
+
+			var changed []string
+			for _, field := range spec.ChangedFields(&old) {
+				changed = append(changed, field.Path)
+			}
+			if !reflect.DeepEqual(changed, test.changed) {
+				t.Errorf("expected changed fields %v, got %v", test.changed, changed)
+			}
+			if policy := spec.UpdatePolicy(&old); policy != test.policy {
+				t.Errorf("expected policy %v, got %v", test.policy, policy)
+			}
+		})
+	}
+}
+
+func TestUpdatePolicyOfUndeclaredField(t *testing.T) {
+	declared := FieldUpdatePolicies
+	defer func() { FieldUpdatePolicies = declared }()
+	FieldUpdatePolicies = nil
+	for _, field := range declared {
+		if field.Path != "metricsDomainName" {
+			FieldUpdatePolicies = append(FieldUpdatePolicies, field)
+		}
+	}
+
+	old := newConsul().Spec
+	spec := old.DeepCopy()
+	spec.MetricsDomainName = "metrics.example.com"
+
+	if changed := spec.ChangedFields(&old); len(changed) != 0 {
+		t.Errorf("expected no declared changed fields, got %v", changed)
+	}
+	if policy := spec.UpdatePolicy(&old); policy != UpdateRedeploy {
+		t.Errorf("expected policy %v for an undeclared field, got %v", UpdateRedeploy, policy)
+	}
+}
+
+// TestUpdatePolicyDeclared checks that every field of the spec has a declared update policy, the fields of the ports
+// are declared one by one
+func TestUpdatePolicyDeclared(t *testing.T) {
+	declared := make(map[string]bool)
+	for _, field := range FieldUpdatePolicies {
+		declared[field.Path] = true
+	}
+	jsonNames := func(structType reflect.Type, prefix string) []string {
+		var names []string
+		for i := 0; i < structType.NumField(); i++ {
+			names = append(names, prefix+strings.Split(structType.Field(i).Tag.Get("json"), ",")[0])
+		}
+		return names
+	}
+
+	paths := jsonNames(reflect.TypeOf(Ports{}), "ports.")
+	for _, path := range jsonNames(reflect.TypeOf(ConsulSpec{}), "") {
+		if path != "ports" {
+			paths = append(paths, path)
+		}
+	}
+	for _, path := range paths {
+		if !declared[path] {
+			t.Errorf("the update policy of %v is not declared", path)
+		}
+	}
+}
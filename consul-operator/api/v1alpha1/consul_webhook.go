@@ -0,0 +1,227 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package v1alpha1
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Default values of the Consul spec, the ports are the standard ports of Consul
+const (
+	DefaultReplicaCount = 1
+
+	DefaultUiPort    = 8500
+	DefaultAltPort   = 8400
+	DefaultUdpPort   = 53
+	DefaultHttpPort  = 8080
+	DefaultHttpsPort = 8443
+	DefaultSerflan   = 8301
+	DefaultSerfwan   = 8302
+	DefaultConsulDns = 8600
+	DefaultServer    = 8300
+
+	DefaultBackupRetention   = 7
+	DefaultBackupStorageSize = "1Gi"
+	// MinBackupInterval protects the cluster from continuous snapshotting
+	MinBackupInterval = time.Minute
+)
+
+var consullog = logf.Log.WithName("consul-resource")
+
+func (r *Consul) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-app-dac-nokia-com-v1alpha1-consul,mutating=true,failurePolicy=fail,sideEffects=None,groups=app.dac.nokia.com,resources=consuls,verbs=create;update,versions=v1alpha1,name=mconsul.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Consul{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Consul) Default() {
+	consullog.Info("default", "name", r.Name)
+
+	if r.Spec.ReplicaCount == 0 {
+		r.Spec.ReplicaCount = DefaultReplicaCount
+	}
+
+	ports := &r.Spec.Ports
+	setDefaultPort(&ports.UiPort, DefaultUiPort)
+	setDefaultPort(&ports.AltPort, DefaultAltPort)
+	setDefaultPort(&ports.UdpPort, DefaultUdpPort)
+	setDefaultPort(&ports.HttpPort, DefaultHttpPort)
+	setDefaultPort(&ports.HttpsPort, DefaultHttpsPort)
+	setDefaultPort(&ports.Serflan, DefaultSerflan)
+	setDefaultPort(&ports.Serfwan, DefaultSerfwan)
+	setDefaultPort(&ports.ConsulDns, DefaultConsulDns)
+	setDefaultPort(&ports.Server, DefaultServer)
+
+	if backup := r.Spec.Backup; backup != nil {
+		if backup.Retention == 0 {
+			backup.Retention = DefaultBackupRetention
+		}
+		if backup.StorageSize == "" {
+			backup.StorageSize = DefaultBackupStorageSize
+		}
+	}
+}
+
+func setDefaultPort(port *int, value int) {
+	if *port == 0 {
+		*port = value
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-app-dac-nokia-com-v1alpha1-consul,mutating=false,failurePolicy=fail,sideEffects=None,groups=app.dac.nokia.com,resources=consuls,verbs=create;update,versions=v1alpha1,name=vconsul.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &Consul{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Consul) ValidateCreate() error {
+	consullog.Info("validate create", "name", r.Name)
+
+	return r.toInvalidError(r.Spec.validate(field.NewPath("spec")))
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Consul) ValidateUpdate(old runtime.Object) error {
+	consullog.Info("validate update", "name", r.Name)
+
+	oldConsul, ok := old.(*Consul)
+	if !ok {
+		return apierrors.NewBadRequest("the old object is not a Consul")
+	}
+	//Updates of the metadata or the status, eg. the removal of the finalizer, must not be blocked by a spec which
+	//was accepted before the validation was introduced
+	if reflect.DeepEqual(r.Spec, oldConsul.Spec) {
+		return nil
+	}
+
+	specPath := field.NewPath("spec")
+	allErrs := r.Spec.validate(specPath)
+	allErrs = append(allErrs, r.Spec.validateUpdate(&oldConsul.Spec, specPath)...)
+	return r.toInvalidError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Consul) ValidateDelete() error {
+	return nil
+}
+
+func (r *Consul) toInvalidError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Consul"}, r.Name, allErrs)
+}
+
+func (s *ConsulSpec) validate(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	//Raft needs the majority of the servers, an even number of servers does not tolerate more failures
+	if s.ReplicaCount < 1 || s.ReplicaCount%2 == 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("replicaCount"), s.ReplicaCount, "must be a positive odd number"))
+	}
+
+	allErrs = append(allErrs, s.Ports.validate(specPath.Child("ports"))...)
+
+	if pna := s.PrivateNetworkAccess; pna != nil {
+		pnaPath := specPath.Child("privateNetworkAccess")
+		if pna.ApnUUID != "" && len(pna.Networks) > 0 {
+			allErrs = append(allErrs, field.Forbidden(pnaPath.Child("networks"), "apnUUID and networks are mutually exclusive"))
+		}
+		allErrs = append(allErrs, validateRoutes(pna.AdditionalRoutes, pnaPath.Child("additionalRoutes"))...)
+		for i, network := range pna.Networks {
+			allErrs = append(allErrs, validateRoutes(network.AdditionalRoutes, pnaPath.Child("networks").Index(i).Child("additionalRoutes"))...)
+		}
+	}
+
+	if backup := s.Backup; backup != nil {
+		backupPath := specPath.Child("backup")
+		if backup.Interval.Duration < MinBackupInterval {
+			allErrs = append(allErrs, field.Invalid(backupPath.Child("interval"), backup.Interval.Duration.String(), "must be at least "+MinBackupInterval.String()))
+		}
+		if _, err := resource.ParseQuantity(backup.StorageSize); err != nil {
+			allErrs = append(allErrs, field.Invalid(backupPath.Child("storageSize"), backup.StorageSize, err.Error()))
+		}
+	}
+
+	if restore := s.Restore; restore != nil {
+		restorePath := specPath.Child("restore")
+		if restore.Snapshot == "" || strings.Contains(restore.Snapshot, "/") {
+			allErrs = append(allErrs, field.Invalid(restorePath.Child("snapshot"), restore.Snapshot, "must be a file name"))
+		}
+		if restore.ClaimName == "" && s.Backup == nil {
+			allErrs = append(allErrs, field.Required(restorePath.Child("claimName"), "required unless the backup is enabled"))
+		}
+	}
+	return allErrs
+}
+
+// validateUpdate rejects the changes of the immutable fields
+func (s *ConsulSpec) validateUpdate(old *ConsulSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, changed := range s.ChangedFields(old) {
+		if changed.Policy == UpdateImmutable {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child(changed.Path), "cannot be updated"))
+		}
+	}
+	return allErrs
+}
+
+func (p *Ports) validate(portsPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	ports := []struct {
+		name  string
+		value int
+	}{
+		{"uiPort", p.UiPort},
+		{"altPort", p.AltPort},
+		{"udpPort", p.UdpPort},
+		{"httpPort", p.HttpPort},
+		{"httpsPort", p.HttpsPort},
+		{"serflan", p.Serflan},
+		{"serfwan", p.Serfwan},
+		{"consulDns", p.ConsulDns},
+		{"server", p.Server},
+	}
+	used := make(map[int]string)
+	for _, port := range ports {
+		if port.value < 1 || port.value > 65535 {
+			allErrs = append(allErrs, field.Invalid(portsPath.Child(port.name), port.value, "must be between 1 and 65535"))
+			continue
+		}
+		if other, found := used[port.value]; found {
+			allErrs = append(allErrs, field.Invalid(portsPath.Child(port.name), port.value, "collides with "+other))
+			continue
+		}
+		used[port.value] = port.name
+	}
+	return allErrs
+}
+
+func validateRoutes(routes []string, routesPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, route := range routes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			allErrs = append(allErrs, field.Invalid(routesPath.Index(i), route, "must be a CIDR, eg. 10.0.0.0/24"))
+		}
+	}
+	return allErrs
+}
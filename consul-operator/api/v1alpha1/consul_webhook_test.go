@@ -0,0 +1,189 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package v1alpha1
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newConsul returns a valid Consul with the defaults applied
+func newConsul() *Consul {
+	consul := &Consul{ObjectMeta: metav1.ObjectMeta{Name: "consul", Namespace: "app"}}
+	consul.Default()
+	return consul
+}
+
+// invalidFields returns the sorted field paths of the causes of the Invalid error, nil if err is nil
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("expected an Invalid error, got %v", err)
+	}
+	var fields []string
+	for _, cause := range err.(apierrors.APIStatus).Status().Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestDefault(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   ConsulSpec
+		expect func(*ConsulSpec)
+	}{
+		{
+			name: "empty spec",
+			spec: ConsulSpec{},
+			expect: func(s *ConsulSpec) {
+				s.ReplicaCount = DefaultReplicaCount
+				s.Ports = Ports{
+					UiPort: DefaultUiPort, AltPort: DefaultAltPort, UdpPort: DefaultUdpPort,
+					HttpPort: DefaultHttpPort, HttpsPort: DefaultHttpsPort, Serflan: DefaultSerflan,
+					Serfwan: DefaultSerfwan, ConsulDns: DefaultConsulDns, Server: DefaultServer,
+				}
+			},
+		},
+		{
+			name: "set values are kept",
+			spec: ConsulSpec{ReplicaCount: 3, Ports: Ports{UiPort: 18500, Server: 18300}},
+			expect: func(s *ConsulSpec) {
+				s.Ports.AltPort, s.Ports.UdpPort, s.Ports.HttpPort = DefaultAltPort, DefaultUdpPort, DefaultHttpPort
+				s.Ports.HttpsPort, s.Ports.Serflan, s.Ports.Serfwan = DefaultHttpsPort, DefaultSerflan, DefaultSerfwan
+				s.Ports.ConsulDns = DefaultConsulDns
+			},
+		},
+		{
+			name: "backup defaults",
+			spec: ConsulSpec{ReplicaCount: 1, Backup: &Backup{Interval: metav1.Duration{Duration: time.Hour}}},
+			expect: func(s *ConsulSpec) {
+				s.Ports = newConsul().Spec.Ports
+				s.Backup.Retention = DefaultBackupRetention
+				s.Backup.StorageSize = DefaultBackupStorageSize
+			},
+		},
+		{
+			name: "backup values are kept",
+			spec: ConsulSpec{ReplicaCount: 1, Backup: &Backup{Retention: 2, StorageSize: "5Gi"}},
+			expect: func(s *ConsulSpec) {
+				s.Ports = newConsul().Spec.Ports
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			consul := &Consul{Spec: *test.spec.DeepCopy()}
+			consul.Default()
+
+			expected := test.spec.DeepCopy()
+			test.expect(expected)
+			if !reflect.DeepEqual(consul.Spec, *expected) {
+				t.Errorf("expected %+v, got %+v", *expected, consul.Spec)
+			}
+		})
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*ConsulSpec)
+		fields []string
+	}{
+		{"defaults", func(s *ConsulSpec) {}, nil},
+		{"three servers", func(s *ConsulSpec) { s.ReplicaCount = 3 }, nil},
+		{"no servers", func(s *ConsulSpec) { s.ReplicaCount = 0 }, []string{"spec.replicaCount"}},
+		{"negative servers", func(s *ConsulSpec) { s.ReplicaCount = -1 }, []string{"spec.replicaCount"}},
+		{"even servers", func(s *ConsulSpec) { s.ReplicaCount = 2 }, []string{"spec.replicaCount"}},
+		{"port out of range", func(s *ConsulSpec) { s.Ports.UiPort = 65536 }, []string{"spec.ports.uiPort"}},
+		{"port not set", func(s *ConsulSpec) { s.Ports.Server = 0 }, []string{"spec.ports.server"}},
+		{"port collision", func(s *ConsulSpec) { s.Ports.Serfwan = s.Ports.Serflan }, []string{"spec.ports.serfwan"}},
+		{"route of the private network", func(s *ConsulSpec) {
+			s.PrivateNetworkAccess = &PrivateNetworkAccess{ApnUUID: "apn", AdditionalRoutes: []string{"10.0.0.0/24", "10.0.1.0"}}
+		}, []string{"spec.privateNetworkAccess.additionalRoutes[1]"}},
+		{"route of a network", func(s *ConsulSpec) {
+			s.PrivateNetworkAccess = &PrivateNetworkAccess{Networks: []Network{
+				{ApnUUID: "apn1", AdditionalRoutes: []string{"10.0.0.0/24"}},
+				{ApnUUID: "apn2", AdditionalRoutes: []string{"not-a-cidr"}},
+			}}
+		}, []string{"spec.privateNetworkAccess.networks[1].additionalRoutes[0]"}},
+		{"apn and networks", func(s *ConsulSpec) {
+			s.PrivateNetworkAccess = &PrivateNetworkAccess{ApnUUID: "apn", Networks: []Network{{ApnUUID: "apn1"}}}
+		}, []string{"spec.privateNetworkAccess.networks"}},
+		{"apn only", func(s *ConsulSpec) {
+			s.PrivateNetworkAccess = &PrivateNetworkAccess{ApnUUID: "apn"}
+		}, nil},
+		{"short backup interval", func(s *ConsulSpec) {
+			s.Backup = &Backup{Interval: metav1.Duration{Duration: time.Second}, StorageSize: "1Gi"}
+		}, []string{"spec.backup.interval"}},
+		{"invalid backup storage size", func(s *ConsulSpec) {
+			s.Backup = &Backup{Interval: metav1.Duration{Duration: time.Hour}, StorageSize: "lots"}
+		}, []string{"spec.backup.storageSize"}},
+		{"snapshot path", func(s *ConsulSpec) {
+			s.Restore = &Restore{Snapshot: "../consul.snap", ClaimName: "snapshots"}
+		}, []string{"spec.restore.snapshot"}},
+		{"restore without claim and backup", func(s *ConsulSpec) {
+			s.Restore = &Restore{Snapshot: "consul.snap"}
+		}, []string{"spec.restore.claimName"}},
+		{"more errors", func(s *ConsulSpec) {
+			s.ReplicaCount = 4
+			s.Ports.HttpPort = s.Ports.UiPort
+		}, []string{"spec.ports.httpPort", "spec.replicaCount"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			consul := newConsul()
+			test.modify(&consul.Spec)
+
+			fields := invalidFields(t, consul.ValidateCreate())
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("expected invalid fields %v, got %v", test.fields, fields)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	restore := func(s *ConsulSpec) { s.Restore = &Restore{Snapshot: "consul.snap", ClaimName: "snapshots"} }
+	tests := []struct {
+		name   string
+		old    func(*ConsulSpec)
+		modify func(*ConsulSpec)
+		fields []string
+	}{
+		{"scale up", func(s *ConsulSpec) {}, func(s *ConsulSpec) { s.ReplicaCount = 3 }, nil},
+		{"scale to even", func(s *ConsulSpec) {}, func(s *ConsulSpec) { s.ReplicaCount = 2 }, []string{"spec.replicaCount"}},
+		{"port change", func(s *ConsulSpec) {}, func(s *ConsulSpec) { s.Ports.UiPort = 18500 }, nil},
+		{"restore added", func(s *ConsulSpec) {}, restore, []string{"spec.restore"}},
+		{"restore removed", restore, func(s *ConsulSpec) { s.Restore = nil }, []string{"spec.restore"}},
+		{"restore changed", restore, func(s *ConsulSpec) { s.Restore.Snapshot = "other.snap" }, []string{"spec.restore"}},
+		{"restore kept", restore, func(s *ConsulSpec) { s.MetricsDomainName = "metrics.example.com" }, nil},
+		//The metadata updates of a spec accepted before the validation was introduced are not blocked
+		{"spec not changed", func(s *ConsulSpec) { s.ReplicaCount = 2 }, func(s *ConsulSpec) {}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := newConsul()
+			test.old(&old.Spec)
+			consul := old.DeepCopy()
+			test.modify(&consul.Spec)
+
+			fields := invalidFields(t, consul.ValidateUpdate(old))
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("expected invalid fields %v, got %v", test.fields, fields)
+			}
+		})
+	}
+}
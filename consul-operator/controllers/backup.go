@@ -0,0 +1,244 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/libs/kubelib"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// The claim granted for the backup Storage of the instance is named after the Storage, see
+// resource-reqs/storage_for_backup.yaml. The Storage outlives the instance.
+const (
+	consulImage        = "registry.dac.nokia.com/public/consul:1.4.4"
+	imagePullSecret    = "dacsecret"
+	snapshotDir        = "/backup"
+	snapshotVolume     = "snapshots"
+	snapshotTimeFormat = "20060102150405"
+
+	//The job annotation holding the file name of the snapshot
+	snapshotAnnotation = "app.dac.nokia.com/snapshot"
+)
+
+// The pods of a job are retried with exponential delay, eg. until the cluster has elected its leader
+var snapshotJobBackoffLimit int32 = 6
+
+// reconcileBackup collects the result of the last backup job and starts the next one when it is due. The finished
+// jobs are enqueued by the watch of the jobs, the next backup by the returned requeue time.
+func (r *ConsulReconciler) reconcileBackup(logger logr.Logger, instance *app.Consul, namespace string) (reconcile.Result, error) {
+	backup := instance.Spec.Backup
+	if backup == nil {
+		return reconcile.Result{}, nil
+	}
+
+	names := namesOf(instance)
+	job, err := r.getJob(namespace, names.BackupJob)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if job != nil {
+		finished, succeeded := jobResult(job)
+		if !finished {
+			logger.V(1).Info("Backup is in progress", "snapshot", job.Annotations[snapshotAnnotation])
+			return reconcile.Result{}, nil
+		}
+		r.recordBackupResult(logger, instance, job, succeeded)
+		if err := r.deleteJob(job); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := r.updateStatus(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	//The spec is validated by the webhook, the operator may run without it
+	if backup.Interval.Duration < app.MinBackupInterval {
+		err := errors.Errorf("the backup interval %v is shorter than %v, the backups are not scheduled",
+			backup.Interval.Duration, app.MinBackupInterval)
+		logger.Error(err, "invalid backup spec")
+		r.setFailedCondition(instance, app.ConditionBackupSucceeded, app.ReasonBackupInvalid, err)
+		return reconcile.Result{}, nil
+	}
+
+	now := time.Now()
+	if last := instance.Status.Backup.LastScheduleTime; last != nil {
+		if next := last.Add(backup.Interval.Duration); now.Before(next) {
+			return reconcile.Result{RequeueAfter: next.Sub(now)}, nil
+		}
+	}
+
+	snapshot := "consul-" + now.UTC().Format(snapshotTimeFormat) + ".snap"
+	script := fmt.Sprintf("set -e\nconsul snapshot save %v/%v\n", snapshotDir, snapshot)
+	if backup.Retention >= 1 {
+		//The snapshots are named after their time, the oldest ones above the retention are removed
+		script += fmt.Sprintf("ls -1 %v/consul-*.snap | sort -r | tail -n +%v | xargs -r rm -f\n",
+			snapshotDir, backup.Retention+1)
+	} else {
+		logger.Info("No valid retention is set, the snapshots are not pruned", "retention", backup.Retention)
+	}
+	if err := r.createSnapshotJob(instance, namespace, names.BackupJob, names.BackupStorage, snapshot, script); err != nil {
+		logger.Error(err, "failed to start the backup")
+		r.setFailedCondition(instance, app.ConditionBackupSucceeded, app.ReasonBackupFailed, err)
+		return reconcile.Result{}, err
+	}
+	logger.Info("Backup started", "snapshot", snapshot)
+
+	instance.Status.Backup.LastScheduleTime = &metav1.Time{Time: now}
+	if err := r.updateStatus(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: backup.Interval.Duration}, nil
+}
+
+func (r *ConsulReconciler) recordBackupResult(logger logr.Logger, instance *app.Consul, job *batchv1.Job, succeeded bool) {
+	snapshot := job.Annotations[snapshotAnnotation]
+	if !succeeded {
+		logger.Error(nil, "Backup failed", "snapshot", snapshot)
+		instance.SetCondition(app.ConditionBackupSucceeded, metav1.ConditionFalse, app.ReasonBackupFailed,
+			fmt.Sprintf("The backup job of the snapshot %v failed", snapshot))
+		return
+	}
+	logger.Info("Backup finished", "snapshot", snapshot)
+	instance.Status.Backup.LastSuccessfulBackup = job.Status.CompletionTime
+	instance.Status.Backup.LastSnapshot = snapshot
+	instance.SetCondition(app.ConditionBackupSucceeded, metav1.ConditionTrue, app.ReasonBackupSucceeded,
+		fmt.Sprintf("The snapshot %v has been saved", snapshot))
+}
+
+// isRestoreNeeded reports whether the snapshot of the spec still has to be restored into the deployed cluster
+func isRestoreNeeded(instance *app.Consul) bool {
+	return instance.Spec.Restore != nil && instance.Status.Backup.RestoredSnapshot != instance.Spec.Restore.Snapshot
+}
+
+// handleRestoring restores the snapshot into the freshly deployed cluster with a job, the phase is enqueued again
+// by the watch of the jobs when the job finishes. The restore runs after the ACL bootstrap, the default policy of the
+// cluster is deny so the job needs the bootstrap token.
+func (r *ConsulReconciler) handleRestoring(logger logr.Logger, instance *app.Consul, namespace string) (reconcile.Result, error) {
+	restore := instance.Spec.Restore
+	if !isRestoreNeeded(instance) {
+		return r.advancePhase(instance, app.PhaseRunning)
+	}
+
+	names := namesOf(instance)
+	job, err := r.getJob(namespace, names.RestoreJob)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if job == nil {
+		claim := restore.ClaimName
+		if claim == "" {
+			claim = names.BackupStorage
+		}
+		script := fmt.Sprintf("consul snapshot restore %v/%v\n", snapshotDir, restore.Snapshot)
+		if err := r.createSnapshotJob(instance, namespace, names.RestoreJob, claim, restore.Snapshot, script); err != nil {
+			logger.Error(err, "Failed to start the restore")
+			r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonRestoreFailed, err)
+			return reconcile.Result{}, err
+		}
+		logger.Info("Restore started", "snapshot", restore.Snapshot, "claim", claim)
+		return reconcile.Result{}, nil
+	}
+
+	finished, succeeded := jobResult(job)
+	if !finished {
+		logger.V(1).Info("Restore is in progress", "snapshot", restore.Snapshot)
+		return reconcile.Result{}, nil
+	}
+	if err := r.deleteJob(job); err != nil {
+		return reconcile.Result{}, err
+	}
+	if !succeeded {
+		//The job is started again with backoff
+		err := errors.Errorf("the restore job of the snapshot %v failed", restore.Snapshot)
+		logger.Error(err, "Failed to restore the snapshot")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonRestoreFailed, err)
+		return reconcile.Result{}, err
+	}
+
+	logger.Info("Snapshot restored", "snapshot", restore.Snapshot)
+	instance.Status.Backup.RestoredSnapshot = restore.Snapshot
+	//The restore replaces the ACL state of the cluster by the one of the snapshot, the stored tokens are checked and
+	//created again by the ACL phase
+	return r.advancePhase(instance, nextPostDeployPhase(instance))
+}
+
+// createSnapshotJob starts a job executing the script with the consul CLI, the claim of the snapshots is mounted to
+// the snapshot directory. The job is owned by the Consul instance.
+func (r *ConsulReconciler) createSnapshotJob(instance *app.Consul, namespace, name, claim, snapshot, script string) error {
+	job := kubelib.CreateJob(name)
+	job.Namespace = namespace
+	job.Annotations = map[string]string{snapshotAnnotation: snapshot}
+	job.Spec.BackoffLimit = &snapshotJobBackoffLimit
+
+	container := kubelib.CreateContainer("snapshot", consulImage)
+	container.Command = []string{"/bin/sh", "-c", script}
+	names := namesOf(instance)
+	kubelib.AddEnvVar(container, "CONSUL_HTTP_ADDR", fmt.Sprintf("http://%v.%v.svc:%v", names.Service, namespace, consulHttpPort))
+	//The snapshot API needs a management token if the ACL system is enabled
+	kubelib.AddSecretEnvVar(container, "CONSUL_HTTP_TOKEN", names.ACLTokenSecret, aclTokenSecretKey, true)
+	kubelib.AddContainerVolume(container, snapshotVolume, snapshotDir)
+
+	podSpec := &job.Spec.Template.Spec
+	kubelib.AddContainer(podSpec, container)
+	kubelib.AddPodClaimVolume(podSpec, snapshotVolume, claim)
+	kubelib.AddPullSecret(podSpec, imagePullSecret)
+
+	if err := controllerutil.SetControllerReference(instance, job, r.Scheme); err != nil {
+		return errors.Wrapf(err, "failed to set the owner of the job %v", name)
+	}
+	if err := r.Create(context.TODO(), job); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create the job %v", name)
+	}
+	return nil
+}
+
+// getJob returns the job or nil if it does not exist
+func (r *ConsulReconciler) getJob(namespace, name string) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, job); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to get the job %v", name)
+	}
+	return job, nil
+}
+
+func (r *ConsulReconciler) deleteJob(job *batchv1.Job) error {
+	err := r.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete the job %v", job.Name)
+	}
+	return nil
+}
+
+// jobResult reports whether the job has finished and whether it has succeeded
+func jobResult(job *batchv1.Job) (finished bool, succeeded bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,342 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newFinishedJob returns the job of the snapshot with the given result
+func newFinishedJob(name, snapshot string, conditionType batchv1.JobConditionType) *batchv1.Job {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   testNamespace,
+		Annotations: map[string]string{snapshotAnnotation: snapshot},
+	}}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+	completion := metav1.Now()
+	job.Status.CompletionTime = &completion
+	return job
+}
+
+// getJobOf returns the job, nil if it does not exist
+func getJobOf(t *testing.T, r *ConsulReconciler, name string) *batchv1.Job {
+	t.Helper()
+	job := &batchv1.Job{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: testNamespace, Name: name}, job); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return job
+}
+
+func newBackupConsul() *app.Consul {
+	instance := newTestConsul("consul", app.PhaseRunning)
+	instance.Spec.Backup = &app.Backup{Interval: metav1.Duration{Duration: time.Hour}, Retention: 3}
+	return instance
+}
+
+func TestReconcileBackupDisabled(t *testing.T) {
+	instance := newTestConsul("consul", app.PhaseRunning)
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.reconcileBackup(log, instance, testNamespace)
+	if err != nil || result.RequeueAfter != 0 {
+		t.Errorf("expected no requeue without error, got %+v, %v", result, err)
+	}
+	if job := getJobOf(t, r, testNames.BackupJob); job != nil {
+		t.Errorf("expected no backup job, got %v", job.Name)
+	}
+}
+
+func TestReconcileBackupStart(t *testing.T) {
+	instance := newBackupConsul()
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.reconcileBackup(log, instance, testNamespace)
+	if err != nil || result.RequeueAfter != time.Hour {
+		t.Fatalf("expected requeue after the interval without error, got %+v, %v", result, err)
+	}
+	job := getJobOf(t, r, testNames.BackupJob)
+	if job == nil {
+		t.Fatalf("expected the backup job")
+	}
+	snapshot := job.Annotations[snapshotAnnotation]
+	if !strings.HasPrefix(snapshot, "consul-") || !strings.HasSuffix(snapshot, ".snap") {
+		t.Errorf("unexpected snapshot name %v", snapshot)
+	}
+	if owner := metav1.GetControllerOf(job); owner == nil || owner.Name != instance.Name {
+		t.Errorf("expected the job to be owned by the instance, got %+v", owner)
+	}
+	script := job.Spec.Template.Spec.Containers[0].Command[2]
+	if !strings.Contains(script, "consul snapshot save "+snapshotDir+"/"+snapshot) || !strings.Contains(script, "tail -n +4") {
+		t.Errorf("unexpected backup script %q", script)
+	}
+	claim := job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim
+	if claim == nil || claim.ClaimName != testNames.BackupStorage {
+		t.Errorf("expected the backup claim to be mounted, got %+v", job.Spec.Template.Spec.Volumes)
+	}
+	if stored := getConsul(t, r, instance); stored.Status.Backup.LastScheduleTime == nil {
+		t.Errorf("expected the schedule time to be recorded")
+	}
+}
+
+func TestReconcileBackupWithoutWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  app.Backup
+		started bool
+		pruned  bool
+		invalid bool
+		requeue time.Duration
+	}{
+		{"no retention", app.Backup{Interval: metav1.Duration{Duration: 6 * time.Hour}}, true, false, false, 6 * time.Hour},
+		{"no interval", app.Backup{Retention: 3}, false, false, true, 0},
+		{"interval too short", app.Backup{Interval: metav1.Duration{Duration: time.Second}, Retention: 3}, false, false, true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//The spec is neither defaulted nor validated by the webhooks
+			instance := newTestConsul("consul", app.PhaseRunning)
+			backup := test.backup
+			instance.Spec.Backup = &backup
+			r, _ := newTestReconciler(t, instance)
+
+			result, err := r.reconcileBackup(log, instance, testNamespace)
+			if err != nil || result.RequeueAfter != test.requeue {
+				t.Fatalf("expected requeue after %v without error, got %+v, %v", test.requeue, result, err)
+			}
+			job := getJobOf(t, r, testNames.BackupJob)
+			if (job != nil) != test.started {
+				t.Fatalf("expected backup job started %v, got %v", test.started, job)
+			}
+			if job != nil {
+				if script := job.Spec.Template.Spec.Containers[0].Command[2]; strings.Contains(script, "rm -f") != test.pruned {
+					t.Errorf("expected pruning %v, got script %q", test.pruned, script)
+				}
+			}
+			stored := getConsul(t, r, instance)
+			if test.invalid {
+				expectCondition(t, stored, app.ConditionBackupSucceeded, metav1.ConditionFalse, app.ReasonBackupInvalid)
+				if stored.Status.Backup.LastScheduleTime != nil {
+					t.Errorf("expected no schedule time")
+				}
+			}
+		})
+	}
+}
+
+func TestRenderBackupStorageWithoutSize(t *testing.T) {
+	dir := withDeploymentDir(t)
+	instance := newTestConsul("consul", app.PhaseTemplating)
+	instance.Spec.Backup = &app.Backup{Interval: metav1.Duration{Duration: time.Hour}}
+
+	if _, err := renderTemplates(instance, testNamespace, resourceReqsDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	storage, err := ioutil.ReadFile(generatedFile(dir, instance, resourceReqsDir, "storage_for_backup.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(storage), "size: 1Gi") {
+		t.Errorf("expected the default size, got %s", storage)
+	}
+}
+
+func TestReconcileBackupNotDue(t *testing.T) {
+	instance := newBackupConsul()
+	instance.Status.Backup.LastScheduleTime = &metav1.Time{Time: time.Now().Add(-20 * time.Minute)}
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.reconcileBackup(log, instance, testNamespace)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.RequeueAfter <= 39*time.Minute || result.RequeueAfter > 40*time.Minute {
+		t.Errorf("expected requeue after the rest of the interval, got %v", result.RequeueAfter)
+	}
+	if job := getJobOf(t, r, testNames.BackupJob); job != nil {
+		t.Errorf("expected no backup job, got %v", job.Name)
+	}
+}
+
+func TestReconcileBackupInProgress(t *testing.T) {
+	instance := newBackupConsul()
+	job := newFinishedJob(testNames.BackupJob, "consul-1.snap", batchv1.JobComplete)
+	job.Status.Conditions = nil
+	r, _ := newTestReconciler(t, instance, job)
+
+	//The finished job is enqueued by the watch of the jobs
+	result, err := r.reconcileBackup(log, instance, testNamespace)
+	if err != nil || result.RequeueAfter != 0 {
+		t.Errorf("expected no requeue without error, got %+v, %v", result, err)
+	}
+	if getJobOf(t, r, testNames.BackupJob) == nil {
+		t.Errorf("expected the job to be kept")
+	}
+}
+
+func TestReconcileBackupResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		condition    batchv1.JobConditionType
+		status       metav1.ConditionStatus
+		reason       string
+		lastSnapshot string
+	}{
+		{"succeeded", batchv1.JobComplete, metav1.ConditionTrue, app.ReasonBackupSucceeded, "consul-1.snap"},
+		{"failed", batchv1.JobFailed, metav1.ConditionFalse, app.ReasonBackupFailed, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newBackupConsul()
+			instance.Status.Backup.LastScheduleTime = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+			r, _ := newTestReconciler(t, instance, newFinishedJob(testNames.BackupJob, "consul-1.snap", test.condition))
+
+			result, err := r.reconcileBackup(log, instance, testNamespace)
+			if err != nil || result.RequeueAfter == 0 {
+				t.Fatalf("expected requeue of the next backup without error, got %+v, %v", result, err)
+			}
+			stored := getConsul(t, r, instance)
+			expectCondition(t, stored, app.ConditionBackupSucceeded, test.status, test.reason)
+			if stored.Status.Backup.LastSnapshot != test.lastSnapshot {
+				t.Errorf("expected last snapshot %q, got %q", test.lastSnapshot, stored.Status.Backup.LastSnapshot)
+			}
+			if job := getJobOf(t, r, testNames.BackupJob); job != nil {
+				t.Errorf("expected the finished job to be deleted")
+			}
+		})
+	}
+}
+
+func newRestoreConsul(claim string) *app.Consul {
+	instance := newTestConsul("consul", app.PhaseRestoring)
+	instance.Spec.Restore = &app.Restore{Snapshot: "consul-1.snap", ClaimName: claim}
+	return instance
+}
+
+func TestHandleRestoringStart(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		want  string
+	}{
+		{"backup claim", "", testNames.BackupStorage},
+		{"given claim", "snapshots", "snapshots"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newRestoreConsul(test.claim)
+			r, _ := newTestReconciler(t, instance)
+
+			result, err := r.handleCreate(instance, testNamespace)
+			if err != nil || result.Requeue || result.RequeueAfter != 0 {
+				t.Fatalf("expected no requeue without error, got %+v, %v", result, err)
+			}
+			job := getJobOf(t, r, testNames.RestoreJob)
+			if job == nil {
+				t.Fatalf("expected the restore job")
+			}
+			if claim := job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != test.want {
+				t.Errorf("expected the claim %v to be mounted, got %+v", test.want, job.Spec.Template.Spec.Volumes)
+			}
+			if script := job.Spec.Template.Spec.Containers[0].Command[2]; !strings.Contains(script, "consul snapshot restore "+snapshotDir+"/consul-1.snap") {
+				t.Errorf("unexpected restore script %q", script)
+			}
+		})
+	}
+}
+
+func TestHandleRestoringResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition batchv1.JobConditionType
+		security  *app.Security
+		fails     bool
+		phase     app.Phase
+	}{
+		{"succeeded", batchv1.JobComplete, nil, false, app.PhaseRunning},
+		//The tokens replaced by the snapshot are checked again
+		{"succeeded with acl", batchv1.JobComplete, &app.Security{ACL: true}, false, app.PhaseBootstrappingACL},
+		{"failed", batchv1.JobFailed, nil, true, app.PhaseRestoring},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newRestoreConsul("")
+			instance.Spec.Security = test.security
+			r, _ := newTestReconciler(t, instance, newFinishedJob(testNames.RestoreJob, "consul-1.snap", test.condition))
+
+			_, err := r.handleCreate(instance, testNamespace)
+			if (err != nil) != test.fails {
+				t.Fatalf("expected failure %v, got %v", test.fails, err)
+			}
+			stored := getConsul(t, r, instance)
+			if stored.Status.Phase != test.phase {
+				t.Errorf("expected phase %v, got %v", test.phase, stored.Status.Phase)
+			}
+			if test.fails {
+				//The job is started again with backoff
+				expectCondition(t, stored, app.ConditionDegraded, metav1.ConditionTrue, app.ReasonRestoreFailed)
+			} else if stored.Status.Backup.RestoredSnapshot != "consul-1.snap" {
+				t.Errorf("expected the restored snapshot to be recorded, got %q", stored.Status.Backup.RestoredSnapshot)
+			}
+			if job := getJobOf(t, r, testNames.RestoreJob); job != nil {
+				t.Errorf("expected the finished job to be deleted")
+			}
+		})
+	}
+}
+
+func TestHandleRestoringDone(t *testing.T) {
+	instance := newRestoreConsul("")
+	instance.Status.Backup.RestoredSnapshot = "consul-1.snap"
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.handleCreate(instance, testNamespace)
+	if err != nil || !result.Requeue {
+		t.Fatalf("expected requeue without error, got %+v, %v", result, err)
+	}
+	if stored := getConsul(t, r, instance); stored.Status.Phase != app.PhaseRunning {
+		t.Errorf("expected phase %v, got %v", app.PhaseRunning, stored.Status.Phase)
+	}
+	if job := getJobOf(t, r, testNames.RestoreJob); job != nil {
+		t.Errorf("expected no restore job")
+	}
+}
+
+func TestJobResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []batchv1.JobCondition
+		finished   bool
+		succeeded  bool
+	}{
+		{"running", nil, false, false},
+		{"complete", []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}, true, true},
+		{"failed", []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}, true, false},
+		{"condition not true", []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionFalse}}, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := &batchv1.Job{Status: batchv1.JobStatus{Conditions: test.conditions}}
+			if finished, succeeded := jobResult(job); finished != test.finished || succeeded != test.succeeded {
+				t.Errorf("expected %v, %v, got %v, %v", test.finished, test.succeeded, finished, succeeded)
+			}
+		})
+	}
+}
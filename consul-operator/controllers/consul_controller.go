@@ -6,19 +6,25 @@ package controllers
 
 import (
 	"context"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/licenceexpired"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/monitoring"
 )
 
 var log = logf.Log.WithName("controller_consul")
@@ -27,6 +33,12 @@ var log = logf.Log.WithName("controller_consul")
 type ConsulReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder records the events of the Consul instances
+	Recorder record.EventRecorder
+	// OwnerReferences enables setting the Consul instance as the controller owner of the applied resources
+	OwnerReferences bool
+	// requests enqueues the reconciliation of the instances from outside of the watches
+	requests chan event.GenericEvent
 }
 
 //+kubebuilder:rbac:groups=app.dac.nokia.com,resources=consuls,verbs=get;list;watch;create;update;patch;delete
@@ -35,6 +47,9 @@ type ConsulReconciler struct {
 //+kubebuilder:rbac:groups=ops.dac.nokia.com,resources=*,verbs=create;delete;get;list;patch;update;watch
 //+kubebuilder:rbac:groups=extensions,resources=ingresses,verbs=*
 //+kubebuilder:rbac:groups="",resources=pods;services;endpoints;events;configmaps;secrets,verbs=create;delete;get;list;watch;patch;update
+//+kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=create;delete;get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;patch;update;delete;deletecollection
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -59,6 +74,12 @@ func (r *ConsulReconciler) Reconcile(ctx context.Context, request ctrl.Request)
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			monitoring.Remove(request.NamespacedName)
+			licenceexpired.Remove(request.NamespacedName)
+			forgetSweeps(request.NamespacedName)
+			forgetRedeploy(request.NamespacedName)
+			forgetGrants(request.NamespacedName)
+			metrics.RemoveInstance(request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -92,5 +113,27 @@ func (r *ConsulReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	return nil
+	// Watch for the completion of the backup and restore jobs
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &app.Consul{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for the reconciliations requested by the recovery and by the licence handlers
+	r.requests = make(chan event.GenericEvent)
+	err = c.Watch(&source.Channel{Source: r.requests}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for the approval and the removal of the platform resource requests
+	if err := r.watchPlatformResources(mgr, c); err != nil {
+		return err
+	}
+
+	// Reattach the monitoring of the deployed instances after the restart of the operator
+	return r.setupRecovery(mgr)
 }
@@ -0,0 +1,183 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/licenceexpired"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/monitoring"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/util/finalizer"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// The annotation of the Consul CR which allows the removal of the finalizer without a complete cleanup. With the
+// value "true" the finalizer is removed at the next reconciliation, with a duration, eg. "15m", once the deletion has
+// been in progress for that long.
+const forceDeleteAnnotation = "app.dac.nokia.com/force-delete"
+
+// handleDelete uninstalls the application and deletes the applied platform resource requests. The finalizer is
+// removed only when the requests have disappeared or the deletion is forced, the failed steps are retried with
+// backoff and the progress is reported in the Terminating condition.
+func (r *ConsulReconciler) handleDelete(instance *app.Consul, namespace string) (reconcile.Result, error) {
+	logger := log.WithName("handlers").WithName("handleDelete").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
+	logger.Info("Called")
+
+	if !controllerutil.ContainsFinalizer(instance, finalizer.FinalizerId) {
+		return reconcile.Result{}, nil
+	}
+
+	key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+	monitoring.Remove(key)
+	licenceexpired.Remove(key)
+	forgetSweeps(key)
+	forgetRedeploy(key)
+	forgetGrants(key)
+	metrics.RemoveInstance(key)
+	instance.SetCondition(app.ConditionDeployed, metav1.ConditionFalse, app.ReasonDeleting, "The application is being deleted")
+	instance.SetCondition(app.ConditionReady, metav1.ConditionFalse, app.ReasonDeleting, "The application is being deleted")
+
+	pending, err := r.cleanUp(logger, instance, namespace)
+	if err == nil && len(pending) == 0 {
+		logger.Info("Cleanup finished")
+		instance.SetCondition(app.ConditionTerminating, metav1.ConditionTrue, app.ReasonCleanedUp, "The resources of the application have been removed")
+		return r.removeFinalizer(logger, instance)
+	}
+
+	if force, reason := isDeletionForced(logger, instance); force {
+		logger.Info("Deletion forced, the remaining resources are left behind", "reason", reason, "resources", pending)
+		instance.SetCondition(app.ConditionTerminating, metav1.ConditionTrue, app.ReasonForceDeleted, reason)
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, app.ReasonForceDeleted, "%v, left behind: %v", reason, resourceNames(pending))
+		return r.removeFinalizer(logger, instance)
+	}
+
+	if err != nil {
+		logger.Error(err, "cleanup failed, it is retried")
+		instance.SetCondition(app.ConditionTerminating, metav1.ConditionTrue, app.ReasonUndeployFailed, err.Error())
+		instance.SetCondition(app.ConditionDegraded, metav1.ConditionTrue, app.ReasonUndeployFailed, err.Error())
+		if err := r.updateStatus(instance); nil != err {
+			logger.Error(err, "status conditions update failed")
+		}
+		return reconcile.Result{}, err
+	}
+
+	names := resourceNames(pending)
+	logger.Info("Waiting for the release of the platform resources", "resources", names)
+	instance.SetCondition(app.ConditionTerminating, metav1.ConditionTrue, app.ReasonWaitingForRelease,
+		fmt.Sprintf("Waiting for the release of the platform resources %v", names))
+	if err := r.updateStatus(instance); nil != err {
+		logger.Error(err, "status conditions update failed")
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: releaseCheckInterval}, nil
+}
+
+// The steps of the cleanup done by helm and by the platform resource providers, the tests replace them
+var (
+	undeployRelease = func(namespace, release string) (bool, error) {
+		return helm.NewHelm(namespace, release).Undeploy()
+	}
+	isResourceReleased = platformres.IsResourceReleased
+)
+
+// cleanUp uninstalls the helm release and deletes the applied resources except the backup Storage. The resources which
+// have not disappeared yet are returned, they are kept in the applied resources of the status until then. Every step
+// can be repeated.
+func (r *ConsulReconciler) cleanUp(logger logr.Logger, instance *app.Consul, namespace string) ([]k8sdynamic.ResourceDescriptor, error) {
+	//The application is removed first, it uses the granted resources
+	uninstalled, err := undeployRelease(namespace, helmReleaseOf(instance))
+	r.recordHelmUndeploy(instance, uninstalled, err)
+	if err != nil {
+		return instance.Status.AppliedResources, errors.Wrap(err, "failed to uninstall the helm chart")
+	}
+	logger.V(1).Info("Helm release uninstalled")
+
+	if !r.OwnerReferences {
+		if err := r.deleteSecuritySecrets(namespace, namesOf(instance)); err != nil {
+			return instance.Status.AppliedResources, err
+		}
+	}
+
+	released := releasedResources(instance.Status.AppliedResources, namesOf(instance).BackupStorage)
+	k8sClient := newDynClient()
+	if err := k8sClient.DeleteResources(released); err != nil {
+		return instance.Status.AppliedResources, errors.Wrap(err, "failed to delete the resources")
+	}
+
+	//The platform resource providers release the granted resources before the requests disappear
+	var pending []k8sdynamic.ResourceDescriptor
+	for _, resource := range released {
+		released, err := isResourceReleased(resource)
+		if err != nil {
+			return instance.Status.AppliedResources, err
+		}
+		if !released {
+			pending = append(pending, resource)
+		}
+	}
+	instance.Status.AppliedResources = pending
+	return pending, nil
+}
+
+// releasedResources returns the applied resources to be released with the instance. The backup Storage is kept, so its
+// snapshots can be restored into a new instance of the namespace, it has to be deleted manually when not needed.
+func releasedResources(applied []k8sdynamic.ResourceDescriptor, backupStorage string) []k8sdynamic.ResourceDescriptor {
+	var released []k8sdynamic.ResourceDescriptor
+	for _, resource := range applied {
+		if platformres.IsStorage(resource) && resource.Name == backupStorage {
+			continue
+		}
+		released = append(released, resource)
+	}
+	return released
+}
+
+// isDeletionForced reports whether the finalizer can be removed without a complete cleanup and the reason of it
+func isDeletionForced(logger logr.Logger, instance *app.Consul) (bool, string) {
+	value, found := instance.GetAnnotations()[forceDeleteAnnotation]
+	if !found {
+		return false, ""
+	}
+	if value == "true" {
+		return true, "The deletion has been forced by the " + forceDeleteAnnotation + " annotation"
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Error(err, "invalid annotation value, it must be true or a duration", "annotation", forceDeleteAnnotation, "value", value)
+		return false, ""
+	}
+	if time.Since(instance.GetDeletionTimestamp().Time) < timeout {
+		return false, ""
+	}
+	return true, fmt.Sprintf("The cleanup has not finished in %v, the deletion has been forced by the %v annotation", timeout, forceDeleteAnnotation)
+}
+
+// removeFinalizer records the final conditions and removes the finalizer, so the CR and its namespace can be deleted
+func (r *ConsulReconciler) removeFinalizer(logger logr.Logger, instance *app.Consul) (reconcile.Result, error) {
+	if err := r.updateStatus(instance); nil != err {
+		logger.Error(err, "status conditions update failed")
+		return reconcile.Result{}, err
+	}
+	finalizer.RemoveFinalizer(instance, finalizer.FinalizerId)
+	if err := r.Client.Update(context.TODO(), instance); err != nil {
+		logger.Error(err, "failed to remove the finalizer")
+		return reconcile.Result{}, errors.Wrap(err, "failed to remove the finalizer")
+	}
+	logger.Info("Finalizer removed")
+	return reconcile.Result{}, nil
+}
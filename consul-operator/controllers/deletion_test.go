@@ -0,0 +1,298 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/util/finalizer"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// withDynClient points the platform resource requests to a fake dynamic client with the given objects for the
+// duration of the test, the discovery serves the kinds of the platform resources
+func withDynClient(t *testing.T, objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	resources := &metav1.APIResourceList{GroupVersion: platformres.Group + "/" + platformres.Version}
+	listKinds := map[schema.GroupVersionResource]string{}
+	for _, kind := range platformres.Kinds {
+		gvr, _ := meta.UnsafeGuessKindToResource(schema.GroupVersionKind{Group: platformres.Group, Version: platformres.Version, Kind: kind})
+		resources.APIResources = append(resources.APIResources, metav1.APIResource{Name: gvr.Resource, Kind: kind, Namespaced: true})
+		listKinds[gvr] = kind + "List"
+	}
+	genClient := k8sfake.NewSimpleClientset()
+	genClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{resources}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	prev := newDynClient
+	newDynClient = func() k8sdynamic.K8sDynClient {
+		return k8sdynamic.NewWithClients(dynClient, genClient)
+	}
+	t.Cleanup(func() {
+		newDynClient = prev
+	})
+	return dynClient
+}
+
+// withCleanUpSteps replaces the helm uninstall and the release check of the platform resources for the duration of
+// the test
+func withCleanUpSteps(t *testing.T, undeployErr error, released func(k8sdynamic.ResourceDescriptor) (bool, error)) {
+	t.Helper()
+	prevUndeploy, prevReleased := undeployRelease, isResourceReleased
+	undeployRelease = func(string, string) (bool, error) {
+		return undeployErr == nil, undeployErr
+	}
+	isResourceReleased = released
+	t.Cleanup(func() {
+		undeployRelease, isResourceReleased = prevUndeploy, prevReleased
+	})
+}
+
+// newTestPlatformResource returns a platform resource request of the kind in the test namespace
+func newTestPlatformResource(kind, name string) *unstructured.Unstructured {
+	resource := &unstructured.Unstructured{}
+	resource.SetAPIVersion(platformres.Group + "/" + platformres.Version)
+	resource.SetKind(kind)
+	resource.SetName(name)
+	resource.SetNamespace(testNamespace)
+	return resource
+}
+
+func newTestStorage(name string) *unstructured.Unstructured {
+	return newTestPlatformResource("Storage", name)
+}
+
+func storageDescriptor(name string) k8sdynamic.ResourceDescriptor {
+	return k8sdynamic.ResourceDescriptor{
+		Name:      name,
+		Namespace: testNamespace,
+		Gvr:       k8sdynamic.GroupVersionResource{Group: platformres.Group, Version: platformres.Version, Resource: platformres.StorageResource},
+	}
+}
+
+// newDeletedConsul returns a deployed Consul with an applied Storage whose deletion started the given time ago
+func newDeletedConsul(deleted time.Duration) *app.Consul {
+	instance := newTestConsul("consul", app.PhaseRunning)
+	instance.Status.PrevSpec = instance.Spec.DeepCopy()
+	instance.Status.AppliedResources = []k8sdynamic.ResourceDescriptor{storageDescriptor("example-consul-0")}
+	deletion := metav1.NewTime(time.Now().Add(-deleted))
+	instance.DeletionTimestamp = &deletion
+	return instance
+}
+
+// expectDeleted checks that the finalizer has been removed, the fake client deletes the object at once
+func expectDeleted(t *testing.T, r *ConsulReconciler, instance *app.Consul) {
+	t.Helper()
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(instance), &app.Consul{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the finalizer to be removed, got %v", err)
+	}
+}
+
+func TestIsDeletionForced(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation *string
+		deleted    time.Duration
+		forced     bool
+	}{
+		{"no annotation", nil, time.Hour, false},
+		{"forced", stringPtr("true"), 0, true},
+		{"not true", stringPtr("false"), time.Hour, false},
+		{"invalid value", stringPtr("soon"), time.Hour, false},
+		{"timeout not elapsed", stringPtr("15m"), 10 * time.Minute, false},
+		{"timeout elapsed", stringPtr("15m"), 20 * time.Minute, true},
+		{"zero timeout", stringPtr("0s"), 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseRunning)
+			deletion := metav1.NewTime(time.Now().Add(-test.deleted))
+			instance.DeletionTimestamp = &deletion
+			if test.annotation != nil {
+				instance.Annotations = map[string]string{forceDeleteAnnotation: *test.annotation}
+			}
+
+			forced, reason := isDeletionForced(log, instance)
+			if forced != test.forced {
+				t.Errorf("expected forced %v, got %v", test.forced, forced)
+			}
+			if forced == (reason == "") {
+				t.Errorf("expected a reason only when forced, got %q", reason)
+			}
+		})
+	}
+}
+
+func TestHandleDeleteRetriesFailedCleanup(t *testing.T) {
+	tests := []struct {
+		name        string
+		undeployErr error
+		releaseErr  error
+	}{
+		{"uninstall failed", errors.New("helm failed"), nil},
+		{"release check failed", nil, errors.New("connection refused")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withDynClient(t, newTestStorage("example-consul-0"))
+			withCleanUpSteps(t, test.undeployErr, func(k8sdynamic.ResourceDescriptor) (bool, error) {
+				return false, test.releaseErr
+			})
+			instance := newDeletedConsul(time.Minute)
+			r, _ := newTestReconciler(t, instance)
+
+			//The error is returned, so the controller-runtime retries the cleanup with backoff
+			if _, err := r.handleDelete(instance, testNamespace); err == nil {
+				t.Fatalf("expected the error of the cleanup")
+			}
+			stored := getConsul(t, r, instance)
+			if !controllerutil.ContainsFinalizer(stored, finalizer.FinalizerId) {
+				t.Errorf("expected the finalizer to be kept")
+			}
+			expectCondition(t, stored, app.ConditionTerminating, metav1.ConditionTrue, app.ReasonUndeployFailed)
+			expectCondition(t, stored, app.ConditionDegraded, metav1.ConditionTrue, app.ReasonUndeployFailed)
+			if len(stored.Status.AppliedResources) != 1 {
+				t.Errorf("expected the applied resources to be kept, got %v", stored.Status.AppliedResources)
+			}
+		})
+	}
+}
+
+func TestHandleDeleteWaitsForRelease(t *testing.T) {
+	dynClient := withDynClient(t, newTestStorage("example-consul-0"))
+	released := false
+	withCleanUpSteps(t, nil, func(k8sdynamic.ResourceDescriptor) (bool, error) {
+		return released, nil
+	})
+	instance := newDeletedConsul(time.Minute)
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.handleDelete(instance, testNamespace)
+	if err != nil || result.RequeueAfter != releaseCheckInterval {
+		t.Fatalf("expected requeue after %v without error, got %+v, %v", releaseCheckInterval, result, err)
+	}
+	stored := getConsul(t, r, instance)
+	expectCondition(t, stored, app.ConditionTerminating, metav1.ConditionTrue, app.ReasonWaitingForRelease)
+	if len(stored.Status.AppliedResources) != 1 {
+		t.Errorf("expected the pending request in the applied resources, got %v", stored.Status.AppliedResources)
+	}
+	gvr := storageDescriptor("").Gvr.GetGvr()
+	if _, err := dynClient.Resource(gvr).Namespace(testNamespace).Get(context.TODO(), "example-consul-0", metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the request to be deleted, got %v", err)
+	}
+
+	released = true
+	if _, err := r.handleDelete(stored, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDeleted(t, r, instance)
+}
+
+func TestHandleDeleteForcedAfterTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		deleted time.Duration
+		forced  bool
+	}{
+		{"timeout not elapsed", 10 * time.Minute, false},
+		{"timeout elapsed", 40 * time.Minute, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withDynClient(t, newTestStorage("example-consul-0"))
+			withCleanUpSteps(t, nil, func(k8sdynamic.ResourceDescriptor) (bool, error) {
+				return false, nil
+			})
+			instance := newDeletedConsul(test.deleted)
+			instance.Annotations = map[string]string{forceDeleteAnnotation: "30m"}
+			r, recorder := newTestReconciler(t, instance)
+
+			result, err := r.handleDelete(instance, testNamespace)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var forced []string
+			for _, event := range recordedEvents(recorder) {
+				if strings.Contains(event, app.ReasonForceDeleted) {
+					forced = append(forced, event)
+				}
+			}
+			if !test.forced {
+				if result.RequeueAfter != releaseCheckInterval || len(forced) != 0 {
+					t.Errorf("expected to wait for the release, got %+v, %v", result, forced)
+				}
+				expectCondition(t, getConsul(t, r, instance), app.ConditionTerminating, metav1.ConditionTrue, app.ReasonWaitingForRelease)
+				return
+			}
+			expectDeleted(t, r, instance)
+			if len(forced) != 1 || !strings.Contains(forced[0], "example-consul-0") {
+				t.Errorf("expected the ForceDeleted event listing the left behind request, got %v", forced)
+			}
+		})
+	}
+}
+
+func TestReleasedResources(t *testing.T) {
+	storage := func(name string) k8sdynamic.ResourceDescriptor {
+		return k8sdynamic.ResourceDescriptor{
+			Name: name,
+			Gvr:  k8sdynamic.GroupVersionResource{Group: platformres.Group, Resource: platformres.StorageResource},
+		}
+	}
+	network := k8sdynamic.ResourceDescriptor{
+		Name: testNames.BackupStorage,
+		Gvr:  k8sdynamic.GroupVersionResource{Group: platformres.Group, Resource: "privatenetworkaccesses"},
+	}
+	applied := []k8sdynamic.ResourceDescriptor{storage("example-consul-0"), storage(testNames.BackupStorage), network}
+
+	released := releasedResources(applied, testNames.BackupStorage)
+	want := []k8sdynamic.ResourceDescriptor{storage("example-consul-0"), network}
+	if !reflect.DeepEqual(released, want) {
+		t.Errorf("expected %+v, got %+v", want, released)
+	}
+}
+
+// TestHandleDeleteOtherInstance checks that the cleanup of an instance leaves the other instance of the namespace alone
+func TestHandleDeleteOtherInstance(t *testing.T) {
+	withDynClient(t)
+	withCleanUpSteps(t, nil, func(k8sdynamic.ResourceDescriptor) (bool, error) {
+		return true, nil
+	})
+	kept := newTestConsul("kept", app.PhaseRunning)
+	deleted := newTestConsul("deleted", app.PhaseRunning)
+	deletion := metav1.Now()
+	deleted.DeletionTimestamp = &deletion
+	keptNames, deletedNames := namesOf(kept), namesOf(deleted)
+	r, _ := newTestReconciler(t, kept, deleted,
+		newTokenSecret(keptNames.ACLTokenSecret, "token"), newTokenSecret(deletedNames.ACLTokenSecret, "token"))
+
+	if _, err := r.handleDelete(deleted, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectDeleted(t, r, deleted)
+	if secret, err := r.getSecret(testNamespace, deletedNames.ACLTokenSecret); err != nil || secret != nil {
+		t.Errorf("expected the secret of the deleted instance to be deleted, got %v, %v", secret, err)
+	}
+	if secret, err := r.getSecret(testNamespace, keptNames.ACLTokenSecret); err != nil || secret == nil {
+		t.Errorf("expected the secret of the other instance to be kept, got %v, %v", secret, err)
+	}
+}
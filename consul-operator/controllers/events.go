@@ -0,0 +1,48 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordHelmDeploy records the outcome of the install or the upgrade of the helm release as an event of the instance
+func (r *ConsulReconciler) recordHelmDeploy(instance *app.Consul, action string, err error) {
+	release := instance.Status.HelmRelease
+	if err != nil {
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, app.ReasonDeployFailed, "Helm %v of the release %v failed: %v", action, release, err)
+		return
+	}
+	if action == helm.ActionInstall {
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, app.ReasonInstalled, "Helm release %v installed", release)
+	} else {
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, app.ReasonUpgraded, "Helm release %v upgraded", release)
+	}
+}
+
+// recordHelmUndeploy records the outcome of the uninstall of the helm release as an event of the instance, nothing
+// is recorded if the release was not installed
+func (r *ConsulReconciler) recordHelmUndeploy(instance *app.Consul, uninstalled bool, err error) {
+	release := helmReleaseOf(instance)
+	if err != nil {
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, app.ReasonUndeployFailed, "Helm uninstall of the release %v failed: %v", release, err)
+		return
+	}
+	if uninstalled {
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, app.ReasonUninstalled, "Helm release %v uninstalled", release)
+	}
+}
+
+// resourceNames returns the names of the resources for the messages
+func resourceNames(resources []k8sdynamic.ResourceDescriptor) []string {
+	var names []string
+	for _, resource := range resources {
+		names = append(names, resource.Name)
+	}
+	return names
+}
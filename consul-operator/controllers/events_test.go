@@ -0,0 +1,70 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
+)
+
+func TestRecordHelmDeploy(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		err    error
+		events []string
+	}{
+		{"installed", helm.ActionInstall, nil, []string{"Normal Installed Helm release consul installed"}},
+		{"upgraded", helm.ActionUpgrade, nil, []string{"Normal Upgraded Helm release consul upgraded"}},
+		{"failed", helm.ActionUpgrade, errors.New("timeout"),
+			[]string{"Warning DeployFailed Helm " + helm.ActionUpgrade + " of the release consul failed: timeout"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseDeploying)
+			instance.Status.HelmRelease = "consul"
+			r, recorder := newTestReconciler(t, instance)
+
+			r.recordHelmDeploy(instance, test.action, test.err)
+			if events := recordedEvents(recorder); !reflect.DeepEqual(events, test.events) {
+				t.Errorf("expected events %v, got %v", test.events, events)
+			}
+		})
+	}
+}
+
+func TestRecordHelmUndeploy(t *testing.T) {
+	tests := []struct {
+		name        string
+		prevSpec    bool
+		uninstalled bool
+		err         error
+		events      []string
+	}{
+		{"uninstalled", false, true, nil, []string{"Normal Uninstalled Helm release consul uninstalled"}},
+		{"not installed", false, false, nil, nil},
+		{"failed", false, false, errors.New("timeout"), []string{"Warning UndeployFailed Helm uninstall of the release consul failed: timeout"}},
+		//The instances deployed before the release was named after the CR use the legacy release
+		{"legacy release", true, true, nil, []string{"Normal Uninstalled Helm release " + helm.LegacyReleaseName + " uninstalled"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseRunning)
+			if test.prevSpec {
+				instance.Status.PrevSpec = instance.Spec.DeepCopy()
+			}
+			r, recorder := newTestReconciler(t, instance)
+
+			r.recordHelmUndeploy(instance, test.uninstalled, test.err)
+			if events := recordedEvents(recorder); !reflect.DeepEqual(events, test.events) {
+				t.Errorf("expected events %v, got %v", test.events, events)
+			}
+		})
+	}
+}
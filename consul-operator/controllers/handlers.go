@@ -17,26 +17,23 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"time"
 
+	"github.com/go-logr/logr"
 	netattv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
 	"github.com/nokia/industrial-application-framework/consul-operator/libs/kubelib"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
-	"github.com/nokia/industrial-application-framework/consul-operator/pkg/licenceexpired"
-	"github.com/nokia/industrial-application-framework/consul-operator/pkg/monitoring"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
-	"github.com/nokia/industrial-application-framework/consul-operator/pkg/template"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/util/finalizer"
 	appsv1 "k8s.io/api/apps/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-const (
-	usingPnaLabelKey = "ndac.appfw.private-network-access"
-	appPnaName       = "private-network-for-consul"
-)
+//The label of the statefulsets using the private network access, its value is the name of the request
+const usingPnaLabelKey = "ndac.appfw.private-network-access"
 
 const (
 	deploymentTypeDeployment  = "deployments"
@@ -44,6 +41,12 @@ const (
 	deploymentTypeDaemonset   = "deamonsets"
 )
 
+// newDynClient returns the client applying and deleting the platform resource requests, the tests replace it with a
+// client of fakes
+var newDynClient = func() k8sdynamic.K8sDynClient {
+	return k8sdynamic.New(kubelib.GetKubeAPI())
+}
+
 type deploymentType string
 
 type deploymentId struct {
@@ -51,8 +54,6 @@ type deploymentId struct {
 	name           string
 }
 
-var appStatusMonitor *monitoring.Monitor
-
 func (r *ConsulReconciler) handleCrChange(instance *app.Consul, namespace string) (reconcile.Result, error) {
 	logger := log.WithName("handlers").WithName("handleCrChange").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
 	logger.Info("Event arrived handle it")
@@ -72,147 +73,168 @@ func (r *ConsulReconciler) handleCrChange(instance *app.Consul, namespace string
 		return reconcile.Result{}, nil
 	}
 
-	if isSpecUpdated(instance) {
+	if instance.Status.Phase == "" && instance.Status.PrevSpec != nil {
+		//Deployed before the phases were introduced
+		instance.Status.Phase = app.PhaseRunning
+	}
+	instance.Status.HelmRelease = helmReleaseOf(instance)
+
+	if instance.Status.Phase == app.PhaseRunning && isSpecUpdated(instance) {
 		return r.handleUpdate(instance, namespace)
 	} else {
 		return r.handleCreate(instance, namespace)
 	}
 }
 
+// helmReleaseOf returns the name of the helm release of the instance, it is the name of the CR unless the instance was
+// deployed with the legacy release name
+func helmReleaseOf(instance *app.Consul) string {
+	if instance.Status.HelmRelease != "" {
+		return instance.Status.HelmRelease
+	}
+	if instance.Status.PrevSpec != nil {
+		return helm.LegacyReleaseName
+	}
+	return instance.GetName()
+}
+
 func isSpecUpdated(instance *app.Consul) bool {
 	return instance.Status.PrevSpec != nil && !reflect.DeepEqual(instance.Spec, *instance.Status.PrevSpec)
 }
 
-func (r *ConsulReconciler) handleDelete(instance *app.Consul, namespace string) (reconcile.Result, error) {
-	logger := log.WithName("handlers").WithName("handleDelete").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
-	logger.Info("Called")
+// releasedOnUpdate releases the resources of a field which cannot be modified in place before the platform resources
+// are requested again. It reports whether the resources have been released, it is called again until then.
+var releasedOnUpdate = map[string]func(r *ConsulReconciler, instance *app.Consul, namespace string) (bool, error){
+	"privateNetworkAccess": (*ConsulReconciler).releasePrivateNetworkAccess,
+	"replicaCount":         (*ConsulReconciler).scaleDown,
+}
 
-	if nil != appStatusMonitor {
-		appStatusMonitor.Pause()
-	}
+//Interval of checking the release of the resources on update
+const releaseCheckInterval = 5 * time.Second
 
-	//Go through the app spec CR and delete all of the resources present in the AppliedResources list
-	k8sClient := k8sdynamic.New(kubelib.GetKubeAPI())
-	if err := k8sClient.DeleteResources(instance.Status.AppliedResources); err != nil {
-		logger.Error(err, "failed to delete the resources")
-	}
+// handleUpdate applies the spec change of a running instance according to the update policies of the changed
+// fields, the application is deployed again by continuing the deployment phases from the phase the policy requires
+func (r *ConsulReconciler) handleUpdate(instance *app.Consul, namespace string) (reconcile.Result, error) {
+	logger := log.WithName("handlers").WithName("handleUpdate").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
 
-	//Optional - if helm was used for the deployment helm has to be used also for the undeployment
-	h := helm.NewHelm(namespace)
-	if err := h.Undeploy(); err != nil {
-		logger.Error(err, "failed to uninstall the helm chart")
+	changed := instance.Spec.ChangedFields(instance.Status.PrevSpec)
+	policy := instance.Spec.UpdatePolicy(instance.Status.PrevSpec)
+	var fields []string
+	for _, field := range changed {
+		fields = append(fields, field.Path)
 	}
+	logger.Info("Called", "fields", fields, "policy", policy.String())
 
-	finalizer.RemoveFinalizer(instance, finalizer.FinalizerId)
-	r.Client.Update(context.TODO(), instance)
-
-	return reconcile.Result{}, nil
-}
+	if usesLegacyStorage(instance) && instance.Spec.ReplicaCount > instance.Status.PrevSpec.ReplicaCount {
+		//The legacy storage holds the data of a single server, the instance has to be migrated to scale up
+		err := errors.Errorf("the instance deployed with the single %v Storage cannot be scaled up, take a backup and "+
+			"restore it into a new Consul CR with replicaCount %d", legacyStorageName, instance.Spec.ReplicaCount)
+		r.rejectUpdate(logger, instance, err)
+		return reconcile.Result{}, nil
+	}
 
-func (r *ConsulReconciler) handleUpdate(instance *app.Consul, namespace string) (reconcile.Result, error) {
-	logger := log.WithName("handlers").WithName("handleUpdate").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
-	logger.Info("Called")
-	if appParametersChanged(instance) {
-		log.V(1).Info("Network settings updated, reloading app")
+	switch policy {
+	case app.UpdateImmutable:
+		//Rejected by the validating webhook, it happens only if the webhooks are disabled
+		err := errors.Errorf("immutable fields have been changed: %v", fields)
+		r.rejectUpdate(logger, instance, err)
+		return reconcile.Result{}, nil
 
-		err := r.undeployAppComponentsAffectedByUpdate(namespace)
-		if err != nil {
-			logger.Error(err, "Failed removal of app components using pna")
-			return reconcile.Result{}, nil
+	case app.UpdateRedeploy:
+		//The resources of the fields with a weaker policy are released first as well, eg. the removed servers of a
+		//scale down leave the raft configuration before the remaining ones are restarted, otherwise the stale peers
+		//would take the quorum of the redeployed cluster
+		if released, err := r.releaseChangedFields(logger, instance, namespace, changed); err != nil || !released {
+			return releaseResult(err)
 		}
-		logger.V(1).Info("Consul app undeployed")
-
-		//Removing the resources that are affected by the update
-		pna := k8sdynamic.ResourceDescriptor{
-			Name:      appPnaName,
-			Namespace: namespace,
-			Gvr: k8sdynamic.GroupVersionResource{
-				Group:    "ops.dac.nokia.com",
-				Version:  "v1alpha1",
-				Resource: "privatenetworkaccesses",
-			}}
-
-		err = deleteChangedResources(pna)
+		uninstalled, err := helm.NewHelm(namespace, instance.Status.HelmRelease).Undeploy()
+		r.recordHelmUndeploy(instance, uninstalled, err)
 		if err != nil {
-			logger.Error(err, "failed to delete private network access")
+			logger.Error(err, "failed to uninstall the helm chart")
+			r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonUndeployFailed, err)
 			return reconcile.Result{}, err
 		}
+		instance.SetCondition(app.ConditionDeployed, metav1.ConditionFalse, app.ReasonRedeploying, "The application is deployed again to apply the update")
+		return r.advancePhase(instance, app.PhaseTemplating)
 
-		//PrivateNetworkAccess release takes some time, we need to wait for its removal before recreating it
-		waitUntilResourcesAreReleased(pna)
-
-		//Recreate resources and redeploy application
-		//Execute CR based templating to resolve the variables in the resource-req dir
-		resReqTemplater, err := template.NewTemplater(instance.Spec, namespace, "resource-reqs")
-		if err != nil {
-			logger.Error(err, "Failed to initialize the res-req appDeploymentTemplater")
-			return reconcile.Result{}, nil
-		}
-		_, err = resReqTemplater.RunCrTemplater("---\n")
-		if err != nil {
-			logger.Error(err, "Failed to execute the res-req CR appDeploymentTemplater")
-			return reconcile.Result{}, nil
+	case app.UpdateRequestResources:
+		if released, err := r.releaseChangedFields(logger, instance, namespace, changed); err != nil || !released {
+			return releaseResult(err)
 		}
+		instance.SetCondition(app.ConditionResourcesGranted, metav1.ConditionFalse, app.ReasonResourcesPending, "The platform resources are requested again")
+		return r.advancePhase(instance, app.PhaseRequestingResources)
 
-		//Request NDAC platform resources
-		appliedPlatformResourceDescriptors, err := platformres.ApplyPnaResourceRequests(namespace)
-		if err != nil {
-			logger.Error(err, "failed to apply updated pna request")
-			return reconcile.Result{}, nil
+	default:
+		//The app-deployment is templated again and the helm release upgraded in the Deploying phase
+		return r.advancePhase(instance, app.PhaseDeploying)
+	}
+}
+
+// releaseChangedFields releases the resources of the changed fields which cannot be modified in place. It reports
+// whether all of them have been released.
+func (r *ConsulReconciler) releaseChangedFields(logger logr.Logger, instance *app.Consul, namespace string, changed []app.FieldUpdatePolicy) (bool, error) {
+	for _, field := range changed {
+		release, found := releasedOnUpdate[field.Path]
+		if !found {
+			continue
 		}
-		//Blocks until all of the platform requests granted
-		err = platformres.WaitUntilResourcesGranted(appliedPlatformResourceDescriptors, time.Second*500)
+		released, err := release(r, instance, namespace)
 		if err != nil {
-			logger.Error(err, "failed to get pna resources")
-			return reconcile.Result{}, nil
+			logger.Error(err, "failed to release the platform resources", "field", field.Path)
+			r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonResourceRequestFailed, err)
+			return false, err
+		}
+		if !released {
+			logger.V(1).Info("Waiting for the release of the resources", "field", field.Path)
+			return false, nil
 		}
 	}
+	return true, nil
+}
 
-	// Upgrade application for the new networking settings to take effect
-	h := helm.NewHelm(namespace)
-	if err := h.Deploy(); err != nil {
-		logger.Error(err, "failed to update the helm chart")
+// releaseResult returns the result of an update waiting for the release of the resources
+func releaseResult(err error) (reconcile.Result, error) {
+	if err != nil {
 		return reconcile.Result{}, err
 	}
-	instance.Status.PrevSpec = &instance.Spec
-	if err := r.updateStatus(instance); nil != err {
-		log.Error(err, "status previous spec update failed")
-	}
-
-	return reconcile.Result{}, nil
+	//The removal of the platform resources is also detected by their watch, the pods are not watched
+	return reconcile.Result{RequeueAfter: releaseCheckInterval}, nil
 }
 
-func deleteChangedResources(pna k8sdynamic.ResourceDescriptor) error {
-	k8sClient := k8sdynamic.New(kubelib.GetKubeAPI())
+// releasePrivateNetworkAccess removes the private network access request and the components using it
+func (r *ConsulReconciler) releasePrivateNetworkAccess(instance *app.Consul, namespace string) (bool, error) {
+	logger := log.WithName("handlers").WithName("releasePrivateNetworkAccess").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
+
+	names := namesOf(instance)
+	if err := r.undeployAppComponentsAffectedByUpdate(namespace, names.PrivateNetworkAccess); err != nil {
+		return false, errors.Wrap(err, "failed removal of app components using pna")
+	}
+	logger.V(1).Info("Consul app components using pna undeployed")
+
+	pna := k8sdynamic.ResourceDescriptor{
+		Name:      names.PrivateNetworkAccess,
+		Namespace: namespace,
+		Gvr: k8sdynamic.GroupVersionResource{
+			Group:    platformres.Group,
+			Version:  platformres.Version,
+			Resource: "privatenetworkaccesses",
+		}}
+
+	k8sClient := newDynClient()
 	if err := k8sClient.DeleteResources([]k8sdynamic.ResourceDescriptor{pna}); err != nil {
-		return err
+		return false, errors.Wrap(err, "failed to delete private network access")
 	}
-	return nil
-}
 
-func waitUntilResourcesAreReleased(pna k8sdynamic.ResourceDescriptor) {
-	logger := log.WithName("handlers").WithName("waitUntilResourcesAreReleased")
-	for {
-		_, err := k8sdynamic.GetDynamicK8sClient().Resource(pna.Gvr.GetGvr()).Namespace(pna.Namespace).Get(context.TODO(), pna.Name, metav1.GetOptions{})
-		if err != nil {
-			if k8serrors.IsNotFound(err) {
-				logger.V(1).Info("PNA successfully removed")
-				break
-			} else {
-				logger.V(1).Error(err, "error getting oldpna")
-			}
-		}
-		logger.V(1).Info("Waiting for PNA deletion")
-		time.Sleep(time.Millisecond * 100)
-	}
+	//PrivateNetworkAccess release takes some time, it has to be removed before recreating it
+	return platformres.IsResourceReleased(pna)
 }
 
-func (r *ConsulReconciler) undeployAppComponentsAffectedByUpdate(namespace string) error {
+func (r *ConsulReconciler) undeployAppComponentsAffectedByUpdate(namespace, pnaName string) error {
 	//Remove statefulsets having pna label
 	consulApp := &appsv1.StatefulSet{}
 	opts := []client.DeleteAllOfOption{
 		client.InNamespace(namespace),
-		client.MatchingLabels{usingPnaLabelKey: appPnaName},
+		client.MatchingLabels{usingPnaLabelKey: pnaName},
 		client.GracePeriodSeconds(0),
 		client.PropagationPolicy(metav1.DeletePropagationBackground),
 	}
@@ -220,15 +242,17 @@ func (r *ConsulReconciler) undeployAppComponentsAffectedByUpdate(namespace strin
 	return err
 }
 
-func appParametersChanged(instance *app.Consul) bool {
-	// Comparing existing application parameters with new values in case of parameters whose value change is supported
-	return !reflect.DeepEqual(instance.Status.PrevSpec.PrivateNetworkAccess, instance.Spec.PrivateNetworkAccess)
-}
-
 func (r *ConsulReconciler) updateStatus(instance *app.Consul) error {
 	prevSpec := instance.Status.PrevSpec.DeepCopy()
 	appliedResources := make([]k8sdynamic.ResourceDescriptor, len(instance.Status.AppliedResources))
 	copy(appliedResources, instance.Status.AppliedResources)
+	observedGeneration := instance.Status.ObservedGeneration
+	phase := instance.Status.Phase
+	helmRelease := instance.Status.HelmRelease
+	aclBootstrapTokenSecret := instance.Status.AppReportedData.ACLBootstrapTokenSecret
+	backup := instance.Status.Backup.DeepCopy()
+	conditions := make([]metav1.Condition, len(instance.Status.Conditions))
+	copy(conditions, instance.Status.Conditions)
 	key := client.ObjectKey{
 		Namespace: instance.GetNamespace(),
 		Name:      instance.GetName(),
@@ -242,6 +266,14 @@ func (r *ConsulReconciler) updateStatus(instance *app.Consul) error {
 
 		instance.Status.PrevSpec = prevSpec
 		instance.Status.AppliedResources = appliedResources
+		instance.Status.ObservedGeneration = observedGeneration
+		instance.Status.Phase = phase
+		instance.Status.HelmRelease = helmRelease
+		instance.Status.AppReportedData.ACLBootstrapTokenSecret = aclBootstrapTokenSecret
+		instance.Status.Backup = *backup
+		for _, condition := range conditions {
+			meta.SetStatusCondition(&instance.Status.Conditions, condition)
+		}
 
 		err = r.Status().Update(context.TODO(), instance)
 		return err
@@ -254,115 +286,61 @@ func (r *ConsulReconciler) updateStatus(instance *app.Consul) error {
 	return nil
 }
 
-
-func (r *ConsulReconciler) handleCreate(instance *app.Consul, namespace string) (reconcile.Result, error) {
-	logger := log.WithName("handlers").WithName("handleCreate").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
-	logger.Info("Called")
-
-	//Execute CR based templating to resolve the variables in the resource-req dir
-	resReqTemplater, err := template.NewTemplater(instance.Spec, namespace, "resource-reqs")
-	if err != nil {
-		logger.Error(err, "Failed to initialize the res-req appDeploymentTemplater")
-		return reconcile.Result{}, nil
-	}
-	_, err = resReqTemplater.RunCrTemplater("---\n")
-	if err != nil {
-		logger.Error(err, "Failed to execute the res-req CR appDeploymentTemplater")
-		return reconcile.Result{}, nil
+// updateAppReportedData writes the data reported by the running application to the status, retried on conflict with
+// the latest version of the CR
+func (r *ConsulReconciler) updateAppReportedData(instance *app.Consul) error {
+	metricsClusterIp := instance.Status.AppReportedData.MetricsClusterIp
+	privateNetworkIpAddress := instance.Status.AppReportedData.PrivateNetworkIpAddress
+	key := client.ObjectKey{
+		Namespace: instance.GetNamespace(),
+		Name:      instance.GetName(),
 	}
 
-	//Request NDAC platform resources
-	appliedPlatformResourceDescriptors, err := platformres.ApplyPlatformResourceRequests(namespace)
-	if err != nil {
-		logger.Error(err, "failed to apply the platform resource requests")
-		return reconcile.Result{}, nil
-	}
-	//Blocks until all of the platform requests granted
-	err = platformres.WaitUntilResourcesGranted(appliedPlatformResourceDescriptors, time.Second*500)
-	if err != nil {
-		logger.Error(err, "failed to get all of the requested platform resources")
-		return reconcile.Result{}, nil
-	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Get(context.TODO(), key, instance)
+		if err != nil {
+			return err
+		}
 
-	//Execute templating for the app-deplyoment directory using the values from the CR
-	appDeploymentTemplater, err := template.NewTemplater(instance.Spec, namespace, "app-deployment")
-	if err != nil {
-		logger.Error(err, "Failed to initialize the appDeploymentTemplater")
-		return reconcile.Result{}, nil
-	}
+		instance.Status.AppReportedData.MetricsClusterIp = metricsClusterIp
+		instance.Status.AppReportedData.PrivateNetworkIpAddress = privateNetworkIpAddress
+		return r.Status().Update(context.TODO(), instance)
+	})
 
-	//Gives back the output of the templated yamls. It can be applied directly in kubernetes
-	_, err = appDeploymentTemplater.RunCrTemplater("---\n")
 	if err != nil {
-		logger.Error(err, "Failed to execute the CR appDeploymentTemplater")
-		return reconcile.Result{}, nil
+		return errors.Wrap(err, "failed app reported data update")
 	}
 
-	//Optional - Helm based deployment
-	err = helm.NewHelm(namespace).Deploy()
-	if err != nil {
-		logger.Error(err, "Failed to deploy the helm chart")
-		return reconcile.Result{}, nil
-	}
+	return nil
+}
 
-	//This section is only needed if helm is not used for the deployment
-	//k8sClient := k8sdynamic.New(kubelib.GetKubeAPI())
-	//appliedApplicationResourceDescriptors, err := k8sClient.ApplyConcatenatedResources(out, namespace)
-	//if err != nil {
-	//	logger.Error(err, "failed to apply the templated resources")
-	//	return reconcile.Result{}, nil
-	//}
-
-	instance.Status.AppliedResources = appliedPlatformResourceDescriptors
-	//This section is only needed if helm is not used for the deployment
-	//instance.Status.AppliedResources = append(instance.Status.AppliedResources, appliedApplicationResourceDescriptors...)
-	instance.Status.PrevSpec = &instance.Spec
-	if err := r.Client.Status().Update(context.TODO(), instance); nil != err {
-		logger.Error(err, "status applied resources and previous spec update failed")
+// setFailedCondition records the failed step of the reconciliation in the status and marks the Consul degraded
+func (r *ConsulReconciler) setFailedCondition(instance *app.Consul, conditionType string, reason string, err error) {
+	instance.SetCondition(conditionType, metav1.ConditionFalse, reason, err.Error())
+	instance.SetCondition(app.ConditionDegraded, metav1.ConditionTrue, reason, err.Error())
+	if err := r.updateStatus(instance); nil != err {
+		log.Error(err, "status conditions update failed", "condition", conditionType)
 	}
+}
 
-	//Controls the appStatus and appReportedData in the app spec CR, running continuously in the background
-	appStatusMonitor = monitoring.NewMonitor(r.Client, instance, namespace,
-		func() {
-			logger.Info("Set AppReportedData")
-			//runningCallback - example, some dynamic data should be reported here which has value only after the deployment
-			svc, err := kubelib.GetKubeAPI().CoreV1().Services(namespace).Get(context.TODO(), "example-consul-service", metav1.GetOptions{})
-			if err != nil {
-				logger.Error(err, "Failed to read the svc of the metrics endpoint")
-				return
-			}
-			instance.Status.AppReportedData.MetricsClusterIp = svc.Spec.ClusterIP
-			if instance.Spec.PrivateNetworkAccess != nil {
-				instance.Status.AppReportedData.PrivateNetworkIpAddress = getPrivateNetworkIpAddresses(
-					namespace,
-					appPnaName,
-					[]deploymentId{
-						{deploymentTypeStatefulset, "example-consul"},
-					},
-				)
-			}
-
-			if err := r.Client.Status().Update(context.TODO(), instance); nil != err {
-				logger.Error(err, "status app reported data update failed")
-			}
-		},
-		func() {
-			//notRunningCallback
-		},
-	)
-	appStatusMonitor.Run()
-
-	//Handles the application license expiration, reactivation
-	licCallbacks := &licenceexpired.SampleFuncs{
-		RuntimeClient: r.Client,
-		AppInstance:   instance,
-		ClientSet:     kubelib.GetKubeAPI(),
-		Monitor:       appStatusMonitor,
+// rejectUpdate reports the rejected update of the spec. It is reported once per generation, the instance is reconciled
+// again without a spec change, eg. by the periodic sweep, and the update stays rejected until the spec is changed.
+func (r *ConsulReconciler) rejectUpdate(logger logr.Logger, instance *app.Consul, err error) {
+	deployed := meta.FindStatusCondition(instance.Status.Conditions, app.ConditionDeployed)
+	if deployed != nil && deployed.Reason == app.ReasonUpdateRejected && deployed.ObservedGeneration == instance.GetGeneration() {
+		logger.V(1).Info("Update already rejected", "generation", instance.GetGeneration())
+		return
 	}
+	logger.Error(err, "update rejected")
+	r.Recorder.Event(instance, corev1.EventTypeWarning, app.ReasonUpdateRejected, err.Error())
+	r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonUpdateRejected, err)
+}
 
-	licenceexpired.New(namespace, licCallbacks).Watch()
-
-	return reconcile.Result{}, nil
+// setDeployedConditions marks the current generation of the spec deployed
+func setDeployedConditions(instance *app.Consul) {
+	instance.SetCondition(app.ConditionDeployed, metav1.ConditionTrue, app.ReasonDeployed, "The application has been deployed")
+	instance.SetCondition(app.ConditionDegraded, metav1.ConditionFalse, app.ReasonReconciled, "")
+	instance.Status.ObservedGeneration = instance.GetGeneration()
 }
 
 func getPrivateNetworkIpAddresses(namespace, pnaName string, deploymentList []deploymentId) map[string]string {
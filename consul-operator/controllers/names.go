@@ -0,0 +1,104 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
+)
+
+// legacyAppName is the name of the statefulset of the instances deployed with the legacy helm release
+const legacyAppName = "example-consul"
+
+// appNames are the names of the resources of a Consul instance, they are used by the templates as well. They are
+// derived from the name of the CR, so the instances of a namespace do not share any of their resources. The instances
+// deployed with the legacy helm release keep the fixed names of the chart: the statefulset and its claims cannot be
+// renamed without losing the data of the servers.
+type appNames struct {
+	// App is the name of the statefulset, of its headless service and of the app label of its pods
+	App                  string
+	Service              string
+	ConfigMap            string
+	Ingress              string
+	ResourceRequest      string
+	MetricsEndpoint      string
+	PrivateNetworkAccess string
+	BackupStorage        string
+	BackupJob            string
+	RestoreJob           string
+	//The Secrets generated by the operator
+	GossipKeySecret        string
+	TLSSecret              string
+	ACLTokenSecret         string
+	ACLOperatorTokenSecret string
+	ACLAgentTokenSecret    string
+}
+
+// namesOf returns the names of the resources of the instance
+func namesOf(instance *app.Consul) appNames {
+	if helmReleaseOf(instance) == helm.LegacyReleaseName {
+		names := derivedNames(legacyAppName)
+		names.Ingress = "consul-metrics"
+		names.ResourceRequest = "resource-for-consul"
+		names.MetricsEndpoint = "consul-metricsendpoint"
+		names.PrivateNetworkAccess = "private-network-for-consul"
+		return names
+	}
+	return derivedNames(instance.GetName())
+}
+
+func derivedNames(name string) appNames {
+	return appNames{
+		App:                    name,
+		Service:                name + "-service",
+		ConfigMap:              name + "-cm",
+		Ingress:                name + "-metrics",
+		ResourceRequest:        name + "-resources",
+		MetricsEndpoint:        name + "-metricsendpoint",
+		PrivateNetworkAccess:   name + "-private-network",
+		BackupStorage:          name + "-backup",
+		BackupJob:              name + "-snapshot-save",
+		RestoreJob:             name + "-snapshot-restore",
+		GossipKeySecret:        name + "-gossip-key",
+		TLSSecret:              name + "-tls",
+		ACLTokenSecret:         name + "-acl-bootstrap-token",
+		ACLOperatorTokenSecret: name + "-acl-operator-token",
+		ACLAgentTokenSecret:    name + "-acl-agent-token",
+	}
+}
+
+// secrets returns the names of the Secrets generated by the operator
+func (n appNames) secrets() []string {
+	return []string{n.GossipKeySecret, n.TLSSecret, n.ACLTokenSecret, n.ACLOperatorTokenSecret, n.ACLAgentTokenSecret}
+}
+
+// podSelector selects the checked pods of the instance
+func (n appNames) podSelector() string {
+	return "statusCheck=true,app=" + n.App
+}
+
+// serviceSelector selects the services of the instance deleted on the expiration of the licence
+func (n appNames) serviceSelector() string {
+	return "deleteOnLicenceExpiration=true,name=" + n.App
+}
+
+func (n appNames) podName(ordinal int32) string {
+	return n.App + "-" + strconv.Itoa(int(ordinal))
+}
+
+// podOrdinal returns the ordinal of the statefulset pod from its name, the node name of a server is its pod name
+func (n appNames) podOrdinal(pod string) (int32, bool) {
+	if !strings.HasPrefix(pod, n.App+"-") {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(pod, n.App+"-"))
+	if err != nil {
+		return 0, false
+	}
+	return int32(ordinal), true
+}
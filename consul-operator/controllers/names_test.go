@@ -0,0 +1,59 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
+)
+
+func TestNamesOf(t *testing.T) {
+	legacy := newTestConsul("consul", app.PhaseRunning)
+	legacy.Status.HelmRelease = helm.LegacyReleaseName
+	//Deployed before the release name was stored
+	unstored := newTestConsul("consul", app.PhaseRunning)
+	unstored.Status.PrevSpec = unstored.Spec.DeepCopy()
+
+	tests := []struct {
+		name     string
+		instance *app.Consul
+		app      string
+		service  string
+		pna      string
+	}{
+		{"new", newTestConsul("consul", ""), "consul", "consul-service", "consul-private-network"},
+		{"legacy release", legacy, "example-consul", "example-consul-service", "private-network-for-consul"},
+		{"legacy release not stored", unstored, "example-consul", "example-consul-service", "private-network-for-consul"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := namesOf(test.instance)
+			if names.App != test.app || names.Service != test.service || names.PrivateNetworkAccess != test.pna {
+				t.Errorf("expected %v, %v, %v, got %+v", test.app, test.service, test.pna, names)
+			}
+		})
+	}
+}
+
+// TestNamesOfInstances checks that the instances of a namespace share none of their resources
+func TestNamesOfInstances(t *testing.T) {
+	legacy := newTestConsul("legacy", app.PhaseRunning)
+	legacy.Status.HelmRelease = helm.LegacyReleaseName
+	used := make(map[string]string)
+	for _, instance := range []*app.Consul{legacy, newTestConsul("first", ""), newTestConsul("second", "")} {
+		names := namesOf(instance)
+		all := append([]string{names.App, names.Service, names.ConfigMap, names.Ingress, names.ResourceRequest,
+			names.MetricsEndpoint, names.PrivateNetworkAccess, names.BackupStorage, names.BackupJob, names.RestoreJob},
+			names.secrets()...)
+		for _, name := range all {
+			if owner, found := used[name]; found {
+				t.Errorf("the name %v of %v is used by %v as well", name, instance.Name, owner)
+			}
+			used[name] = instance.Name
+		}
+	}
+}
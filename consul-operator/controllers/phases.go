@@ -0,0 +1,375 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/libs/kubelib"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/licenceexpired"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/monitoring"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/template"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	resourceReqsDir  = "resource-reqs"
+	appDeploymentDir = "app-deployment"
+)
+
+// Interval of checking the approval of the platform resource requests, a fallback of their watch which misses the
+// kinds without CRD at the start of the operator
+const grantCheckInterval = 30 * time.Second
+
+// checkResourcesGranted reads the approval status of the platform resource requests, replaced by the tests
+var checkResourcesGranted = platformres.CheckResourcesGranted
+
+// handleCreate deploys the application phase by phase. Every call executes the step belonging to the current
+// phase of the instance, the steps are idempotent so an interrupted phase can be executed again. On success the
+// next phase is stored in the status and the request is requeued, on failure the error is returned so the
+// request is requeued with backoff in the same phase.
+func (r *ConsulReconciler) handleCreate(instance *app.Consul, namespace string) (reconcile.Result, error) {
+	logger := log.WithName("handlers").WithName("handleCreate").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name, "phase", instance.Status.Phase)
+	logger.Info("Called")
+
+	switch instance.Status.Phase {
+	case "", app.PhaseTemplating:
+		return r.handleTemplating(logger, instance, namespace)
+	case app.PhaseRequestingResources:
+		return r.handleRequestingResources(logger, instance, namespace)
+	case app.PhaseWaitingForGrant:
+		return r.handleWaitingForGrant(logger, instance)
+	case app.PhaseDeploying:
+		return r.handleDeploying(logger, instance, namespace)
+	case app.PhaseBootstrappingACL:
+		return r.handleBootstrappingACL(logger, instance, namespace)
+	case app.PhaseRestoring:
+		return r.handleRestoring(logger, instance, namespace)
+	case app.PhaseRunning:
+		//Started again after the restart of the operator
+		if r.startMonitoring(instance, namespace) {
+			recoverLicence(logger, instance, namespace)
+		}
+		if err := r.redeployIfRequested(logger, instance, namespace); err != nil {
+			logger.Error(err, "redeployment failed, it is retried")
+			return reconcile.Result{}, err
+		}
+		nextSweep := r.sweepOrphansIfDue(logger, instance, namespace)
+		result, err := r.reconcileBackup(logger, instance, namespace)
+		return requeueFirst(result, nextSweep), err
+	case app.PhaseFailed:
+		return r.handleFailed(logger, instance)
+	default:
+		logger.Error(nil, "Unknown phase, restart the deployment")
+		return r.advancePhase(instance, app.PhaseTemplating)
+	}
+}
+
+// advancePhase stores the next phase in the status and requeues the request to execute it
+func (r *ConsulReconciler) advancePhase(instance *app.Consul, phase app.Phase) (reconcile.Result, error) {
+	instance.Status.Phase = phase
+	instance.SetCondition(app.ConditionDegraded, metav1.ConditionFalse, app.ReasonReconciled, "")
+	if err := r.updateStatus(instance); nil != err {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{Requeue: true}, nil
+}
+
+// handleTemplating renders the templates of both directories, so an invalid spec is detected before
+// requesting any of the platform resources
+func (r *ConsulReconciler) handleTemplating(logger logr.Logger, instance *app.Consul, namespace string) (reconcile.Result, error) {
+	if _, err := renderTemplates(instance, namespace, resourceReqsDir); err != nil {
+		logger.Error(err, "Failed to execute the res-req CR templater")
+		r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonTemplatingFailed, err)
+		return reconcile.Result{}, err
+	}
+	if _, err := renderTemplates(instance, namespace, appDeploymentDir); err != nil {
+		logger.Error(err, "Failed to execute the app-deployment CR templater")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonTemplatingFailed, err)
+		return reconcile.Result{}, err
+	}
+	return r.advancePhase(instance, app.PhaseRequestingResources)
+}
+
+func (r *ConsulReconciler) handleRequestingResources(logger logr.Logger, instance *app.Consul, namespace string) (reconcile.Result, error) {
+	//The generated files do not survive the restart of the operator, rendering them again is cheap
+	requestDir, err := renderTemplates(instance, namespace, resourceReqsDir)
+	if err != nil {
+		logger.Error(err, "Failed to execute the res-req CR templater")
+		r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonTemplatingFailed, err)
+		return reconcile.Result{}, err
+	}
+
+	//Request NDAC platform resources, applying them again is a no-op. The backup Storage is not owned by the instance,
+	//its snapshots can be restored into a new instance.
+	appliedPlatformResourceDescriptors, err := platformres.ApplyPlatformResourceRequests(requestDir, namespace,
+		r.ownerReference(instance), namesOf(instance).BackupStorage)
+	if err != nil {
+		logger.Error(err, "failed to apply the platform resource requests")
+		r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonResourceRequestFailed, err)
+		return reconcile.Result{}, err
+	}
+	//The requests which are not templated any more, eg. the Storage of a removed replica, are released
+	stale := staleResources(instance.Status.AppliedResources, appliedPlatformResourceDescriptors)
+	if len(stale) > 0 {
+		logger.Info("Delete the platform resource requests which are not needed any more", "resources", stale)
+		k8sClient := newDynClient()
+		if err := k8sClient.DeleteResources(stale); err != nil {
+			logger.Error(err, "failed to delete the platform resource requests")
+			r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonResourceRequestFailed, err)
+			return reconcile.Result{}, err
+		}
+	}
+	instance.Status.AppliedResources = appliedPlatformResourceDescriptors
+	instance.SetCondition(app.ConditionResourcesGranted, metav1.ConditionFalse, app.ReasonResourcesPending, "Waiting for the approval of the platform resource requests")
+	r.Recorder.Eventf(instance, corev1.EventTypeNormal, app.ReasonResourcesRequested,
+		"Platform resource requests submitted: %v", resourceNames(appliedPlatformResourceDescriptors))
+	return r.advancePhase(instance, app.PhaseWaitingForGrant)
+}
+
+func (r *ConsulReconciler) handleWaitingForGrant(logger logr.Logger, instance *app.Consul) (reconcile.Result, error) {
+	approved, pending, err := checkResourcesGranted(instance.Status.AppliedResources)
+	if errors.Is(err, platformres.ErrResourceRequestRejected) {
+		//Retrying does not help, the deployment is restarted when the spec is changed
+		logger.Error(err, "platform resource request rejected")
+		r.Recorder.Event(instance, corev1.EventTypeWarning, app.ReasonResourcesNotGranted, err.Error())
+		instance.Status.Phase = app.PhaseFailed
+		r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonResourcesNotGranted, err)
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "failed to check the platform resource requests")
+		r.setFailedCondition(instance, app.ConditionResourcesGranted, app.ReasonResourceRequestFailed, err)
+		return reconcile.Result{}, err
+	}
+	observeGrants(types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}, approved, pending)
+	if len(pending) > 0 {
+		//The request is enqueued again by the watch of the platform resources when the approval status changes, the
+		//periodic check covers the missed events
+		logger.V(1).Info("Platform resource requests are not approved yet")
+		return reconcile.Result{RequeueAfter: grantCheckInterval}, nil
+	}
+
+	instance.SetCondition(app.ConditionResourcesGranted, metav1.ConditionTrue, app.ReasonResourcesGranted, "All of the platform resource requests have been approved")
+	r.Recorder.Event(instance, corev1.EventTypeNormal, app.ReasonResourcesGranted, "All of the platform resource requests have been approved")
+	return r.advancePhase(instance, app.PhaseDeploying)
+}
+
+func (r *ConsulReconciler) handleDeploying(logger logr.Logger, instance *app.Consul, namespace string) (reconcile.Result, error) {
+	//Execute templating for the app-deplyoment directory using the values from the CR
+	if _, err := renderTemplates(instance, namespace, appDeploymentDir); err != nil {
+		logger.Error(err, "Failed to execute the CR appDeploymentTemplater")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonTemplatingFailed, err)
+		return reconcile.Result{}, err
+	}
+
+	//The chart mounts the keys and certificates, they have to exist before the pods are started
+	if err := r.ensureSecuritySecrets(instance, namespace); err != nil {
+		logger.Error(err, "Failed to generate the security secrets")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonSecretsFailed, err)
+		return reconcile.Result{}, err
+	}
+
+	//Optional - Helm based deployment, installs or upgrades the release
+	action, err := deployRelease(namespace, instance.Status.HelmRelease)
+	r.recordHelmDeploy(instance, action, err)
+	if err != nil {
+		logger.Error(err, "Failed to deploy the helm chart")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonDeployFailed, err)
+		return reconcile.Result{}, err
+	}
+
+	//This section is only needed if helm is not used for the deployment
+	//k8sClient := k8sdynamic.New(kubelib.GetKubeAPI())
+	//appliedApplicationResourceDescriptors, err := k8sClient.ApplyConcatenatedResources(out, namespace)
+	//if err != nil {
+	//	logger.Error(err, "failed to apply the templated resources")
+	//	return reconcile.Result{}, err
+	//}
+	//instance.Status.AppliedResources = append(instance.Status.AppliedResources, appliedApplicationResourceDescriptors...)
+
+	instance.Status.PrevSpec = instance.Spec.DeepCopy()
+	setDeployedConditions(instance)
+	if nil == meta.FindStatusCondition(instance.Status.Conditions, app.ConditionLicenceValid) {
+		instance.SetCondition(app.ConditionLicenceValid, metav1.ConditionTrue, app.ReasonLicenceActive, "Application licence is valid")
+	}
+	instance.Status.Phase = nextPostDeployPhase(instance)
+	if err := r.updateStatus(instance); nil != err {
+		logger.Error(err, "status applied resources and previous spec update failed")
+		return reconcile.Result{}, err
+	}
+	//The monitoring and the backups are started in the Running phase
+	return reconcile.Result{Requeue: true}, nil
+}
+
+// nextPostDeployPhase returns the next step to be executed on the deployed cluster before it is running
+func nextPostDeployPhase(instance *app.Consul) app.Phase {
+	if isACLEnabled(instance) {
+		return app.PhaseBootstrappingACL
+	}
+	return nextPostACLPhase(instance)
+}
+
+// nextPostACLPhase returns the next step to be executed on the deployed cluster once its ACL tokens are set. The
+// snapshot is restored with the bootstrap token, the ACL phase is executed again after the restore.
+func nextPostACLPhase(instance *app.Consul) app.Phase {
+	if isRestoreNeeded(instance) {
+		return app.PhaseRestoring
+	}
+	return app.PhaseRunning
+}
+
+// handleBootstrappingACL bootstraps the ACL system of the deployed cluster and sets its tokens, it is retried with
+// backoff until the servers have elected their leader. It is executed after every deployment, the bootstrap is done
+// only once.
+func (r *ConsulReconciler) handleBootstrappingACL(logger logr.Logger, instance *app.Consul, namespace string) (reconcile.Result, error) {
+	managementToken, err := r.bootstrapACL(instance, namespace)
+	if errors.Is(err, errACLBootstrapNotAllowed) {
+		//Retrying does not help, the deployment is restarted when the spec is changed
+		logger.Error(err, "ACL bootstrap token rejected")
+		r.Recorder.Event(instance, corev1.EventTypeWarning, app.ReasonACLTokenRejected, err.Error())
+		instance.Status.Phase = app.PhaseFailed
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonACLTokenRejected, err)
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		logger.Error(err, "Failed to bootstrap the ACL system")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonACLBootstrapFailed, err)
+		return reconcile.Result{}, err
+	}
+	if err := r.ensureACLTokens(instance, namespace, managementToken); err != nil {
+		logger.Error(err, "Failed to set the ACL tokens")
+		r.setFailedCondition(instance, app.ConditionDeployed, app.ReasonACLBootstrapFailed, err)
+		return reconcile.Result{}, err
+	}
+	setDeployedConditions(instance)
+	return r.advancePhase(instance, nextPostACLPhase(instance))
+}
+
+// handleFailed restarts the deployment when the spec has been changed since the failure
+func (r *ConsulReconciler) handleFailed(logger logr.Logger, instance *app.Consul) (reconcile.Result, error) {
+	degraded := meta.FindStatusCondition(instance.Status.Conditions, app.ConditionDegraded)
+	if degraded != nil && degraded.ObservedGeneration == instance.GetGeneration() {
+		logger.Info("Deployment failed, waiting for the spec to be changed")
+		return reconcile.Result{}, nil
+	}
+	logger.Info("Spec changed since the failure, restart the deployment")
+	return r.advancePhase(instance, app.PhaseTemplating)
+}
+
+// staleResources returns the previously applied resources which are missing from the current ones
+func staleResources(previous []k8sdynamic.ResourceDescriptor, current []k8sdynamic.ResourceDescriptor) []k8sdynamic.ResourceDescriptor {
+	var stale []k8sdynamic.ResourceDescriptor
+	for _, prev := range previous {
+		found := false
+		for _, cur := range current {
+			if prev == cur {
+				found = true
+				break
+			}
+		}
+		if !found {
+			stale = append(stale, prev)
+		}
+	}
+	return stale
+}
+
+// templateValues are the values of the CR based templating, the fields of the spec and the storage layout of the
+// instance
+type templateValues struct {
+	app.ConsulSpec
+	// LegacyStorage keeps the single Storage and the fixed node name of the instances deployed before the per-server
+	// storage
+	LegacyStorage bool
+	// Names are the names of the resources of the instance
+	Names appNames
+}
+
+// renderTemplates executes the CR based templating to resolve the variables in the given directory. The files are
+// generated into the directory of the helm release of the instance, it is returned.
+func renderTemplates(instance *app.Consul, namespace string, dir string) (string, error) {
+	values := templateValues{ConsulSpec: instance.Spec, LegacyStorage: usesLegacyStorage(instance), Names: namesOf(instance)}
+	templater, err := template.NewTemplater(values, namespace, helmReleaseOf(instance), dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to initialize the templater of %v", dir)
+	}
+	if _, err = templater.RunCrTemplater("---\n"); err != nil {
+		return "", errors.Wrapf(err, "failed to execute the templater of %v", dir)
+	}
+	return templater.WorkDir, nil
+}
+
+// startMonitoring starts the application status monitor and the licence handler, both are started only once. The
+// monitor and the handler work on their own copies of the instance. Returns true if the licence handler has been
+// started by this call.
+func (r *ConsulReconciler) startMonitoring(instance *app.Consul, namespace string) bool {
+	logger := log.WithName("handlers").WithName("startMonitoring").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
+	names := namesOf(instance)
+
+	//Controls the appStatus and appReportedData in the app spec CR, running continuously in the background
+	appStatusMonitor := monitoring.NewMonitor(r.Client, r.Recorder, instance, namespace, names.podSelector(),
+		func(instance *app.Consul) int {
+			//Every server of the cluster is checked
+			return int(serverCount(instance))
+		},
+		func(instance *app.Consul) {
+			logger.Info("Set AppReportedData")
+			//runningCallback - example, some dynamic data should be reported here which has value only after the deployment
+			svc, err := kubelib.GetKubeAPI().CoreV1().Services(namespace).Get(context.TODO(), names.Service, metav1.GetOptions{})
+			if err != nil {
+				logger.Error(err, "Failed to read the svc of the metrics endpoint")
+				return
+			}
+			instance.Status.AppReportedData.MetricsClusterIp = svc.Spec.ClusterIP
+			if instance.Spec.PrivateNetworkAccess != nil {
+				instance.Status.AppReportedData.PrivateNetworkIpAddress = getPrivateNetworkIpAddresses(
+					namespace,
+					names.PrivateNetworkAccess,
+					[]deploymentId{
+						{deploymentTypeStatefulset, names.App},
+					},
+				)
+			}
+
+			if err := r.updateAppReportedData(instance); nil != err {
+				logger.Error(err, "status app reported data update failed")
+			}
+		},
+		func(instance *app.Consul) {
+			//notRunningCallback
+		},
+	)
+	//The monitor of a frozen application is started by the reactivation of the licence
+	if instance.Status.AppStatus != app.AppStatusFrozen {
+		appStatusMonitor.Run()
+	}
+
+	//Handles the application license expiration, reactivation
+	key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+	licCallbacks := &licenceexpired.SampleFuncs{
+		RuntimeClient:   r.Client,
+		Recorder:        r.Recorder,
+		AppInstance:     instance.DeepCopy(),
+		ClientSet:       kubelib.GetKubeAPI(),
+		Monitor:         appStatusMonitor,
+		ServiceSelector: names.serviceSelector(),
+		Redeploy: func() {
+			r.requestRedeploy(key)
+		},
+	}
+
+	return licenceexpired.New(key, licCallbacks).Watch()
+}
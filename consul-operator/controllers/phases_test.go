@@ -0,0 +1,346 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/template"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/util/copy"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/util/finalizer"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const testNamespace = "app"
+
+// newTestReconciler returns a reconciler working on the fake client with the given objects
+func newTestReconciler(t *testing.T, objects ...client.Object) (*ConsulReconciler, *record.FakeRecorder) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := app.AddToScheme(scheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recorder := record.NewFakeRecorder(100)
+	return &ConsulReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Scheme:   scheme,
+		Recorder: recorder,
+	}, recorder
+}
+
+// newTestConsul returns a defaulted Consul with the finalizer in the given phase
+func newTestConsul(name string, phase app.Phase) *app.Consul {
+	instance := &app.Consul{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         testNamespace,
+		Generation:        1,
+		CreationTimestamp: metav1.Now(),
+		Finalizers:        []string{finalizer.FinalizerId},
+	}}
+	instance.Default()
+	instance.Status.Phase = phase
+	return instance
+}
+
+// getConsul returns the stored version of the instance
+func getConsul(t *testing.T, r *ConsulReconciler, instance *app.Consul) *app.Consul {
+	t.Helper()
+	stored := &app.Consul{}
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(instance), stored); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return stored
+}
+
+// expectCondition checks the status and the reason of the condition of the stored instance
+func expectCondition(t *testing.T, instance *app.Consul, conditionType string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	condition := meta.FindStatusCondition(instance.Status.Conditions, conditionType)
+	if condition == nil || condition.Status != status || condition.Reason != reason {
+		t.Errorf("expected %v condition %v with reason %v, got %+v", conditionType, status, reason, condition)
+	}
+}
+
+// recordedEvents returns the events recorded since the last call
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// testNames are the names of the resources of the test instances named consul
+var testNames = derivedNames("consul")
+
+// generatedFile returns the path of the file generated for the instance from the deployment directory
+func generatedFile(dir string, instance *app.Consul, deploymentDir, file string) string {
+	return filepath.Join(template.WorkDir(dir, deploymentDir, testNamespace, helmReleaseOf(instance)), file)
+}
+
+// withDeploymentDir points the templater to a copy of the deployment directory for the duration of the test
+func withDeploymentDir(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "deployment")
+	if err := copy.CopyDir(filepath.Join("..", "deployment"), dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	withEnv(t, template.DeploymentDir, dir)
+	return dir
+}
+
+func withEnv(t *testing.T, name, value string) {
+	t.Helper()
+	prev, found := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if found {
+			os.Setenv(name, prev)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestHandleTemplating(t *testing.T) {
+	dir := withDeploymentDir(t)
+	instance := newTestConsul("consul", app.PhaseTemplating)
+	instance.Spec.ReplicaCount = 3
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.handleCreate(instance, testNamespace)
+	if err != nil || !result.Requeue {
+		t.Fatalf("expected requeue without error, got %+v, %v", result, err)
+	}
+	stored := getConsul(t, r, instance)
+	if stored.Status.Phase != app.PhaseRequestingResources {
+		t.Errorf("expected phase %v, got %v", app.PhaseRequestingResources, stored.Status.Phase)
+	}
+	expectCondition(t, stored, app.ConditionDegraded, metav1.ConditionFalse, app.ReasonReconciled)
+
+	//A Storage is requested for every server
+	storage, err := ioutil.ReadFile(generatedFile(dir, instance, resourceReqsDir, "storage_for_db.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"consul-data-consul-0", "consul-data-consul-2"} {
+		if !strings.Contains(string(storage), name) {
+			t.Errorf("expected the Storage %v, got %s", name, storage)
+		}
+	}
+}
+
+// TestRenderTemplatesInstances checks that the instances of a namespace are generated into their own directories with
+// their own resource names
+func TestRenderTemplatesInstances(t *testing.T) {
+	dir := withDeploymentDir(t)
+	withDynClient(t)
+	first := newTestConsul("first", app.PhaseTemplating)
+	second := newTestConsul("second", app.PhaseTemplating)
+	second.Spec.ReplicaCount = 3
+
+	requests := make(map[string]string)
+	for _, instance := range []*app.Consul{first, second} {
+		requestDir, err := renderTemplates(instance, testNamespace, resourceReqsDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := renderTemplates(instance, testNamespace, appDeploymentDir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		descriptors, err := platformres.DescribePlatformResourceRequests(newDynClient(), requestDir, testNamespace)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, descriptor := range descriptors {
+			if other, found := requests[descriptor.Name]; found {
+				t.Errorf("the request %v of %v is requested by %v as well", descriptor.Name, instance.Name, other)
+			}
+			requests[descriptor.Name] = instance.Name
+		}
+
+		values, err := ioutil.ReadFile(generatedFile(dir, instance, appDeploymentDir, "values.yaml"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(values), "  app: "+instance.Name+"\n") {
+			t.Errorf("expected the names of %v in the values, got %s", instance.Name, values)
+		}
+	}
+	for _, name := range []string{"first-data-first-0", "second-data-second-2", "first-resources", "second-resources"} {
+		if _, found := requests[name]; !found {
+			t.Errorf("expected the request %v, got %v", name, requests)
+		}
+	}
+}
+
+func TestHandleTemplatingLegacyStorage(t *testing.T) {
+	dir := withDeploymentDir(t)
+	instance := newTestConsul("consul", app.PhaseTemplating)
+	withLegacyStorage(instance)
+	r, _ := newTestReconciler(t, instance)
+
+	if _, err := r.handleCreate(instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	storage, err := ioutil.ReadFile(generatedFile(dir, instance, resourceReqsDir, "storage_for_db.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(storage), "name: "+legacyStorageName) || strings.Contains(string(storage), "-data-") {
+		t.Errorf("expected only the legacy Storage, got %s", storage)
+	}
+}
+
+func TestHandleTemplatingFailed(t *testing.T) {
+	withEnv(t, template.DeploymentDir, filepath.Join(t.TempDir(), "missing"))
+	instance := newTestConsul("consul", app.PhaseTemplating)
+	r, _ := newTestReconciler(t, instance)
+
+	//The error is returned so the phase is retried with backoff
+	if _, err := r.handleCreate(instance, testNamespace); err == nil {
+		t.Fatalf("expected error")
+	}
+	stored := getConsul(t, r, instance)
+	if stored.Status.Phase != app.PhaseTemplating {
+		t.Errorf("expected phase %v, got %v", app.PhaseTemplating, stored.Status.Phase)
+	}
+	expectCondition(t, stored, app.ConditionDegraded, metav1.ConditionTrue, app.ReasonTemplatingFailed)
+	expectCondition(t, stored, app.ConditionResourcesGranted, metav1.ConditionFalse, app.ReasonTemplatingFailed)
+}
+
+func TestHandleFailed(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation int64
+		phase      app.Phase
+		requeue    bool
+	}{
+		{"spec not changed", 1, app.PhaseFailed, false},
+		{"spec changed", 2, app.PhaseTemplating, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseFailed)
+			instance.SetCondition(app.ConditionDegraded, metav1.ConditionTrue, app.ReasonResourcesNotGranted, "rejected")
+			instance.Generation = test.generation
+			r, _ := newTestReconciler(t, instance)
+
+			result, err := r.handleCreate(instance, testNamespace)
+			if err != nil || result.Requeue != test.requeue {
+				t.Fatalf("expected requeue %v without error, got %+v, %v", test.requeue, result, err)
+			}
+			if stored := getConsul(t, r, instance); stored.Status.Phase != test.phase {
+				t.Errorf("expected phase %v, got %v", test.phase, stored.Status.Phase)
+			}
+		})
+	}
+}
+
+func TestHandleUnknownPhase(t *testing.T) {
+	instance := newTestConsul("consul", "Unknown")
+	r, _ := newTestReconciler(t, instance)
+
+	result, err := r.handleCreate(instance, testNamespace)
+	if err != nil || !result.Requeue {
+		t.Fatalf("expected requeue without error, got %+v, %v", result, err)
+	}
+	if stored := getConsul(t, r, instance); stored.Status.Phase != app.PhaseTemplating {
+		t.Errorf("expected phase %v, got %v", app.PhaseTemplating, stored.Status.Phase)
+	}
+}
+
+func TestNextPostDeployPhase(t *testing.T) {
+	tests := []struct {
+		name     string
+		security *app.Security
+		restore  *app.Restore
+		restored string
+		phase    app.Phase
+		postACL  app.Phase
+	}{
+		{"plain", nil, nil, "", app.PhaseRunning, app.PhaseRunning},
+		{"acl", &app.Security{ACL: true}, nil, "", app.PhaseBootstrappingACL, app.PhaseRunning},
+		{"tls only", &app.Security{TLS: true}, nil, "", app.PhaseRunning, app.PhaseRunning},
+		{"restore", nil, &app.Restore{Snapshot: "consul.snap"}, "", app.PhaseRestoring, app.PhaseRestoring},
+		{"acl and restore", &app.Security{ACL: true}, &app.Restore{Snapshot: "consul.snap"}, "", app.PhaseBootstrappingACL, app.PhaseRestoring},
+		{"restored", nil, &app.Restore{Snapshot: "consul.snap"}, "consul.snap", app.PhaseRunning, app.PhaseRunning},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseDeploying)
+			instance.Spec.Security = test.security
+			instance.Spec.Restore = test.restore
+			instance.Status.Backup.RestoredSnapshot = test.restored
+
+			if phase := nextPostDeployPhase(instance); phase != test.phase {
+				t.Errorf("expected phase %v, got %v", test.phase, phase)
+			}
+			if phase := nextPostACLPhase(instance); phase != test.postACL {
+				t.Errorf("expected phase after the ACL %v, got %v", test.postACL, phase)
+			}
+		})
+	}
+}
+
+func TestHandleCrChangeAddsFinalizer(t *testing.T) {
+	instance := newTestConsul("consul", "")
+	instance.Finalizers = nil
+	r, _ := newTestReconciler(t, instance)
+
+	if _, err := r.handleCrChange(instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored := getConsul(t, r, instance); !finalizer.HasFinalizers(stored) {
+		t.Errorf("expected the finalizer to be added")
+	}
+}
+
+func TestRequeueFirst(t *testing.T) {
+	tests := []struct {
+		name   string
+		result reconcile.Result
+		next   time.Duration
+		want   reconcile.Result
+	}{
+		{"sweep first", reconcile.Result{RequeueAfter: time.Hour}, time.Minute, reconcile.Result{RequeueAfter: time.Minute}},
+		{"backup first", reconcile.Result{RequeueAfter: time.Minute}, time.Hour, reconcile.Result{RequeueAfter: time.Minute}},
+		{"no backup", reconcile.Result{}, time.Hour, reconcile.Result{RequeueAfter: time.Hour}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := requeueFirst(test.result, test.next); got != test.want {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// watchPlatformResources enqueues the Consul instances when the approval status of their platform resource requests
+// changes or the requests are removed, so the reconciliation never has to wait for the platform
+func (r *ConsulReconciler) watchPlatformResources(mgr ctrl.Manager, c controller.Controller) error {
+	logger := log.WithName("watchPlatformResources")
+
+	for _, kind := range platformres.Kinds {
+		gvk := schema.GroupVersionKind{Group: platformres.Group, Version: platformres.Version, Kind: kind}
+		mapping, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				logger.Info("Platform resource is not installed, skip watching it", "kind", kind)
+				continue
+			}
+			return err
+		}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		err = c.Watch(&source.Kind{Type: obj}, handler.EnqueueRequestsFromMapFunc(r.consulsRequesting(mapping.Resource)), platformResourcePredicate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	pendingGrantsMu sync.Mutex
+	// pendingGrants are the platform resource requests of the instances which have been seen waiting for their approval
+	pendingGrants = make(map[types.NamespacedName]map[types.UID]bool)
+)
+
+var platformResourcePredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return platformres.ApprovalStatusChanged(e.ObjectOld, e.ObjectNew)
+	},
+	GenericFunc: func(event.GenericEvent) bool {
+		return false
+	},
+}
+
+// observeGrants records the grant time of the platform resource requests of the instance which have been seen
+// pending and are approved now, and keeps the ones which are still pending. Only the observed approvals are recorded:
+// the requests approved before they were seen, eg. approved while the operator was not running or approved earlier
+// and checked again by a later WaitingForGrant phase, are not recorded.
+func observeGrants(key types.NamespacedName, approved []*unstructured.Unstructured, pending []*unstructured.Unstructured) {
+	pendingGrantsMu.Lock()
+	defer pendingGrantsMu.Unlock()
+
+	seen := pendingGrants[key]
+	for _, obj := range approved {
+		if seen[obj.GetUID()] {
+			metrics.ObserveResourceGrant(obj.GetKind(), time.Since(obj.GetCreationTimestamp().Time))
+		}
+	}
+
+	if len(pending) == 0 {
+		delete(pendingGrants, key)
+		return
+	}
+	stillPending := make(map[types.UID]bool, len(pending))
+	for _, obj := range pending {
+		stillPending[obj.GetUID()] = true
+	}
+	pendingGrants[key] = stillPending
+}
+
+// forgetGrants drops the pending requests of the deleted instance
+func forgetGrants(key types.NamespacedName) {
+	pendingGrantsMu.Lock()
+	delete(pendingGrants, key)
+	pendingGrantsMu.Unlock()
+}
+
+// consulsRequesting returns the mapping of a platform resource request of the given resource to the Consul instances
+// which have applied it
+func (r *ConsulReconciler) consulsRequesting(gvr schema.GroupVersionResource) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		logger := log.WithName("consulsRequesting").WithValues("namespace", obj.GetNamespace(), "name", obj.GetName(), "resource", gvr.Resource)
+
+		consuls := &app.ConsulList{}
+		if err := r.List(context.TODO(), consuls, client.InNamespace(obj.GetNamespace())); err != nil {
+			logger.Error(err, "failed to list the Consul instances")
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, consul := range consuls.Items {
+			for _, resource := range consul.Status.AppliedResources {
+				if resource.Name == obj.GetName() && resource.Gvr.Group == gvr.Group && resource.Gvr.Resource == gvr.Resource {
+					requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: consul.Namespace, Name: consul.Name}})
+					break
+				}
+			}
+		}
+		return requests
+	}
+}
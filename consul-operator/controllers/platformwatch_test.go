@@ -0,0 +1,112 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// withGrantCheck replaces the approval check of the platform resource requests for the duration of the test, the
+// requests are approved once approve is set
+func withGrantCheck(t *testing.T, request *unstructured.Unstructured, approve *bool) {
+	t.Helper()
+	prev := checkResourcesGranted
+	checkResourcesGranted = func([]k8sdynamic.ResourceDescriptor) ([]*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+		if *approve {
+			return []*unstructured.Unstructured{request}, nil, nil
+		}
+		return nil, []*unstructured.Unstructured{request}, nil
+	}
+	t.Cleanup(func() {
+		checkResourcesGranted = prev
+	})
+}
+
+// grantSamples returns the number of the grant times recorded for the kind
+func grantSamples(t *testing.T, kind string) uint64 {
+	t.Helper()
+	families, err := ctrlmetrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "consul_operator_platform_resource_grant_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "kind" && label.GetValue() == kind {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func newTestRequest(kind string) *unstructured.Unstructured {
+	request := &unstructured.Unstructured{}
+	request.SetKind(kind)
+	request.SetName("request")
+	request.SetUID(types.UID(kind + "-uid"))
+	request.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-time.Minute)))
+	return request
+}
+
+func TestHandleWaitingForGrantObservesApproval(t *testing.T) {
+	const kind = "ObservedApproval"
+	approve := false
+	withGrantCheck(t, newTestRequest(kind), &approve)
+	instance := newTestConsul("consul", app.PhaseWaitingForGrant)
+	r, _ := newTestReconciler(t, instance)
+	key := types.NamespacedName{Namespace: testNamespace, Name: instance.Name}
+	t.Cleanup(func() { forgetGrants(key) })
+
+	result, err := r.handleCreate(instance, testNamespace)
+	if err != nil || result.RequeueAfter != grantCheckInterval {
+		t.Fatalf("expected requeue after %v without error, got %+v, %v", grantCheckInterval, result, err)
+	}
+
+	approve = true
+	if _, err := r.handleCreate(instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored := getConsul(t, r, instance); stored.Status.Phase != app.PhaseDeploying {
+		t.Fatalf("expected phase %v, got %v", app.PhaseDeploying, stored.Status.Phase)
+	}
+
+	//The approved request is checked again by the next WaitingForGrant phase, eg. after a scale up
+	instance.Status.Phase = app.PhaseWaitingForGrant
+	if _, err := r.handleCreate(instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if samples := grantSamples(t, kind); samples != 1 {
+		t.Errorf("expected one grant sample, got %v", samples)
+	}
+}
+
+func TestHandleWaitingForGrantApprovedBeforeSeen(t *testing.T) {
+	const kind = "ApprovedBeforeSeen"
+	approve := true
+	withGrantCheck(t, newTestRequest(kind), &approve)
+	instance := newTestConsul("consul", app.PhaseWaitingForGrant)
+	r, _ := newTestReconciler(t, instance)
+
+	//Eg. approved while the operator was not running, the time of the approval is not known
+	if _, err := r.handleCreate(instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if samples := grantSamples(t, kind); samples != 0 {
+		t.Errorf("expected no grant sample, got %v", samples)
+	}
+}
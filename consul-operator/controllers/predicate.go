@@ -63,6 +63,9 @@ func (CustomPredicate) Update(event event.UpdateEvent) bool {
 		} else if isDeleteEvent(oldInstance, newInstance) {
 			logger.Info("DeleteTimestamp changed")
 			return true
+		} else if isForceDeleteAnnotationChange(oldInstance, newInstance) {
+			logger.Info("Force delete annotation changed on the deleted object")
+			return true
 		}
 	}
 	logger.V(1).Info("Update event skipped")
@@ -81,6 +84,13 @@ func isDeleteEvent(oldInstance *app.Consul, newInstance *app.Consul) bool {
 	return oldInstance.DeletionTimestamp == nil && newInstance.DeletionTimestamp != nil
 }
 
+// isForceDeleteAnnotationChange reports whether the force delete annotation of an object being deleted has changed,
+// the metadata change does not increase the generation of the object
+func isForceDeleteAnnotationChange(oldInstance *app.Consul, newInstance *app.Consul) bool {
+	return newInstance.DeletionTimestamp != nil &&
+		oldInstance.GetAnnotations()[forceDeleteAnnotation] != newInstance.GetAnnotations()[forceDeleteAnnotation]
+}
+
 func (CustomPredicate) Generic(event.GenericEvent) bool {
 	logger := log.WithName("predicate").WithName("generic event")
 	logger.V(1).Info("Generic event received, skip it.")
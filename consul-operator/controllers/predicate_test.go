@@ -0,0 +1,53 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestCustomPredicateUpdate(t *testing.T) {
+	deleted := func(instance *app.Consul) *app.Consul {
+		deletion := metav1.Now()
+		instance.DeletionTimestamp = &deletion
+		return instance
+	}
+	annotated := func(instance *app.Consul, value string) *app.Consul {
+		instance.Annotations = map[string]string{forceDeleteAnnotation: value}
+		return instance
+	}
+	relabeled := func(instance *app.Consul) *app.Consul {
+		instance.Labels = map[string]string{"team": "platform"}
+		return instance
+	}
+
+	tests := []struct {
+		name     string
+		old, new *app.Consul
+		want     bool
+	}{
+		{"status only", newTestConsul("consul", app.PhaseRunning), newTestConsul("consul", app.PhaseDeploying), false},
+		{"deletion started", newTestConsul("consul", app.PhaseRunning), deleted(newTestConsul("consul", app.PhaseRunning)), true},
+		{"force delete annotated while deleted", deleted(newTestConsul("consul", app.PhaseRunning)),
+			annotated(deleted(newTestConsul("consul", app.PhaseRunning)), "true"), true},
+		{"force delete annotation changed while deleted", annotated(deleted(newTestConsul("consul", app.PhaseRunning)), "1h"),
+			annotated(deleted(newTestConsul("consul", app.PhaseRunning)), "true"), true},
+		{"force delete annotated while running", newTestConsul("consul", app.PhaseRunning),
+			annotated(newTestConsul("consul", app.PhaseRunning), "true"), false},
+		{"other metadata while deleted", deleted(newTestConsul("consul", app.PhaseRunning)),
+			relabeled(deleted(newTestConsul("consul", app.PhaseRunning))), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := (CustomPredicate{}).Update(event.UpdateEvent{ObjectOld: test.old, ObjectNew: test.new}); got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-logr/logr"
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/helm"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/licenceexpired"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var (
+	redeployRequestsMu sync.Mutex
+	redeployRequests   = make(map[types.NamespacedName]bool)
+)
+
+// deployRelease installs or upgrades the helm release of the instance, the tests replace it
+var deployRelease = func(namespace, release string) (string, error) {
+	return helm.NewHelm(namespace, release).Deploy()
+}
+
+// recoverInstances requests the reconciliation of the deployed instances after the start of the operator, it
+// reattaches their application status monitors and licence handlers. The in-memory state of the previous operator is
+// lost, the status of the CRs tells which instances are deployed. Nothing is deployed again, the reconciliation
+// continues from the stored phase.
+func (r *ConsulReconciler) recoverInstances(ctx context.Context) error {
+	logger := log.WithName("recovery")
+
+	consuls := &app.ConsulList{}
+	if err := r.List(ctx, consuls); err != nil {
+		return errors.Wrap(err, "failed to list the Consul instances")
+	}
+
+	for i := range consuls.Items {
+		instance := &consuls.Items[i]
+		if !isDeployed(instance) {
+			continue
+		}
+		namespace := instance.GetNamespace()
+		logger.Info("Reattach the monitoring of the deployed instance", "namespace", namespace, "name", instance.GetName(),
+			"phase", instance.Status.Phase, "appStatus", instance.Status.AppStatus)
+
+		key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+		metrics.SetAppStatus(key, instance.Status.AppStatus)
+		r.requestReconcile(key)
+	}
+	return nil
+}
+
+// recoverLicence reactivates the frozen instance if its licence has been reactivated while the operator was not
+// running. It is called when the licence handler of the instance has been started by this operator, so the frozen
+// status has been stored by the previous one.
+func recoverLicence(logger logr.Logger, instance *app.Consul, namespace string) {
+	frozen := instance.Status.AppStatus == app.AppStatusFrozen
+	key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+	if err := licenceexpired.Get(key).Recover(frozen); err != nil {
+		logger.Error(err, "failed to recover the licence state")
+	}
+}
+
+// requestReconcile enqueues the reconciliation of the instance, so the work started outside of the reconciliation,
+// eg. by the licence handler, is done by the reconciliation of the instance
+func (r *ConsulReconciler) requestReconcile(key types.NamespacedName) {
+	if nil == r.requests {
+		return
+	}
+	r.requests <- event.GenericEvent{Object: &app.Consul{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}}
+}
+
+// requestRedeploy makes the next reconciliation of the running instance deploy the application again
+func (r *ConsulReconciler) requestRedeploy(key types.NamespacedName) {
+	redeployRequestsMu.Lock()
+	redeployRequests[key] = true
+	redeployRequestsMu.Unlock()
+	r.requestReconcile(key)
+}
+
+// takeRedeployRequest reports whether the redeployment of the instance has been requested and forgets the request
+func takeRedeployRequest(key types.NamespacedName) bool {
+	redeployRequestsMu.Lock()
+	defer redeployRequestsMu.Unlock()
+	requested := redeployRequests[key]
+	delete(redeployRequests, key)
+	return requested
+}
+
+// forgetRedeploy drops the redeployment request of the deleted instance
+func forgetRedeploy(key types.NamespacedName) {
+	redeployRequestsMu.Lock()
+	delete(redeployRequests, key)
+	redeployRequestsMu.Unlock()
+}
+
+// redeployIfRequested deploys the application of the running instance again if it has been requested, eg. the
+// services deleted on the licence expiration are restored this way. A failed redeployment is requested again.
+func (r *ConsulReconciler) redeployIfRequested(logger logr.Logger, instance *app.Consul, namespace string) error {
+	key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+	if !takeRedeployRequest(key) {
+		return nil
+	}
+
+	logger.Info("Redeploy the application")
+	_, err := renderTemplates(instance, namespace, appDeploymentDir)
+	if err == nil {
+		var action string
+		action, err = deployRelease(namespace, instance.Status.HelmRelease)
+		r.recordHelmDeploy(instance, action, err)
+	}
+	if err != nil {
+		redeployRequestsMu.Lock()
+		redeployRequests[key] = true
+		redeployRequestsMu.Unlock()
+		return errors.Wrap(err, "failed to redeploy the application")
+	}
+	return nil
+}
+
+// isDeployed reports whether the application of the instance has been deployed and not yet removed
+func isDeployed(instance *app.Consul) bool {
+	return instance.Status.PrevSpec != nil && instance.ObjectMeta.DeletionTimestamp == nil
+}
+
+// setupRecovery runs the recovery once the caches of the manager have been synced and the operator has been elected
+// leader, so only the active operator monitors the instances
+func (r *ConsulReconciler) setupRecovery(mgr manager.Manager) error {
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if err := r.recoverInstances(ctx); err != nil {
+			//The instances are recovered by their reconciliation in the Running phase as well
+			log.Error(err, "startup recovery failed")
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,185 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/licenceexpired"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// withDeployRelease replaces the helm install for the duration of the test, it returns the number of the calls
+func withDeployRelease(t *testing.T, deployErr error) *int {
+	t.Helper()
+	calls := 0
+	prev := deployRelease
+	deployRelease = func(string, string) (string, error) {
+		calls++
+		return "upgraded", deployErr
+	}
+	t.Cleanup(func() {
+		deployRelease = prev
+	})
+	return &calls
+}
+
+// requestedReconciles returns the names of the instances whose reconciliation has been requested
+func requestedReconciles(r *ConsulReconciler) []string {
+	var names []string
+	for {
+		select {
+		case request := <-r.requests:
+			names = append(names, request.Object.GetName())
+		default:
+			return names
+		}
+	}
+}
+
+// fakeLicenceCallbacks records the calls of the licence handler
+type fakeLicenceCallbacks struct {
+	expired   int
+	activated int
+}
+
+func (cb *fakeLicenceCallbacks) Expired() {
+	cb.expired++
+}
+
+func (cb *fakeLicenceCallbacks) Activate() {
+	cb.activated++
+}
+
+func TestRecoverInstances(t *testing.T) {
+	deploys := withDeployRelease(t, nil)
+	deployed := newTestConsul("deployed", app.PhaseRunning)
+	deployed.Status.PrevSpec = deployed.Spec.DeepCopy()
+	deployed.Status.AppStatus = app.AppStatusRunning
+	deleted := newDeletedConsul(0)
+	deleted.Name = "deleted"
+	notDeployed := newTestConsul("new", app.PhaseWaitingForGrant)
+	r, _ := newTestReconciler(t, deployed, deleted, notDeployed)
+	r.requests = make(chan event.GenericEvent, 3)
+
+	if err := r.recoverInstances(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requested := requestedReconciles(r); !reflect.DeepEqual(requested, []string{"deployed"}) {
+		t.Errorf("expected the reconciliation of the deployed instance only, got %v", requested)
+	}
+
+	//The reconciliation of the recovered instance continues in the stored phase without deploying again
+	key := types.NamespacedName{Namespace: testNamespace, Name: deployed.Name}
+	t.Cleanup(func() {
+		forgetRedeploy(key)
+	})
+	if err := r.redeployIfRequested(log, getConsul(t, r, deployed), testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *deploys != 0 {
+		t.Errorf("expected no redeployment, got %v", *deploys)
+	}
+	if stored := getConsul(t, r, deployed); stored.Status.Phase != app.PhaseRunning {
+		t.Errorf("expected the phase %v to be kept, got %v", app.PhaseRunning, stored.Status.Phase)
+	}
+}
+
+func TestRedeployIfRequested(t *testing.T) {
+	withDeploymentDir(t)
+	instance := newTestConsul("consul", app.PhaseRunning)
+	instance.Status.PrevSpec = instance.Spec.DeepCopy()
+	r, _ := newTestReconciler(t, instance)
+	r.requests = make(chan event.GenericEvent, 1)
+	key := types.NamespacedName{Namespace: testNamespace, Name: instance.Name}
+	t.Cleanup(func() {
+		forgetRedeploy(key)
+	})
+
+	//The failed redeployment is requested again
+	deploys := withDeployRelease(t, errors.New("helm failed"))
+	r.requestRedeploy(key)
+	if err := r.redeployIfRequested(log, instance, testNamespace); err == nil {
+		t.Fatal("expected an error")
+	}
+	if *deploys != 1 {
+		t.Errorf("expected one deployment, got %v", *deploys)
+	}
+
+	deploys = withDeployRelease(t, nil)
+	if err := r.redeployIfRequested(log, instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *deploys != 1 {
+		t.Errorf("expected one deployment, got %v", *deploys)
+	}
+	if takeRedeployRequest(key) {
+		t.Error("expected the redeployment request to be forgotten")
+	}
+}
+
+func TestRecoverLicence(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: licenceexpired.Group, Version: licenceexpired.Version, Resource: licenceexpired.Resource}
+	licenceExpired := &unstructured.Unstructured{}
+	licenceExpired.SetAPIVersion(licenceexpired.Group + "/" + licenceexpired.Version)
+	licenceExpired.SetKind("LicenceExpired")
+	licenceExpired.SetNamespace(testNamespace)
+	licenceExpired.SetName("licence-expired")
+
+	tests := []struct {
+		name        string
+		appStatus   app.AppStatus
+		objects     []runtime.Object
+		activations int
+	}{
+		{
+			name:        "frozen and reactivated",
+			appStatus:   app.AppStatusFrozen,
+			activations: 1,
+		},
+		{
+			name:      "frozen and still expired",
+			appStatus: app.AppStatusFrozen,
+			objects:   []runtime.Object{licenceExpired},
+		},
+		{
+			name:      "running",
+			appStatus: app.AppStatusRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseRunning)
+			instance.Status.AppStatus = tt.appStatus
+			key := types.NamespacedName{Namespace: testNamespace, Name: instance.Name}
+			dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{gvr: "LicenceExpiredList"}, tt.objects...)
+			callbacks := &fakeLicenceCallbacks{}
+			licenceexpired.NewWithClient(key, callbacks, dynClient)
+			t.Cleanup(func() {
+				licenceexpired.Remove(key)
+			})
+
+			recoverLicence(log, instance, testNamespace)
+
+			if callbacks.activated != tt.activations {
+				t.Errorf("expected %v activations, got %v", tt.activations, callbacks.activated)
+			}
+			if callbacks.expired != 0 {
+				t.Errorf("expected no expiration, got %v", callbacks.expired)
+			}
+		})
+	}
+}
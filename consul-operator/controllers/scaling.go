@@ -0,0 +1,144 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/libs/kubelib"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	//Name of the container of the statefulset in the app-deployment chart
+	consulContainer = "example-consul"
+	//Name of the single Storage of the instances deployed before the per-server storage
+	legacyStorageName = "storage-for-db"
+)
+
+// usesLegacyStorage reports whether the instance has been deployed with the single storage-for-db Storage. Such an
+// instance keeps its Storage, its claim and its node name, moving its data to the per-server claims would need a
+// migration. It is not scaled to a multi-server cluster.
+func usesLegacyStorage(instance *app.Consul) bool {
+	for _, resource := range instance.Status.AppliedResources {
+		if platformres.IsStorage(resource) && resource.Name == legacyStorageName {
+			return true
+		}
+	}
+	return false
+}
+
+// scaleDown removes the servers above the new replica count from the Consul cluster before the statefulset and the
+// storage requests are shrunk. The statefulset is scaled down, the departing servers leave the cluster gracefully
+// by the preStop hook of their pods, and the peers left behind in the raft configuration are removed once the pods
+// are gone. It reports whether the scale down has been finished, it is called again until then.
+func (r *ConsulReconciler) scaleDown(instance *app.Consul, namespace string) (bool, error) {
+	logger := log.WithName("handlers").WithName("scaleDown").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
+
+	replicas := int32(instance.Spec.ReplicaCount)
+	if instance.Status.PrevSpec == nil || replicas >= int32(instance.Status.PrevSpec.ReplicaCount) || usesLegacyStorage(instance) {
+		return true, nil
+	}
+
+	names := namesOf(instance)
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: names.App}, sts); err != nil {
+		if k8serrors.IsNotFound(err) {
+			//Removed by the update of another field, it is deployed with the new replica count
+			return true, nil
+		}
+		return false, errors.Wrap(err, "failed to get the Consul statefulset")
+	}
+
+	if sts.Spec.Replicas != nil && *sts.Spec.Replicas > replicas {
+		logger.Info("Scale down the Consul statefulset", "replicas", replicas)
+		patch := client.MergeFrom(sts.DeepCopy())
+		sts.Spec.Replicas = &replicas
+		if err := r.Patch(context.TODO(), sts, patch); err != nil {
+			return false, errors.Wrap(err, "failed to scale down the Consul statefulset")
+		}
+		return false, nil
+	}
+
+	if sts.Status.Replicas > replicas {
+		logger.V(1).Info("Waiting for the removal of the pods", "replicas", sts.Status.Replicas)
+		return false, nil
+	}
+
+	return true, removeStalePeers(namespace, names, replicas, isACLEnabled(instance))
+}
+
+// removeStalePeers removes the servers of the removed pods which are still in the raft configuration, eg. because
+// the leave timed out. The commands are authorized by the operator token if the ACL system is enabled.
+func removeStalePeers(namespace string, names appNames, replicas int32, acl bool) error {
+	logger := log.WithName("handlers").WithName("removeStalePeers").WithValues("namespace", namespace)
+
+	out, err := kubelib.ExecInPod(namespace, names.podName(0), consulContainer, consulCommand(acl, "operator", "raft", "list-peers"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list the raft peers")
+	}
+
+	for _, peer := range parseRaftPeers(out) {
+		ordinal, isConsulPod := names.podOrdinal(peer.node)
+		if !isConsulPod || ordinal < replicas {
+			continue
+		}
+		logger.Info("Remove stale raft peer", "node", peer.node, "address", peer.address)
+		_, err := kubelib.ExecInPod(namespace, names.podName(0), consulContainer,
+			consulCommand(acl, "operator", "raft", "remove-peer", "-address="+peer.address))
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove the raft peer %v", peer.node)
+		}
+	}
+	return nil
+}
+
+// consulCommand returns the command executing the consul CLI in the server pod. With the ACL system enabled the CLI
+// reads the operator token from the file mounted by the chart, the token is never part of the command line: the exec
+// arguments are part of the request URL logged by the API server and they are visible in the process list.
+func consulCommand(acl bool, args ...string) []string {
+	if !acl {
+		return append([]string{"consul"}, args...)
+	}
+	script := `CONSUL_HTTP_TOKEN="$(cat ` + aclOperatorTokenFile + `)" exec consul`
+	for _, arg := range args {
+		script += " " + shellQuote(arg)
+	}
+	return []string{"/bin/sh", "-c", script}
+}
+
+// shellQuote quotes the argument for the shell
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+}
+
+type raftPeer struct {
+	node    string
+	address string
+}
+
+// parseRaftPeers parses the output of the consul operator raft list-peers command:
+//
+//	Node             ID                                    Address          State     Voter  RaftProtocol
+//	example-consul-0 2e3f7bd3-6d0f-2c36-97f7-7d1c1e6f2f7a  10.1.2.3:8300    leader    true   3
+func parseRaftPeers(out string) []raftPeer {
+	var peers []raftPeer
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		columns := strings.Fields(scanner.Text())
+		if len(columns) < 3 || columns[0] == "Node" {
+			continue
+		}
+		peers = append(peers, raftPeer{node: columns[0], address: columns[2]})
+	}
+	return peers
+}
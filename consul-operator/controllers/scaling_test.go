@@ -0,0 +1,187 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// withLegacyStorage marks the instance deployed with the single storage-for-db Storage
+func withLegacyStorage(instance *app.Consul) {
+	instance.Status.AppliedResources = []k8sdynamic.ResourceDescriptor{{
+		Name: legacyStorageName,
+		Gvr:  k8sdynamic.GroupVersionResource{Group: platformres.Group, Resource: platformres.StorageResource},
+	}}
+}
+
+func TestHandleUpdateLegacyStorage(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*app.ConsulSpec)
+		rejected bool
+		phase    app.Phase
+	}{
+		{"scale up", func(s *app.ConsulSpec) { s.ReplicaCount = 3 }, true, app.PhaseRunning},
+		{"scale up with other changes", func(s *app.ConsulSpec) {
+			s.ReplicaCount = 3
+			s.MetricsDomainName = "metrics.example.com"
+		}, true, app.PhaseRunning},
+		{"other changes", func(s *app.ConsulSpec) { s.MetricsDomainName = "metrics.example.com" }, false, app.PhaseDeploying},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := newTestConsul("consul", app.PhaseRunning)
+			withLegacyStorage(instance)
+			instance.Status.PrevSpec = instance.Spec.DeepCopy()
+			test.modify(&instance.Spec)
+			r, recorder := newTestReconciler(t, instance)
+
+			if _, err := r.handleUpdate(instance, testNamespace); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			stored := getConsul(t, r, instance)
+			if stored.Status.Phase != test.phase {
+				t.Errorf("expected phase %v, got %v", test.phase, stored.Status.Phase)
+			}
+			if test.rejected {
+				//The rejected generation is reconciled again, eg. by the sweep, it is not reported again
+				if _, err := r.handleUpdate(stored, testNamespace); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			events := recordedEvents(recorder)
+			if test.rejected {
+				expectCondition(t, stored, app.ConditionDeployed, metav1.ConditionFalse, app.ReasonUpdateRejected)
+				if len(events) != 1 || !strings.HasPrefix(events[0], "Warning "+app.ReasonUpdateRejected) {
+					t.Errorf("expected the warning event of the rejected scale up, got %v", events)
+				}
+			} else if len(events) != 0 {
+				t.Errorf("expected no events, got %v", events)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateImmutableRejectedOnce(t *testing.T) {
+	instance := newTestConsul("consul", app.PhaseRunning)
+	instance.Status.PrevSpec = instance.Spec.DeepCopy()
+	instance.Spec.Restore = &app.Restore{Snapshot: "consul-20210901120000.snap"}
+	r, recorder := newTestReconciler(t, instance)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.handleUpdate(getConsul(t, r, instance), testNamespace); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	stored := getConsul(t, r, instance)
+	expectCondition(t, stored, app.ConditionDeployed, metav1.ConditionFalse, app.ReasonUpdateRejected)
+	events := recordedEvents(recorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning "+app.ReasonUpdateRejected) {
+		t.Errorf("expected a single warning event of the rejected update, got %v", events)
+	}
+
+	//A new generation of the spec is rejected again
+	stored.Generation++
+	if _, err := r.handleUpdate(stored, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if events := recordedEvents(recorder); len(events) != 1 {
+		t.Errorf("expected the warning event of the new generation, got %v", events)
+	}
+}
+
+func TestHandleUpdateScaleDownWithRedeploy(t *testing.T) {
+	instance := newTestConsul("consul", app.PhaseRunning)
+	instance.Spec.ReplicaCount = 3
+	instance.Status.PrevSpec = instance.Spec.DeepCopy()
+	instance.Spec.ReplicaCount = 1
+	instance.Spec.Security = &app.Security{GossipEncryption: true}
+	replicas := int32(3)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: namesOf(instance).App, Namespace: testNamespace},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{Replicas: replicas},
+	}
+	r, _ := newTestReconciler(t, instance, sts)
+
+	//The removed servers leave the cluster before the release is uninstalled
+	result, err := r.handleUpdate(instance, testNamespace)
+	if err != nil || result.RequeueAfter != releaseCheckInterval {
+		t.Fatalf("expected requeue after %v without error, got %+v, %v", releaseCheckInterval, result, err)
+	}
+	if err := r.Get(context.TODO(), client.ObjectKeyFromObject(sts), sts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *sts.Spec.Replicas != 1 {
+		t.Errorf("expected the statefulset scaled down to 1, got %v", *sts.Spec.Replicas)
+	}
+	if stored := getConsul(t, r, instance); stored.Status.Phase != app.PhaseRunning {
+		t.Errorf("expected phase %v, got %v", app.PhaseRunning, stored.Status.Phase)
+	}
+}
+
+func TestParseRaftPeers(t *testing.T) {
+	out := `Node              ID                                    Address          State     Voter  RaftProtocol
+example-consul-0  2e3f7bd3-6d0f-2c36-97f7-7d1c1e6f2f7a  10.1.2.3:8300    leader    true   3
+example-consul-3  6a1c4c2e-0b7e-9d2d-1f0f-4f0b0e4e8a11  10.1.2.6:8300    follower  true   3
+
+`
+	expected := []raftPeer{
+		{node: "example-consul-0", address: "10.1.2.3:8300"},
+		{node: "example-consul-3", address: "10.1.2.6:8300"},
+	}
+	if peers := parseRaftPeers(out); !reflect.DeepEqual(peers, expected) {
+		t.Errorf("expected %+v, got %+v", expected, peers)
+	}
+}
+
+func TestPodOrdinal(t *testing.T) {
+	names := derivedNames(legacyAppName)
+	tests := []struct {
+		pod     string
+		ordinal int32
+		found   bool
+	}{
+		{"example-consul-0", 0, true},
+		{"example-consul-12", 12, true},
+		{"consul.default", 0, false},
+		{"example-consul-x", 0, false},
+		{"other-consul-1", 0, false},
+		//The pod of another instance of the namespace
+		{"example-consul-two-1", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.pod, func(t *testing.T) {
+			if ordinal, found := names.podOrdinal(test.pod); ordinal != test.ordinal || found != test.found {
+				t.Errorf("expected %v, %v, got %v, %v", test.ordinal, test.found, ordinal, found)
+			}
+		})
+	}
+}
+
+func TestConsulCommand(t *testing.T) {
+	if command := consulCommand(false, "operator", "raft", "list-peers"); !reflect.DeepEqual(command, []string{"consul", "operator", "raft", "list-peers"}) {
+		t.Errorf("expected the plain command, got %v", command)
+	}
+	command := consulCommand(true, "operator", "raft", "remove-peer", "-address=10.1.2.6:8300")
+	expected := []string{"/bin/sh", "-c",
+		`CONSUL_HTTP_TOKEN="$(cat /consul/acl/token)" exec consul 'operator' 'raft' 'remove-peer' '-address=10.1.2.6:8300'`}
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("expected the token to be read from the file, got %v", command)
+	}
+	if quoted := shellQuote("it's"); quoted != `'it'"'"'s'` {
+		t.Errorf("unexpected quoting %v", quoted)
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/certs"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	//The file of the operator token in the server pods, see the acl-operator-token volume of the chart
+	aclOperatorTokenFile = "/consul/acl/token"
+
+	gossipKeySecretKey = "key"
+	aclTokenSecretKey  = "token"
+
+	//Port of the HTTP API of the Consul servers
+	consulHttpPort   = 8500
+	consulDatacenter = "dc1"
+
+	consulApiTimeout = 10 * time.Second
+
+	//The anonymous token of Consul is used by the requests without a token
+	anonymousTokenID = "00000000-0000-0000-0000-000000000002"
+)
+
+// errACLBootstrapNotAllowed is returned when the stored bootstrap token is rejected by the cluster and its ACL system
+// cannot be bootstrapped again, retrying does not help
+var errACLBootstrapNotAllowed = errors.New("the stored ACL bootstrap token is rejected and the ACL system has been " +
+	"bootstrapped already, store a management token of the cluster in the Secret of the bootstrap token and change " +
+	"the spec to restart the deployment")
+
+// consulServiceAddress returns the address of the HTTP API of the Consul servers behind their service
+var consulServiceAddress = func(namespace, service string) string {
+	return fmt.Sprintf("http://%v.%v.svc:%v", service, namespace, consulHttpPort)
+}
+
+// aclPolicy is an ACL policy created by the operator after the bootstrap of the ACL system
+type aclPolicy struct {
+	Name        string
+	Description string
+	Rules       string
+}
+
+var (
+	// metricsPolicy lets the platform scrape the metrics endpoint of the agents. The MetricsEndpoint platform resource
+	// cannot pass a token, so the policy is attached to the anonymous token.
+	metricsPolicy = aclPolicy{
+		Name:        "metrics-read",
+		Description: "Scrape of the agent metrics endpoint",
+		Rules:       `agent_prefix "" { policy = "read" }`,
+	}
+	// operatorPolicy covers the calls of the operator to the servers, the raft peer removal on scale down and the
+	// consul leave in the preStop hook of the servers
+	operatorPolicy = aclPolicy{
+		Name:        "consul-operator",
+		Description: "Raft peer management and leave of the servers by the operator",
+		Rules: `operator = "write"
+agent_prefix "" { policy = "write" }
+node_prefix "" { policy = "read" }`,
+	}
+	// agentPolicy lets the servers register their nodes in the catalog
+	agentPolicy = aclPolicy{
+		Name:        "consul-agent",
+		Description: "Node registration of the servers",
+		Rules: `node_prefix "" { policy = "write" }
+service_prefix "" { policy = "read" }`,
+	}
+)
+
+// ensureSecuritySecrets generates the gossip key and the certificates enabled in the spec. The Secrets are generated
+// only once, they are kept on update so the running servers and the redeployed ones use the same keys.
+func (r *ConsulReconciler) ensureSecuritySecrets(instance *app.Consul, namespace string) error {
+	security := instance.Spec.Security
+	if security == nil {
+		return nil
+	}
+	names := namesOf(instance)
+
+	if security.GossipEncryption {
+		err := r.ensureSecret(instance, namespace, names.GossipKeySecret, corev1.SecretTypeOpaque, func() (map[string][]byte, error) {
+			key, err := certs.GenerateGossipKey()
+			if err != nil {
+				return nil, err
+			}
+			return map[string][]byte{gossipKeySecretKey: []byte(key)}, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if security.TLS {
+		err := r.ensureSecret(instance, namespace, names.TLSSecret, corev1.SecretTypeTLS, func() (map[string][]byte, error) {
+			return generateServerCerts(namespace, names)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateServerCerts generates the CA and the certificate shared by the servers. The server name is required by the
+// verify_server_hostname setting, the service names are used by the clients connecting from the cluster.
+func generateServerCerts(namespace string, names appNames) (map[string][]byte, error) {
+	ca, err := certs.GenerateCA("Consul Agent CA " + namespace)
+	if err != nil {
+		return nil, err
+	}
+	dnsNames := []string{
+		"server." + consulDatacenter + ".consul",
+		"localhost",
+		names.Service,
+		fmt.Sprintf("%v.%v.svc", names.Service, namespace),
+		fmt.Sprintf("*.%v.%v.svc", names.App, namespace),
+	}
+	server, err := certs.GenerateServerCert(ca, "server."+consulDatacenter+".consul", dnsNames, []net.IP{net.ParseIP("127.0.0.1")})
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"ca.crt":                ca.Cert,
+		corev1.TLSCertKey:       server.Cert,
+		corev1.TLSPrivateKeyKey: server.Key,
+	}, nil
+}
+
+// ensureSecret creates the Secret with the generated data unless it exists already. With owner references the Secret
+// is owned by the Consul instance and garbage collected with it, otherwise it is deleted by the cleanup of the instance.
+func (r *ConsulReconciler) ensureSecret(instance *app.Consul, namespace, name string, secretType corev1.SecretType, generate func() (map[string][]byte, error)) error {
+	logger := log.WithName("security").WithName("ensureSecret").WithValues("namespace", namespace, "secret", name)
+
+	err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, &corev1.Secret{})
+	if err == nil {
+		return nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get the secret %v", name)
+	}
+
+	data, err := generate()
+	if err != nil {
+		return errors.Wrapf(err, "failed to generate the data of the secret %v", name)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       secretType,
+		Data:       data,
+	}
+	if r.OwnerReferences {
+		if err := controllerutil.SetControllerReference(instance, secret, r.Scheme); err != nil {
+			return errors.Wrapf(err, "failed to set the owner of the secret %v", name)
+		}
+	}
+	if err := r.Create(context.TODO(), secret); err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create the secret %v", name)
+	}
+	logger.Info("Secret generated")
+	return nil
+}
+
+// deleteSecuritySecrets deletes the generated Secrets of the deleted instance, they are not garbage collected without
+// owner references
+func (r *ConsulReconciler) deleteSecuritySecrets(namespace string, names appNames) error {
+	for _, name := range names.secrets() {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		if err := r.Delete(context.TODO(), secret); err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete the secret %v", name)
+		}
+	}
+	return nil
+}
+
+// isACLEnabled reports whether the ACL system of the deployed cluster is enabled
+func isACLEnabled(instance *app.Consul) bool {
+	return instance.Spec.Security != nil && instance.Spec.Security.ACL
+}
+
+// bootstrapACL bootstraps the ACL system via the HTTP API of Consul and stores the token of the management policy
+// in a Secret. The bootstrap is allowed only once in the lifetime of the cluster, so the token is not requested again
+// if the Secret holds a token accepted by the cluster, eg. after the redeployment of the cluster. A stored token
+// rejected by the cluster, eg. when the cluster has been deployed on new Storages, is replaced by bootstrapping again.
+// Returns the management token.
+func (r *ConsulReconciler) bootstrapACL(instance *app.Consul, namespace string) (string, error) {
+	logger := log.WithName("security").WithName("bootstrapACL").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
+
+	names := namesOf(instance)
+	secret, err := r.getSecret(namespace, names.ACLTokenSecret)
+	if err != nil {
+		return "", err
+	}
+	if secret != nil {
+		token := string(secret.Data[aclTokenSecretKey])
+		valid, err := serviceAPI(namespace, names, token).isTokenValid()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to check the stored ACL bootstrap token")
+		}
+		if valid {
+			logger.Info("ACL system has been bootstrapped already")
+			instance.Status.AppReportedData.ACLBootstrapTokenSecret = names.ACLTokenSecret
+			return token, nil
+		}
+		logger.Info("The stored ACL bootstrap token is rejected by the cluster, bootstrap it again")
+	}
+
+	token, err := requestACLBootstrap(namespace, names)
+	if err != nil {
+		return "", err
+	}
+	if err := r.storeToken(instance, namespace, names.ACLTokenSecret, secret, token); err != nil {
+		//The token cannot be requested again, the ACL bootstrap has to be reset by hand, see the README
+		return "", errors.Wrap(err, "failed to store the ACL bootstrap token")
+	}
+	logger.Info("ACL system bootstrapped")
+	instance.Status.AppReportedData.ACLBootstrapTokenSecret = names.ACLTokenSecret
+	return token, nil
+}
+
+// ensureACLTokens creates the policies and the tokens used by the metrics scrape, the operator and the servers, and
+// sets the agent token on every server. The default policy of the cluster is deny, so the calls without these tokens
+// are rejected. It is executed after every deployment, the servers added by a scale up get the agent token as well.
+// The stored tokens rejected by the cluster, eg. after a new bootstrap, are created again.
+func (r *ConsulReconciler) ensureACLTokens(instance *app.Consul, namespace, managementToken string) error {
+	logger := log.WithName("security").WithName("ensureACLTokens").WithValues("namespace", namespace, "name", instance.ObjectMeta.Name)
+
+	names := namesOf(instance)
+	api := serviceAPI(namespace, names, managementToken)
+	if err := api.ensurePolicies(metricsPolicy, operatorPolicy, agentPolicy); err != nil {
+		return err
+	}
+	anonymous := map[string]interface{}{
+		"AccessorID":  anonymousTokenID,
+		"Description": "Anonymous Token",
+		"Policies":    []map[string]string{{"Name": metricsPolicy.Name}},
+	}
+	if err := api.call(http.MethodPut, "/v1/acl/token/"+anonymousTokenID, anonymous, nil); err != nil {
+		return errors.Wrap(err, "failed to attach the metrics policy to the anonymous token")
+	}
+
+	if _, err := r.ensureToken(instance, namespace, names.ACLOperatorTokenSecret, api, operatorPolicy); err != nil {
+		return err
+	}
+	agentToken, err := r.ensureToken(instance, namespace, names.ACLAgentTokenSecret, api, agentPolicy)
+	if err != nil {
+		return err
+	}
+
+	//The token is persisted by the servers in their data directory, enable_token_persistence is set in the chart
+	for ordinal := int32(0); ordinal < serverCount(instance); ordinal++ {
+		server := &consulAPI{
+			address: fmt.Sprintf("http://%v.%v.%v.svc:%v", names.podName(ordinal), names.App, namespace, consulHttpPort),
+			token:   managementToken,
+		}
+		if err := server.call(http.MethodPut, "/v1/agent/token/agent", map[string]string{"Token": agentToken}, nil); err != nil {
+			return errors.Wrapf(err, "failed to set the agent token of %v", names.podName(ordinal))
+		}
+	}
+	logger.Info("ACL tokens are set")
+	return nil
+}
+
+// ensureToken returns the token stored in the Secret if it is accepted by the cluster, otherwise a token of the
+// policy is created and stored in the Secret
+func (r *ConsulReconciler) ensureToken(instance *app.Consul, namespace, name string, api *consulAPI, policy aclPolicy) (string, error) {
+	logger := log.WithName("security").WithName("ensureToken").WithValues("namespace", namespace, "secret", name)
+
+	secret, err := r.getSecret(namespace, name)
+	if err != nil {
+		return "", err
+	}
+	if secret != nil {
+		token := string(secret.Data[aclTokenSecretKey])
+		valid, err := api.withToken(token).isTokenValid()
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to check the token of the secret %v", name)
+		}
+		if valid {
+			return token, nil
+		}
+		logger.Info("The stored token is rejected by the cluster, it is created again")
+	}
+
+	token, err := api.createToken(policy)
+	if err != nil {
+		return "", err
+	}
+	return token, r.storeToken(instance, namespace, name, secret, token)
+}
+
+// storeToken stores the token in the Secret, the existing Secret is updated, otherwise it is created
+func (r *ConsulReconciler) storeToken(instance *app.Consul, namespace, name string, existing *corev1.Secret, token string) error {
+	if existing == nil {
+		return r.ensureSecret(instance, namespace, name, corev1.SecretTypeOpaque, func() (map[string][]byte, error) {
+			return map[string][]byte{aclTokenSecretKey: []byte(token)}, nil
+		})
+	}
+	patch := client.MergeFrom(existing.DeepCopy())
+	existing.Data = map[string][]byte{aclTokenSecretKey: []byte(token)}
+	if err := r.Patch(context.TODO(), existing, patch); err != nil {
+		return errors.Wrapf(err, "failed to update the secret %v", name)
+	}
+	return nil
+}
+
+// getSecret returns the Secret or nil if it does not exist
+func (r *ConsulReconciler) getSecret(namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to get the secret %v", name)
+	}
+	return secret, nil
+}
+
+// readToken returns the ACL token stored in the Secret
+func (r *ConsulReconciler) readToken(namespace, name string) (string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		return "", errors.Wrapf(err, "failed to get the secret %v", name)
+	}
+	token := string(secret.Data[aclTokenSecretKey])
+	if token == "" {
+		return "", errors.Errorf("the secret %v contains no token", name)
+	}
+	return token, nil
+}
+
+// serverCount returns the number of the deployed Consul servers
+func serverCount(instance *app.Consul) int32 {
+	if usesLegacyStorage(instance) {
+		return 1
+	}
+	return int32(instance.Spec.ReplicaCount)
+}
+
+// consulAPI calls the HTTP API of Consul with the given ACL token
+type consulAPI struct {
+	address string
+	token   string
+}
+
+// serviceAPI returns the HTTP API of the Consul servers behind the service of the instance
+func serviceAPI(namespace string, names appNames, token string) *consulAPI {
+	return &consulAPI{address: consulServiceAddress(namespace, names.Service), token: token}
+}
+
+// withToken returns the same API called with the given token
+func (c *consulAPI) withToken(token string) *consulAPI {
+	return &consulAPI{address: c.address, token: token}
+}
+
+// statusError is the error of a call answered with an unexpected status
+type statusError struct {
+	method string
+	path   string
+	status int
+	body   []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%v %v failed with status %v: %s", e.method, e.path, e.status, e.body)
+}
+
+// hasStatus reports whether the call failed with the given status
+func hasStatus(err error, status int) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.status == status
+}
+
+// call sends the request with the JSON encoded body and decodes the JSON response into out if it is not nil
+func (c *consulAPI) call(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode the request of %v", path)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.address+path, reader)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create the request of %v", path)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	httpClient := &http.Client{Timeout: consulApiTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call %v", path)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the response of %v", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{method: method, path: path, status: resp.StatusCode, body: respBody}
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return errors.Wrapf(err, "failed to parse the response of %v", path)
+		}
+	}
+	return nil
+}
+
+// ensurePolicies creates the policies which do not exist yet, the existing ones are left as they are
+func (c *consulAPI) ensurePolicies(policies ...aclPolicy) error {
+	var existing []aclPolicy
+	if err := c.call(http.MethodGet, "/v1/acl/policies", nil, &existing); err != nil {
+		return errors.Wrap(err, "failed to list the ACL policies")
+	}
+	names := make(map[string]bool)
+	for _, policy := range existing {
+		names[policy.Name] = true
+	}
+	for _, policy := range policies {
+		if names[policy.Name] {
+			continue
+		}
+		if err := c.call(http.MethodPut, "/v1/acl/policy", policy, nil); err != nil {
+			return errors.Wrapf(err, "failed to create the ACL policy %v", policy.Name)
+		}
+	}
+	return nil
+}
+
+// isTokenValid reports whether the token of the API is accepted by the cluster. The empty token is not valid, it
+// would be resolved to the anonymous token.
+func (c *consulAPI) isTokenValid() (bool, error) {
+	if c.token == "" {
+		return false, nil
+	}
+	err := c.call(http.MethodGet, "/v1/acl/token/self", nil, nil)
+	if hasStatus(err, http.StatusForbidden) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// createToken creates a token with the policy and returns its secret
+func (c *consulAPI) createToken(policy aclPolicy) (string, error) {
+	request := map[string]interface{}{
+		"Description": policy.Description,
+		"Policies":    []map[string]string{{"Name": policy.Name}},
+	}
+	var token struct {
+		SecretID string
+	}
+	if err := c.call(http.MethodPut, "/v1/acl/token", request, &token); err != nil {
+		return "", errors.Wrapf(err, "failed to create the ACL token of the policy %v", policy.Name)
+	}
+	if token.SecretID == "" {
+		return "", errors.Errorf("the ACL token of the policy %v contains no secret", policy.Name)
+	}
+	return token.SecretID, nil
+}
+
+// requestACLBootstrap calls the ACL bootstrap endpoint of the Consul servers and returns the secret of the token
+func requestACLBootstrap(namespace string, names appNames) (string, error) {
+	var token struct {
+		SecretID string
+	}
+	if err := serviceAPI(namespace, names, "").call(http.MethodPut, "/v1/acl/bootstrap", nil, &token); err != nil {
+		if hasStatus(err, http.StatusForbidden) {
+			return "", errors.Wrapf(errACLBootstrapNotAllowed, "%v, the bootstrap token is stored in the Secret %v", err, names.ACLTokenSecret)
+		}
+		//The cluster has no leader yet right after the deployment, it is retried with backoff
+		return "", errors.Wrap(err, "ACL bootstrap failed")
+	}
+	if token.SecretID == "" {
+		return "", errors.New("ACL bootstrap response contains no token")
+	}
+	return token.SecretID, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeConsulACL serves the ACL endpoints of Consul used by the bootstrap, only the valid token is accepted
+type fakeConsulACL struct {
+	validToken       string
+	bootstrapAllowed bool
+}
+
+func (f *fakeConsulACL) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/v1/acl/token/self":
+		if token := req.Header.Get("X-Consul-Token"); token == "" || token != f.validToken {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("{}"))
+	case "/v1/acl/bootstrap":
+		if !f.bootstrapAllowed {
+			http.Error(w, "Permission denied: ACL bootstrap no longer allowed (reset index: 12)", http.StatusForbidden)
+			return
+		}
+		f.bootstrapAllowed = false
+		f.validToken = "new-token"
+		w.Write([]byte(`{"SecretID":"new-token"}`))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// withFakeConsul points the API calls of the service to the fake Consul for the duration of the test
+func withFakeConsul(t *testing.T, handler http.Handler) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	prev := consulServiceAddress
+	consulServiceAddress = func(string, string) string { return server.URL }
+	t.Cleanup(func() {
+		consulServiceAddress = prev
+		server.Close()
+	})
+}
+
+func newTokenSecret(name, token string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data:       map[string][]byte{aclTokenSecretKey: []byte(token)},
+	}
+}
+
+func TestBootstrapACL(t *testing.T) {
+	tests := []struct {
+		name             string
+		stored           *string
+		validToken       string
+		bootstrapAllowed bool
+		want             string
+		notAllowed       bool
+	}{
+		{"first bootstrap", nil, "", true, "new-token", false},
+		{"stored token valid", stringPtr("old-token"), "old-token", false, "old-token", false},
+		{"stored token rejected", stringPtr("old-token"), "", true, "new-token", false},
+		{"stored token empty", stringPtr(""), "", true, "new-token", false},
+		{"stored token rejected and bootstrapped", stringPtr("old-token"), "other-token", false, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withFakeConsul(t, &fakeConsulACL{validToken: test.validToken, bootstrapAllowed: test.bootstrapAllowed})
+			instance := newTestConsul("consul", app.PhaseBootstrappingACL)
+			objects := []client.Object{instance}
+			if test.stored != nil {
+				objects = append(objects, newTokenSecret(testNames.ACLTokenSecret, *test.stored))
+			}
+			r, _ := newTestReconciler(t, objects...)
+
+			token, err := r.bootstrapACL(instance, testNamespace)
+			if test.notAllowed {
+				if !errors.Is(err, errACLBootstrapNotAllowed) {
+					t.Fatalf("expected the bootstrap not to be allowed, got %v", err)
+				}
+				return
+			}
+			if err != nil || token != test.want {
+				t.Fatalf("expected token %q without error, got %q, %v", test.want, token, err)
+			}
+			stored, err := r.readToken(testNamespace, testNames.ACLTokenSecret)
+			if err != nil || stored != test.want {
+				t.Errorf("expected the stored token %q, got %q, %v", test.want, stored, err)
+			}
+			if instance.Status.AppReportedData.ACLBootstrapTokenSecret != testNames.ACLTokenSecret {
+				t.Errorf("expected the secret to be reported, got %q", instance.Status.AppReportedData.ACLBootstrapTokenSecret)
+			}
+		})
+	}
+}
+
+func TestHandleBootstrappingACLTokenRejected(t *testing.T) {
+	withFakeConsul(t, &fakeConsulACL{validToken: "other-token"})
+	instance := newTestConsul("consul", app.PhaseBootstrappingACL)
+	instance.Spec.Security = &app.Security{ACL: true}
+	r, recorder := newTestReconciler(t, instance, newTokenSecret(testNames.ACLTokenSecret, "old-token"))
+
+	//Retrying does not help, the instance is not requeued
+	result, err := r.handleCreate(instance, testNamespace)
+	if err != nil || result.Requeue || result.RequeueAfter != 0 {
+		t.Fatalf("expected no requeue without error, got %+v, %v", result, err)
+	}
+	stored := getConsul(t, r, instance)
+	if stored.Status.Phase != app.PhaseFailed {
+		t.Errorf("expected phase %v, got %v", app.PhaseFailed, stored.Status.Phase)
+	}
+	expectCondition(t, stored, app.ConditionDeployed, metav1.ConditionFalse, app.ReasonACLTokenRejected)
+	events := recordedEvents(recorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning "+app.ReasonACLTokenRejected) {
+		t.Errorf("expected the warning event of the rejected token, got %v", events)
+	}
+}
+
+func TestEnsureSecretOwner(t *testing.T) {
+	for _, ownerReferences := range []bool{true, false} {
+		instance := newTestConsul("consul", app.PhaseDeploying)
+		instance.Spec.Security = &app.Security{GossipEncryption: true}
+		r, _ := newTestReconciler(t, instance)
+		r.OwnerReferences = ownerReferences
+
+		if err := r.ensureSecuritySecrets(instance, testNamespace); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		secret, err := r.getSecret(testNamespace, testNames.GossipKeySecret)
+		if err != nil || secret == nil {
+			t.Fatalf("expected the secret, got %v, %v", secret, err)
+		}
+		if owner := metav1.GetControllerOf(secret); (owner != nil) != ownerReferences {
+			t.Errorf("expected the owner to be set %v, got %+v", ownerReferences, owner)
+		}
+	}
+}
+
+func TestDeleteSecuritySecrets(t *testing.T) {
+	instance := newTestConsul("consul", app.PhaseRunning)
+	r, _ := newTestReconciler(t, instance, newTokenSecret(testNames.ACLTokenSecret, "token"), newTokenSecret(testNames.ACLAgentTokenSecret, "token"))
+
+	//The missing Secrets are skipped
+	if err := r.deleteSecuritySecrets(testNamespace, testNames); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range testNames.secrets() {
+		if secret, err := r.getSecret(testNamespace, name); err != nil || secret != nil {
+			t.Errorf("expected the secret %v to be deleted, got %v, %v", name, secret, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	//Interval of the orphan sweeps of a running instance
+	sweepInterval = 10 * time.Minute
+	//Interval of retrying a failed sweep
+	sweepRetryInterval = time.Minute
+)
+
+var (
+	lastSweepsMu sync.Mutex
+	lastSweeps   = make(map[types.NamespacedName]time.Time)
+)
+
+// ownerReference returns the owner reference set on the applied resources, nil if they are not owned by the instance
+func (r *ConsulReconciler) ownerReference(instance *app.Consul) *metav1.OwnerReference {
+	if !r.OwnerReferences {
+		return nil
+	}
+	return metav1.NewControllerRef(instance, app.GroupVersion.WithKind("Consul"))
+}
+
+// sweepOrphansIfDue sweeps the orphans of the instance if the sweep interval has elapsed since its last sweep. The
+// time of the sweeps is kept in memory, so the instances are swept after the restart of the operator. It returns
+// the time until the next sweep.
+func (r *ConsulReconciler) sweepOrphansIfDue(logger logr.Logger, instance *app.Consul, namespace string) time.Duration {
+	key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+
+	lastSweepsMu.Lock()
+	last, found := lastSweeps[key]
+	lastSweepsMu.Unlock()
+	if found {
+		if remaining := sweepInterval - time.Since(last); remaining > 0 {
+			return remaining
+		}
+	}
+
+	//The sweep is housekeeping, its failure does not block the reconciliation of the running instance
+	if err := r.sweepOrphans(logger, instance, namespace); err != nil {
+		logger.Error(err, "orphan sweep failed")
+		return sweepRetryInterval
+	}
+
+	lastSweepsMu.Lock()
+	lastSweeps[key] = time.Now()
+	lastSweepsMu.Unlock()
+	return sweepInterval
+}
+
+// forgetSweeps drops the sweep time of the deleted instance
+func forgetSweeps(key types.NamespacedName) {
+	lastSweepsMu.Lock()
+	delete(lastSweeps, key)
+	lastSweepsMu.Unlock()
+}
+
+// sweepOrphans deletes the platform resources which have been applied for the instance but are not templated from its
+// current spec any more, eg. left behind by an interrupted update. With owner references the resources owned by the
+// instance are swept as well, even if they are missing from the applied resources of the status. The orphaned Storage
+// requests are only reported, deleting them would delete the data of the application, eg. after an upgrade of the
+// operator changing the templates. The ones in the applied resources are deleted with the instance, the ones only
+// owned by the instance are garbage collected with it through their owner reference.
+func (r *ConsulReconciler) sweepOrphans(logger logr.Logger, instance *app.Consul, namespace string) error {
+	//The generated files do not survive the restart of the operator
+	requestDir, err := renderTemplates(instance, namespace, resourceReqsDir)
+	if err != nil {
+		return err
+	}
+	dynClient := newDynClient()
+	rendered, err := platformres.DescribePlatformResourceRequests(dynClient, requestDir, namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to describe the platform resource requests")
+	}
+
+	applied := instance.Status.AppliedResources
+	candidates := append([]k8sdynamic.ResourceDescriptor{}, applied...)
+	if r.OwnerReferences {
+		owned, err := platformres.ListOwnedPlatformResources(dynClient, namespace, instance.GetUID())
+		if err != nil {
+			return err
+		}
+		//The owned resources which are not in the status yet
+		candidates = append(candidates, staleResources(owned, applied)...)
+	}
+
+	var orphans []k8sdynamic.ResourceDescriptor
+	for _, orphan := range staleResources(candidates, rendered) {
+		if platformres.IsStorage(orphan) {
+			logger.Info("Orphaned Storage is kept until the instance is deleted", "resource", orphan)
+			continue
+		}
+		orphans = append(orphans, orphan)
+	}
+	if len(orphans) == 0 {
+		logger.V(1).Info("No orphaned platform resources to delete")
+		return nil
+	}
+
+	logger.Info("Delete the orphaned platform resources", "resources", orphans)
+	if err := dynClient.DeleteResources(orphans); err != nil {
+		return errors.Wrap(err, "failed to delete the orphaned platform resources")
+	}
+	instance.Status.AppliedResources = staleResources(applied, orphans)
+	return r.updateStatus(instance)
+}
+
+// requeueFirst returns the result requeued at the earlier of its own requeue time and the given one
+func requeueFirst(result reconcile.Result, after time.Duration) reconcile.Result {
+	if result.RequeueAfter == 0 || after < result.RequeueAfter {
+		result.RequeueAfter = after
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/platformres"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func platformDescriptor(kind, name string) k8sdynamic.ResourceDescriptor {
+	gvr, _ := meta.UnsafeGuessKindToResource(schema.GroupVersionKind{Group: platformres.Group, Version: platformres.Version, Kind: kind})
+	return k8sdynamic.ResourceDescriptor{
+		Name:      name,
+		Namespace: testNamespace,
+		Gvr:       k8sdynamic.GroupVersionResource{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource},
+	}
+}
+
+// ownedBy sets the instance as the controller of the platform resource
+func ownedBy(resource *unstructured.Unstructured, instance *app.Consul) *unstructured.Unstructured {
+	resource.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(instance, app.GroupVersion.WithKind("Consul"))})
+	return resource
+}
+
+func TestSweepOrphans(t *testing.T) {
+	withDeploymentDir(t)
+	//Deployed with the legacy release, the resources have the fixed names
+	instance := newTestConsul("consul", app.PhaseRunning)
+	instance.UID = types.UID("consul-uid")
+	instance.Status.PrevSpec = instance.Spec.DeepCopy()
+
+	//Rendered from the spec of a single server
+	rendered := []k8sdynamic.ResourceDescriptor{
+		platformDescriptor("Resourcerequest", "resource-for-consul"),
+		platformDescriptor("MetricsEndpoint", "consul-metricsendpoint"),
+		platformDescriptor("Storage", "example-consul-data-example-consul-0"),
+	}
+	//Left behind by an interrupted update
+	instance.Status.AppliedResources = append(rendered,
+		platformDescriptor("Storage", "example-consul-data-example-consul-1"),
+		platformDescriptor("PrivateNetworkAccess", "private-network-for-consul"))
+	dynClient := withDynClient(t,
+		newTestPlatformResource("Resourcerequest", "resource-for-consul"),
+		newTestPlatformResource("MetricsEndpoint", "consul-metricsendpoint"),
+		newTestPlatformResource("Storage", "example-consul-data-example-consul-0"),
+		newTestPlatformResource("Storage", "example-consul-data-example-consul-1"),
+		newTestPlatformResource("PrivateNetworkAccess", "private-network-for-consul"),
+		//Owned only, eg. applied before the update of the status was interrupted
+		ownedBy(newTestPlatformResource("Storage", "example-consul-data-example-consul-2"), instance),
+		ownedBy(newTestPlatformResource("Resourcerequest", "resource-for-consul-old"), instance),
+		//Neither applied nor owned by the instance
+		newTestPlatformResource("Resourcerequest", "resource-of-other-app"),
+	)
+	r, _ := newTestReconciler(t, instance)
+	r.OwnerReferences = true
+
+	if err := r.sweepOrphans(log, instance, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exists := func(resource k8sdynamic.ResourceDescriptor) bool {
+		_, err := dynClient.Resource(resource.Gvr.GetGvr()).Namespace(testNamespace).Get(context.TODO(), resource.Name, metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return err == nil
+	}
+	tests := []struct {
+		resource k8sdynamic.ResourceDescriptor
+		kept     bool
+	}{
+		{rendered[0], true},
+		{rendered[2], true},
+		//The data of the application is never swept
+		{platformDescriptor("Storage", "example-consul-data-example-consul-1"), true},
+		{platformDescriptor("Storage", "example-consul-data-example-consul-2"), true},
+		{platformDescriptor("PrivateNetworkAccess", "private-network-for-consul"), false},
+		{platformDescriptor("Resourcerequest", "resource-for-consul-old"), false},
+		{platformDescriptor("Resourcerequest", "resource-of-other-app"), true},
+	}
+	for _, test := range tests {
+		if kept := exists(test.resource); kept != test.kept {
+			t.Errorf("expected %v to be kept %v, got %v", test.resource.Name, test.kept, kept)
+		}
+	}
+
+	stored := getConsul(t, r, instance)
+	expected := append(rendered, platformDescriptor("Storage", "example-consul-data-example-consul-1"))
+	if stale := staleResources(stored.Status.AppliedResources, expected); len(stale) != 0 || len(stored.Status.AppliedResources) != len(expected) {
+		t.Errorf("expected the applied resources %v, got %v", expected, stored.Status.AppliedResources)
+	}
+}
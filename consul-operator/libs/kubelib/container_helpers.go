@@ -36,3 +36,16 @@ func AddConfigEnvVar(c *k8v1.Container, envName string, selector string, key str
 	c.Env = append(c.Env, e)
 
 }
+
+func AddSecretEnvVar(c *k8v1.Container, envName string, secret string, key string, optional bool) {
+	e := k8v1.EnvVar{}
+	e.Name = envName
+	e.ValueFrom = &k8v1.EnvVarSource{}
+
+	e.ValueFrom.SecretKeyRef = &k8v1.SecretKeySelector{}
+	e.ValueFrom.SecretKeyRef.Name = secret
+	e.ValueFrom.SecretKeyRef.Key = key
+	e.ValueFrom.SecretKeyRef.Optional = &optional
+	c.Env = append(c.Env, e)
+
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package kubelib
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	k8v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+//ExecInPod executes the command in the container of the pod and returns its standard output.
+//The container must have the RBAC role to create pods/exec
+func ExecInPod(namespace string, pod string, container string, command []string) (string, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the in-cluster config")
+	}
+
+	req := GetKubeAPI().CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&k8v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create the executor")
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.Stream(remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		return stdout.String(), errors.Wrapf(err, "command failed in pod %v, stderr: %v", pod, stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -37,3 +37,12 @@ func AddPodConfigVolume(p *k8v1.PodSpec, volName string, configMap string) {
 func AddContainer(p *k8v1.PodSpec, c *k8v1.Container) {
 	p.Containers = append(p.Containers, *c)
 }
+
+func AddPodClaimVolume(p *k8v1.PodSpec, volName string, claimName string) {
+	v := k8v1.Volume{}
+	v.Name = volName
+	v.PersistentVolumeClaim = &k8v1.PersistentVolumeClaimVolumeSource{}
+	v.PersistentVolumeClaim.ClaimName = claimName
+	p.Volumes = append(p.Volumes, v)
+
+}
@@ -22,8 +22,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/nokia/industrial-application-framework/alarmlogger"
 	appdacnokiacomv1alpha1 "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
 	"github.com/nokia/industrial-application-framework/consul-operator/controllers"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/alarms"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -56,6 +58,16 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	alarmLogger, err := alarms.NewLogger(func(err error) {
+		ctrl.Log.WithName("alarmlogger").Error(err, "alarm logger failure")
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create the alarm logger")
+		os.Exit(1)
+	}
+	alarmlogger.SetDefault(alarmLogger)
+	defer alarmLogger.Close()
+
 	watchNamespace, err := getWatchNamespace()
 	if err != nil {
 		setupLog.Error(err, "unable to get WatchNamespace, "+
@@ -85,12 +97,20 @@ func main() {
 	}
 
 	if err = (&controllers.ConsulReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		Recorder:        mgr.GetEventRecorderFor("consul-operator"),
+		OwnerReferences: os.Getenv("ENABLE_OWNER_REFERENCES") == "true",
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Consul")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		if err = (&appdacnokiacomv1alpha1.Consul{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Consul")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
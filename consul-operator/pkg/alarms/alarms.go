@@ -0,0 +1,50 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarms
+
+import (
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	"github.com/pkg/errors"
+)
+
+// Names of the alarms raised by the operator for the Consul instances
+const (
+	AppNotRunning  = "AppNotRunning"
+	LicenceExpired = "LicenceExpired"
+)
+
+// Definitions is the catalogue of the alarms raised by the operator, the ID and the severity of the alarms are
+// completed from it
+var Definitions = []alarmlogger.AlarmDefinition{
+	{
+		Name:        AppNotRunning,
+		ID:          "1",
+		Severity:    alarmlogger.Warning,
+		Description: "Not all components of the Consul instance are ready",
+	},
+	{
+		Name:        LicenceExpired,
+		ID:          "2",
+		Severity:    alarmlogger.Warning,
+		Description: "The licence of the application is invalid",
+	},
+}
+
+// NewLogger returns the alarm logger of the operator with the further options, it rejects the alarms missing from the
+// catalogue. The problems of the alarm logger are passed to onError, they are not written to the alarm log.
+func NewLogger(onError func(error), opts ...alarmlogger.Option) (*alarmlogger.AlarmLogger, error) {
+	catalogue := alarmlogger.NewCatalogue()
+	if err := catalogue.Register(Definitions...); err != nil {
+		return nil, errors.Wrap(err, "failed to register the alarm catalogue")
+	}
+	logger, err := alarmlogger.New(append([]alarmlogger.Option{
+		alarmlogger.WithCatalogue(catalogue, alarmlogger.RejectOnMismatch),
+		alarmlogger.WithErrorHandler(onError),
+	}, opts...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the alarm logger")
+	}
+	return logger, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package alarms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var reported []error
+	logger, err := NewLogger(func(err error) {
+		reported = append(reported, err)
+	}, alarmlogger.WithWriter(buf))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{AppNotRunning, "1"},
+		{LicenceExpired, "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			if err := logger.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: tt.name, Text: "text", SubDN: "/NS-app/CONSUL-consul"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var entry struct {
+				Alarm alarmlogger.AlarmDetails `json:"alarm"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("unexpected alarm log %q: %v", buf.String(), err)
+			}
+			if entry.Alarm.ID != tt.id || entry.Alarm.Severity != alarmlogger.Warning {
+				t.Errorf("expected the id %v and the severity %v from the catalogue, got %+v", tt.id, alarmlogger.Warning, entry.Alarm)
+			}
+		})
+	}
+
+	//The alarms missing from the catalogue are rejected
+	buf.Reset()
+	if err := logger.Raise(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{Name: "Unknown", ID: "3"}); !errors.Is(err, alarmlogger.ErrUnknownAlarm) {
+		t.Errorf("expected ErrUnknownAlarm, got %v", err)
+	}
+	if buf.Len() != 0 || len(reported) != 0 {
+		t.Errorf("expected nothing written and reported, got %q and %v", buf.String(), reported)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 5 * 365 * 24 * time.Hour
+
+	//Length of the AES-256 key used by the gossip protocol
+	gossipKeyLength = 32
+)
+
+// KeyPair is a PEM encoded certificate and its private key
+type KeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+// GenerateGossipKey returns a random base64 encoded key for the gossip encryption of Consul
+func GenerateGossipKey() (string, error) {
+	key := make([]byte, gossipKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "failed to generate the gossip key")
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// GenerateCA returns a self-signed CA certificate
+func GenerateCA(commonName string) (*KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return generate(template, caValidity, nil)
+}
+
+// GenerateServerCert returns a certificate signed by the CA which is valid both as a server and as a client
+// certificate, as the Consul servers use the same certificate for the incoming and the outgoing connections
+func GenerateServerCert(ca *KeyPair, commonName string, dnsNames []string, ips []net.IP) (*KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+	}
+	return generate(template, serverValidity, ca)
+}
+
+// generate creates a new key and certificate from the template, the certificate is self-signed if the CA is nil
+func generate(template *x509.Certificate, validity time.Duration, ca *KeyPair) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate the private key")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate the serial number")
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(validity)
+
+	parent := template
+	var signer interface{} = key
+	if ca != nil {
+		parent, signer, err = parseKeyPair(ca)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the certificate of %v", template.Subject.CommonName)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the private key")
+	}
+
+	return &KeyPair{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}, nil
+}
+
+func parseKeyPair(pair *KeyPair) (*x509.Certificate, interface{}, error) {
+	certBlock, _ := pem.Decode(pair.Cert)
+	if certBlock == nil {
+		return nil, nil, errors.New("the CA certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse the CA certificate")
+	}
+	keyBlock, _ := pem.Decode(pair.Key)
+	if keyBlock == nil {
+		return nil, nil, errors.New("the CA key is not PEM encoded")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse the CA key")
+	}
+	return cert, key, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateGossipKey(t *testing.T) {
+	key, err := GenerateGossipKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatalf("expected a base64 encoded key, got %v", err)
+	}
+	if len(decoded) != gossipKeyLength {
+		t.Errorf("expected a key of %v bytes, got %v", gossipKeyLength, len(decoded))
+	}
+
+	other, err := GenerateGossipKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == key {
+		t.Error("expected different keys")
+	}
+}
+
+func TestGenerateServerCert(t *testing.T) {
+	ca, err := GenerateCA("Consul CA")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dnsNames := []string{"server.dc1.consul", "consul-0.consul-server.app.svc", "localhost"}
+	ips := []net.IP{net.ParseIP("127.0.0.1").To4(), net.ParseIP("10.0.0.1").To4()}
+	server, err := GenerateServerCert(ca, "server.dc1.consul", dnsNames, ips)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	caCert, _, err := parseKeyPair(ca)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !caCert.IsCA {
+		t.Error("expected a CA certificate")
+	}
+	cert, _, err := parseKeyPair(server)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		if _, err := cert.Verify(x509.VerifyOptions{
+			DNSName:   "server.dc1.consul",
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{usage},
+		}); err != nil {
+			t.Errorf("expected the certificate to be verified for %v, got %v", usage, err)
+		}
+	}
+	if !reflect.DeepEqual(cert.DNSNames, dnsNames) {
+		t.Errorf("expected the DNS names %v, got %v", dnsNames, cert.DNSNames)
+	}
+	if len(cert.IPAddresses) != len(ips) {
+		t.Fatalf("expected the IPs %v, got %v", ips, cert.IPAddresses)
+	}
+	for i, ip := range ips {
+		if !cert.IPAddresses[i].Equal(ip) {
+			t.Errorf("expected the IPs %v, got %v", ips, cert.IPAddresses)
+		}
+	}
+
+	//The key matches the certificate
+	if _, err := tls.X509KeyPair(server.Cert, server.Key); err != nil {
+		t.Errorf("expected a matching key pair, got %v", err)
+	}
+
+	//A certificate of another CA is rejected
+	other, err := GenerateCA("Other CA")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	otherCert, _, err := parseKeyPair(other)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: otherRoots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err == nil {
+		t.Error("expected the verification by another CA to fail")
+	}
+}
+
+func TestParseKeyPairInvalid(t *testing.T) {
+	ca, err := GenerateCA("Consul CA")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name string
+		pair *KeyPair
+	}{
+		{name: "certificate not PEM", pair: &KeyPair{Cert: []byte("cert"), Key: ca.Key}},
+		{name: "key not PEM", pair: &KeyPair{Cert: ca.Cert, Key: []byte("key")}},
+		{name: "key of certificate", pair: &KeyPair{Cert: ca.Cert, Key: ca.Cert}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := GenerateServerCert(tt.pair, "server", nil, nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -8,32 +8,47 @@ import (
 	"context"
 	"os"
 	"os/exec"
+	"regexp"
 	"time"
 
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/template"
 	"github.com/pkg/errors"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type Helm struct {
-	namespace string
-	WorkDir   string
+	namespace   string
+	releaseName string
+	WorkDir     string
 }
 
 const (
-	ReleaseName   = "app-release"
-	FlagNamespace = "--namespace"
+	// LegacyReleaseName is the release of the instances deployed before the release name was derived from the CR
+	LegacyReleaseName = "app-release"
+	FlagNamespace     = "--namespace"
+	FlagFilter        = "--filter"
 )
 
 var log = logf.Log.WithName("helm_controller")
 
-func NewHelm(namespace string) *Helm {
+func NewHelm(namespace string, releaseName string) *Helm {
 	return &Helm{
-		namespace: namespace,
-		WorkDir:   os.Getenv("DEPLOYMENT_DIR") + "/app-deployment-generated",
+		namespace:   namespace,
+		releaseName: releaseName,
+		WorkDir:     template.WorkDir(os.Getenv(template.DeploymentDir), "app-deployment", namespace, releaseName),
 	}
 }
 
+// execCommand executes the helm command, its duration and failure are recorded in the metrics
 func (h *Helm) execCommand(args ...string) (string, error) {
+	start := time.Now()
+	out, err := h.runCommand(args...)
+	metrics.ObserveHelmCommand(args[0], time.Since(start), err)
+	return out, err
+}
+
+func (h *Helm) runCommand(args ...string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -55,37 +70,53 @@ func (h *Helm) execCommand(args ...string) (string, error) {
 }
 
 func (h *Helm) getRelease() (string, error) {
-	out, err := h.execCommand("list", "-q", FlagNamespace, h.namespace)
+	out, err := h.execCommand("list", "-q", FlagNamespace, h.namespace, FlagFilter, "^"+regexp.QuoteMeta(h.releaseName)+"$")
 
 	return string(out), err
 }
 
 func (h *Helm) install() error {
-	_, err := h.execCommand("install", ReleaseName, FlagNamespace, h.namespace, ".")
+	_, err := h.execCommand("install", h.releaseName, FlagNamespace, h.namespace, ".")
 
 	return err
 }
 
 func (h *Helm) upgrade() error {
-	_, err := h.execCommand("upgrade", ReleaseName, FlagNamespace, h.namespace, ".")
+	_, err := h.execCommand("upgrade", h.releaseName, FlagNamespace, h.namespace, ".")
 
 	return err
 }
 
-func (h *Helm) Deploy() error {
+// Actions executed by Deploy
+const (
+	ActionInstall = "install"
+	ActionUpgrade = "upgrade"
+)
+
+// Deploy installs the release or upgrades it if it is installed already, it returns the executed action
+func (h *Helm) Deploy() (string, error) {
 	if release, err := h.getRelease(); err == nil {
 		if release == "" {
-			return h.install()
+			return ActionInstall, h.install()
 		} else {
-			return h.upgrade()
+			return ActionUpgrade, h.upgrade()
 		}
 	} else {
-		return err
+		return "", err
 	}
 }
 
-func (h *Helm) Undeploy() error {
-	_, err := h.execCommand("uninstall", ReleaseName, FlagNamespace, h.namespace)
+// Undeploy uninstalls the release, it reports whether the release was installed
+func (h *Helm) Undeploy() (bool, error) {
+	release, err := h.getRelease()
+	if err != nil {
+		return false, err
+	}
+	if release == "" {
+		log.Info("release is not installed", "release", h.releaseName)
+		return false, nil
+	}
+	_, err = h.execCommand("uninstall", h.releaseName, FlagNamespace, h.namespace)
 
-	return err
+	return true, err
 }
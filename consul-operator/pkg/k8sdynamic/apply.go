@@ -13,6 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -29,7 +30,7 @@ func (k K8sDynClient) ApplyConcatenatedResources(resourcesStr string, namespace
 		yamlRes = removeCommentedParts(yamlRes)
 		yamlRes = strings.Trim(yamlRes, "\n")
 
-		if yamlRes == "" {
+		if strings.TrimSpace(yamlRes) == "" {
 			continue
 		}
 		log.V(1).Info("Resource to apply", "content", yamlRes)
@@ -71,28 +72,33 @@ func (k *K8sDynClient) applyResource(object *unstructured.Unstructured, namespac
 	logger := log.WithName("applyResource")
 	gvk := object.GroupVersionKind()
 
-	apiResource, err := k.getAPIResourceByGvk(gvk)
+	resourceDescriptor, apiResource, err := k.describeResource(object, namespace)
 	if err != nil {
-		return ResourceDescriptor{}, errors.Wrap(err, "failed to find the resource by gvk")
+		return ResourceDescriptor{}, err
 	}
-
-	gvr := GroupVersionResource{Version: gvk.Version, Group: gvk.Group, Resource: apiResource.Name}
+	gvr := resourceDescriptor.Gvr
 	logger.Info("GVR of the app specific CR", "value", gvr)
 
-	resourceDescriptor := ResourceDescriptor{
-		Name: object.GetName(),
-		Gvr:  gvr,
-	}
-
 	var k8sResource dynamic.ResourceInterface
 	if apiResource.Namespaced {
 		k8sResource = k.dynClient.Resource(gvr.GetGvr()).Namespace(namespace)
-		resourceDescriptor.Namespace = namespace
 	} else {
 		k8sResource = k.dynClient.Resource(gvr.GetGvr())
 	}
 
 	actVer, err := k8sResource.Get(context.TODO(), object.GetName(), metav1.GetOptions{})
+	//An owner in another namespace or a namespaced owner of a cluster scoped resource is not allowed
+	if k.owner != nil && apiResource.Namespaced {
+		ownerRefs := object.GetOwnerReferences()
+		if err == nil {
+			ownerRefs = actVer.GetOwnerReferences()
+		}
+		if k.ownerExempt[object.GetName()] {
+			object.SetOwnerReferences(withoutOwner(ownerRefs, k.owner.UID))
+		} else {
+			object.SetOwnerReferences(withOwner(ownerRefs, *k.owner))
+		}
+	}
 	if err != nil {
 		logger.Info("resource doesn't exist, create it")
 		_, err = k8sResource.Create(context.TODO(), object, metav1.CreateOptions{})
@@ -117,6 +123,99 @@ func (k *K8sDynClient) applyResource(object *unstructured.Unstructured, namespac
 	return resourceDescriptor, nil
 }
 
+// describeResource returns the descriptor of the object and its API resource
+func (k *K8sDynClient) describeResource(object *unstructured.Unstructured, namespace string) (ResourceDescriptor, metav1.APIResource, error) {
+	gvk := object.GroupVersionKind()
+	apiResource, err := k.getAPIResourceByGvk(gvk)
+	if err != nil {
+		return ResourceDescriptor{}, metav1.APIResource{}, errors.Wrap(err, "failed to find the resource by gvk")
+	}
+
+	resourceDescriptor := ResourceDescriptor{
+		Name: object.GetName(),
+		Gvr:  GroupVersionResource{Version: gvk.Version, Group: gvk.Group, Resource: apiResource.Name},
+	}
+	if apiResource.Namespaced {
+		resourceDescriptor.Namespace = namespace
+	}
+	return resourceDescriptor, apiResource, nil
+}
+
+// DescribeConcatenatedResources returns the descriptors of the resources without applying them
+func (k K8sDynClient) DescribeConcatenatedResources(resourcesStr string, namespace string) ([]ResourceDescriptor, error) {
+	var resourceDescriptors []ResourceDescriptor
+	for _, yamlRes := range splitToIndividualResources(resourcesStr) {
+		yamlRes = strings.Trim(removeCommentedParts(yamlRes), "\n")
+		if strings.TrimSpace(yamlRes) == "" {
+			continue
+		}
+		object, err := yamlToUnstructured(yamlRes)
+		if err != nil {
+			return nil, err
+		}
+		resourceDescriptor, _, err := k.describeResource(&object, namespace)
+		if err != nil {
+			return nil, err
+		}
+		resourceDescriptors = append(resourceDescriptors, resourceDescriptor)
+	}
+	return resourceDescriptors, nil
+}
+
+// ListOwnedResources returns the descriptors of the resources of the kind in the namespace which are owned by the
+// given owner. ErrResourceNotFound is returned if the kind is not served by the API server.
+func (k K8sDynClient) ListOwnedResources(gvk schema.GroupVersionKind, namespace string, owner types.UID) ([]ResourceDescriptor, error) {
+	apiResource, err := k.getAPIResourceByGvk(gvk)
+	if err != nil {
+		return nil, err
+	}
+	gvr := GroupVersionResource{Version: gvk.Version, Group: gvk.Group, Resource: apiResource.Name}
+	list, err := k.dynClient.Resource(gvr.GetGvr()).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %v", gvr.Resource)
+	}
+
+	var resourceDescriptors []ResourceDescriptor
+	for _, item := range list.Items {
+		for _, ref := range item.GetOwnerReferences() {
+			if ref.UID == owner {
+				resourceDescriptors = append(resourceDescriptors, ResourceDescriptor{Name: item.GetName(), Namespace: namespace, Gvr: gvr})
+				break
+			}
+		}
+	}
+	return resourceDescriptors, nil
+}
+
+// withOwner adds the owner to the owner references. It is added as a plain owner if the object has another controller.
+func withOwner(ownerRefs []metav1.OwnerReference, owner metav1.OwnerReference) []metav1.OwnerReference {
+	var result []metav1.OwnerReference
+	for _, ref := range ownerRefs {
+		if ref.UID == owner.UID {
+			continue
+		}
+		if ref.Controller != nil && *ref.Controller {
+			owner.Controller = nil
+		}
+		result = append(result, ref)
+	}
+	return append(result, owner)
+}
+
+// withoutOwner removes the owner from the owner references, eg. set before the resource has been exempted
+func withoutOwner(ownerRefs []metav1.OwnerReference, owner types.UID) []metav1.OwnerReference {
+	var result []metav1.OwnerReference
+	for _, ref := range ownerRefs {
+		if ref.UID != owner {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// ErrResourceNotFound is returned if the API server does not serve the kind
+var ErrResourceNotFound = errors.New("not found")
+
 func (k K8sDynClient) getAPIResourceByGvk(gvk schema.GroupVersionKind) (metav1.APIResource, error) {
 	if gvk.Version == "" || gvk.Kind == "" {
 		return metav1.APIResource{}, errors.New("empty input parameters")
@@ -130,6 +229,9 @@ func (k K8sDynClient) getAPIResourceByGvk(gvk schema.GroupVersionKind) (metav1.A
 	}
 
 	resList, err := k.generalClient.Discovery().ServerResourcesForGroupVersion(groupVersion)
+	if k8serrors.IsNotFound(err) {
+		return metav1.APIResource{}, errors.Wrap(ErrResourceNotFound, groupVersion)
+	}
 	if err != nil {
 		return metav1.APIResource{}, err
 	}
@@ -140,5 +242,5 @@ func (k K8sDynClient) getAPIResourceByGvk(gvk schema.GroupVersionKind) (metav1.A
 		}
 	}
 
-	return metav1.APIResource{}, errors.New("not found")
+	return metav1.APIResource{}, errors.Wrap(ErrResourceNotFound, gvk.Kind)
 }
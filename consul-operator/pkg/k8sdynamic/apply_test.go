@@ -0,0 +1,145 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package k8sdynamic
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "app"
+
+var storageGvr = schema.GroupVersionResource{Group: "ops.dac.nokia.com", Version: "v1alpha1", Resource: "storages"}
+
+func ownerRef(uid string, controller bool) metav1.OwnerReference {
+	ref := metav1.OwnerReference{APIVersion: "app.dac.nokia.com/v1alpha1", Kind: "Consul", Name: uid, UID: types.UID(uid)}
+	if controller {
+		ref.Controller = &controller
+	}
+	return ref
+}
+
+func TestWithOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		ownerRefs []metav1.OwnerReference
+		want      []metav1.OwnerReference
+	}{
+		{"no owner", nil, []metav1.OwnerReference{ownerRef("consul", true)}},
+		{"plain owner", []metav1.OwnerReference{ownerRef("other", false)},
+			[]metav1.OwnerReference{ownerRef("other", false), ownerRef("consul", true)}},
+		//Only one controller is allowed, the owner is added as a plain owner
+		{"existing controller", []metav1.OwnerReference{ownerRef("other", true)},
+			[]metav1.OwnerReference{ownerRef("other", true), ownerRef("consul", false)}},
+		//Set by an earlier apply, it is replaced rather than duplicated
+		{"duplicate UID", []metav1.OwnerReference{ownerRef("consul", false), ownerRef("other", false)},
+			[]metav1.OwnerReference{ownerRef("other", false), ownerRef("consul", true)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := withOwner(test.ownerRefs, ownerRef("consul", true)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestWithoutOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		ownerRefs []metav1.OwnerReference
+		want      []metav1.OwnerReference
+	}{
+		{"no owner", nil, nil},
+		{"owned", []metav1.OwnerReference{ownerRef("consul", true)}, nil},
+		{"owned with other owners", []metav1.OwnerReference{ownerRef("other", true), ownerRef("consul", false)},
+			[]metav1.OwnerReference{ownerRef("other", true)}},
+		{"not owned", []metav1.OwnerReference{ownerRef("other", false)}, []metav1.OwnerReference{ownerRef("other", false)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := withoutOwner(test.ownerRefs, types.UID("consul")); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+// newTestClient returns a client of fakes serving the Storage kind with the given objects
+func newTestClient(objects ...runtime.Object) K8sDynClient {
+	genClient := k8sfake.NewSimpleClientset()
+	genClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+		GroupVersion: storageGvr.GroupVersion().String(),
+		APIResources: []metav1.APIResource{{Name: storageGvr.Resource, Kind: "Storage", Namespaced: true}},
+	}}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{storageGvr: "StorageList"}, objects...)
+	return NewWithClients(dynClient, genClient)
+}
+
+func newStorage(name string, ownerRefs ...metav1.OwnerReference) *unstructured.Unstructured {
+	storage := &unstructured.Unstructured{}
+	storage.SetAPIVersion(storageGvr.GroupVersion().String())
+	storage.SetKind("Storage")
+	storage.SetName(name)
+	storage.SetNamespace(testNamespace)
+	storage.SetOwnerReferences(ownerRefs)
+	return storage
+}
+
+func TestApplyResourceOwner(t *testing.T) {
+	//The exempt resource has been owned before it has been exempted
+	k := newTestClient(newStorage("backup", ownerRef("consul", true), ownerRef("other", false))).
+		WithOwner(ownerRef("consul", true), "backup")
+
+	resources := `
+apiVersion: ops.dac.nokia.com/v1alpha1
+kind: Storage
+metadata:
+  name: data
+---
+apiVersion: ops.dac.nokia.com/v1alpha1
+kind: Storage
+metadata:
+  name: backup
+`
+	if _, err := k.ApplyConcatenatedResources(resources, testNamespace); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string][]metav1.OwnerReference{
+		"data":   {ownerRef("consul", true)},
+		"backup": {ownerRef("other", false)},
+	}
+	for name, want := range expected {
+		object, err := k.dynClient.Resource(storageGvr).Namespace(testNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := object.GetOwnerReferences(); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the owners %+v of %v, got %+v", want, name, got)
+		}
+	}
+
+	owned, err := k.ListOwnedResources(storageGvr.GroupVersion().WithKind("Storage"), testNamespace, types.UID("consul"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ResourceDescriptor{{Name: "data", Namespace: testNamespace, Gvr: GroupVersionResource{
+		Group: storageGvr.Group, Version: storageGvr.Version, Resource: storageGvr.Resource}}}
+	if !reflect.DeepEqual(owned, want) {
+		t.Errorf("expected the owned resources %+v, got %+v", want, owned)
+	}
+}
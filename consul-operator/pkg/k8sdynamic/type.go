@@ -5,6 +5,7 @@
 package k8sdynamic
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -13,7 +14,9 @@ import (
 
 type K8sDynClient struct {
 	dynClient     dynamic.Interface
-	generalClient *kubernetes.Clientset
+	generalClient kubernetes.Interface
+	owner         *metav1.OwnerReference
+	ownerExempt   map[string]bool
 }
 
 type GroupVersionResource struct {
@@ -38,9 +41,25 @@ type ResourceDescriptor struct {
 
 var log = logf.Log.WithName("k8sdynamic")
 
-func New(genClient *kubernetes.Clientset) K8sDynClient {
+func New(genClient kubernetes.Interface) K8sDynClient {
+	return NewWithClients(GetDynamicK8sClient(), genClient)
+}
+
+// NewWithClients returns a client working through the given clients instead of the in-cluster ones
+func NewWithClients(dynClient dynamic.Interface, genClient kubernetes.Interface) K8sDynClient {
 	return K8sDynClient{
-		dynClient:     GetDynamicK8sClient(),
+		dynClient:     dynClient,
 		generalClient: genClient,
 	}
 }
+
+// WithOwner returns a client which sets the owner on the namespaced resources it applies, so they are garbage
+// collected with the owner. The resources with the exempt names outlive the owner, the owner is removed from them.
+func (k K8sDynClient) WithOwner(owner metav1.OwnerReference, exempt ...string) K8sDynClient {
+	k.owner = &owner
+	k.ownerExempt = map[string]bool{}
+	for _, name := range exempt {
+		k.ownerExempt[name] = true
+	}
+	return k
+}
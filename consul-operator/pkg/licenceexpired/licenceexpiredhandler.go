@@ -6,18 +6,27 @@ package licenceexpired
 
 import (
 	"context"
+	"sync"
 
 	"github.com/nokia/industrial-application-framework/alarmlogger"
 	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/alarms"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/monitoring"
+	"github.com/pkg/errors"
 
 	corev1 "k8s.io/api/core/v1"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -29,8 +38,10 @@ const (
 )
 
 var (
-	log     = logf.Log.WithName("licence_expired_handler")
-	handler *Handler
+	log = logf.Log.WithName("licence_expired_handler")
+
+	handlersMu sync.Mutex
+	handlers   = make(map[types.NamespacedName]*Handler)
 )
 
 type LicenceExpiredResourceFuncs interface {
@@ -42,70 +53,184 @@ type Handler struct {
 	namespace string
 	gvr       *schema.GroupVersionResource
 	callbacks LicenceExpiredResourceFuncs
-	watching  bool
+	// dynClient lists the LicenceExpired resources on recovery, the in-cluster client is used if it is nil
+	dynClient dynamic.Interface
+
+	// mu guards the start and the stop of the watch
+	mu       sync.Mutex
+	watching bool
+	stopper  chan struct{}
+	// callbackMu serializes the callbacks of the watch and of the recovery
+	callbackMu sync.Mutex
 }
 
-func New(namespace string, callbacks LicenceExpiredResourceFuncs) *Handler {
-	if nil == handler {
-		handler = &Handler{
-			namespace: namespace,
-			gvr: &schema.GroupVersionResource{
-				Group:    Group,
-				Version:  Version,
-				Resource: Resource,
-			},
-			callbacks: callbacks,
-		}
+// New returns the licence handler of the Consul instance, it is created at the first call for the instance
+func New(key types.NamespacedName, callbacks LicenceExpiredResourceFuncs) *Handler {
+	return NewWithClient(key, callbacks, nil)
+}
+
+// NewWithClient returns the licence handler of the Consul instance recovering the licence state with the given
+// dynamic client, it is created at the first call for the instance
+func NewWithClient(key types.NamespacedName, callbacks LicenceExpiredResourceFuncs, dynClient dynamic.Interface) *Handler {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	if h, found := handlers[key]; found {
+		return h
 	}
+	h := &Handler{
+		namespace: key.Namespace,
+		gvr: &schema.GroupVersionResource{
+			Group:    Group,
+			Version:  Version,
+			Resource: Resource,
+		},
+		callbacks: callbacks,
+		dynClient: dynClient,
+	}
+	handlers[key] = h
+	return h
+}
+
+// Get returns the licence handler of the Consul instance, nil if it has not been created
+func Get(key types.NamespacedName) *Handler {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	return handlers[key]
+}
 
-	return handler
+// Remove stops the licence handler of the deleted Consul instance and forgets it. It returns when the callback in
+// progress has finished, so the status of the instance is not reported after the removal.
+func Remove(key types.NamespacedName) {
+	handlersMu.Lock()
+	h, found := handlers[key]
+	delete(handlers, key)
+	handlersMu.Unlock()
+
+	if found {
+		h.Stop()
+		h.callbackMu.Lock()
+		h.callbackMu.Unlock()
+	}
 }
 
-func (h *Handler) Watch() {
+// Watch starts watching the LicenceExpired resources, it returns false if the watch is running already
+func (h *Handler) Watch() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	if true == h.watching {
-		return
+		return false
 	}
 	h.watching = true
 
 	log.Info("Watch LicenceExpired Resource in ", "namespace", h.namespace)
 
-	stopper := make(chan struct{})
+	h.stopper = make(chan struct{})
 
 	go k8sdynamic.WatchInformer("", h.namespace, "", *h.gvr,
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				h.callbacks.Expired()
+				h.callbackMu.Lock()
+				defer h.callbackMu.Unlock()
+				if h.isWatching() {
+					h.callbacks.Expired()
+				}
 			},
 			DeleteFunc: func(obj interface{}) {
-				h.callbacks.Activate()
+				h.callbackMu.Lock()
+				defer h.callbackMu.Unlock()
+				if h.isWatching() {
+					h.callbacks.Activate()
+				}
 			},
 		},
-		stopper)
+		h.stopper)
+
+	return true
+}
+
+// Recover reactivates the application which was frozen before the restart of the operator if its licence has been
+// reactivated meanwhile. The deletion of the LicenceExpired resource is not seen by the watch in that case, while the
+// existing resources are reported as added, so an expired licence needs no recovery.
+func (h *Handler) Recover(frozen bool) error {
+	if !frozen {
+		return nil
+	}
+	dynClient := h.dynClient
+	if nil == dynClient {
+		dynClient = k8sdynamic.GetDynamicK8sClient()
+	}
+	list, err := dynClient.Resource(*h.gvr).Namespace(h.namespace).List(context.TODO(), v1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list the LicenceExpired resources")
+	}
+	if len(list.Items) == 0 {
+		log.Info("Licence has been reactivated while the operator was not running", "namespace", h.namespace)
+		h.callbackMu.Lock()
+		defer h.callbackMu.Unlock()
+		h.callbacks.Activate()
+	}
+	return nil
+}
 
+func (h *Handler) isWatching() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.watching
+}
+
+// Stop stops watching the LicenceExpired resources
+func (h *Handler) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watching {
+		h.watching = false
+		close(h.stopper)
+	}
 }
 
 // BEGIN sample callback functions
 type SampleFuncs struct {
 	RuntimeClient client.Client
-	AppInstance   *app.Consul
-	ClientSet     *kubernetes.Clientset
-	Monitor       *monitoring.Monitor
-	services      []*corev1.Service
+	Recorder      record.EventRecorder
+	// AppInstance is the copy of the Consul CR owned by the callbacks, it is refreshed before every callback
+	AppInstance *app.Consul
+	ClientSet   *kubernetes.Clientset
+	Monitor     *monitoring.Monitor
+	// ServiceSelector selects the services of the instance which are deleted while the licence is expired
+	ServiceSelector string
+	// Redeploy requests the redeployment of the application on activation when the services have been deleted
+	// before the restart of the operator, so they are not known by the callbacks. The redeployment is done by the
+	// reconciliation, the callbacks do not render the templates and run helm themselves.
+	Redeploy func()
+	services []*corev1.Service
+	// servicesSaved is true if the services have been deleted and saved by these callbacks
+	servicesSaved bool
 }
 
 func (cb *SampleFuncs) Expired() {
 	log.Info("Expired")
+	cb.refresh()
+
+	//The application frozen before the restart of the operator has no services to delete and save
+	frozenBefore := cb.AppInstance.Status.AppStatus == app.AppStatusFrozen
 
 	alarmlogger.RaiseAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{
-		Name:     "LicenceExpired",
-		ID:       "2",
-		Severity: alarmlogger.Warning,
-		Text:     "Application licence is invalid",
+		Name:  alarms.LicenceExpired,
+		Text:  "Application licence is invalid",
+		SubDN: monitoring.AlarmSubDN(cb.AppInstance),
 	})
 
 	cb.Monitor.Pause()
+	key := types.NamespacedName{Namespace: cb.AppInstance.GetNamespace(), Name: cb.AppInstance.GetName()}
+	if !frozenBefore {
+		metrics.CountLicenceExpiration(key)
+		cb.Recorder.Eventf(cb.AppInstance, corev1.EventTypeWarning, app.ReasonLicenceExpired,
+			"Application licence is invalid, AppStatus changed from %v to %v", cb.AppInstance.Status.AppStatus, app.AppStatusFrozen)
+	}
 	cb.AppInstance.Status.AppStatus = app.AppStatusFrozen
-	if err := cb.RuntimeClient.Status().Update(context.TODO(), cb.AppInstance); nil != err {
+	metrics.SetAppStatus(key, app.AppStatusFrozen)
+	cb.AppInstance.SetCondition(app.ConditionLicenceValid, v1.ConditionFalse, app.ReasonLicenceExpired, "Application licence is invalid")
+	if err := cb.updateStatus(); nil != err {
 		log.Error(err, "status appStatus update failed", "appStatus", cb.AppInstance.Status.AppStatus)
 	}
 
@@ -137,11 +262,52 @@ func (cb *SampleFuncs) Expired() {
 	}
 
 	cb.services = svcs
+	cb.servicesSaved = !frozenBefore
+}
+
+// refresh reads the latest version of the instance, the stale copy is used if it fails
+func (cb *SampleFuncs) refresh() {
+	key := client.ObjectKey{Namespace: cb.AppInstance.GetNamespace(), Name: cb.AppInstance.GetName()}
+	if err := cb.RuntimeClient.Get(context.TODO(), key, cb.AppInstance); nil != err {
+		log.Error(err, "failed to get the Consul instance", "namespace", key.Namespace, "name", key.Name)
+	}
+}
+
+// updateStatus writes the application status and the licence condition of the instance, retried on conflict with
+// the latest version of the CR
+func (cb *SampleFuncs) updateStatus() error {
+	appStatus := cb.AppInstance.Status.AppStatus
+	var licenceValid *v1.Condition
+	if condition := meta.FindStatusCondition(cb.AppInstance.Status.Conditions, app.ConditionLicenceValid); nil != condition {
+		licenceValid = condition.DeepCopy()
+	}
+	key := client.ObjectKey{
+		Namespace: cb.AppInstance.GetNamespace(),
+		Name:      cb.AppInstance.GetName(),
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := cb.RuntimeClient.Get(context.TODO(), key, cb.AppInstance)
+		if err != nil {
+			return err
+		}
+		cb.AppInstance.Status.AppStatus = appStatus
+		if nil != licenceValid {
+			meta.SetStatusCondition(&cb.AppInstance.Status.Conditions, *licenceValid)
+		}
+		return cb.RuntimeClient.Status().Update(context.TODO(), cb.AppInstance)
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "failed app status update")
+	}
+
+	return nil
 }
 
 func (cb *SampleFuncs) getSvcListOptions() v1.ListOptions {
 	listOp := v1.ListOptions{
-		LabelSelector: "deleteOnLicenceExpiration=true",
+		LabelSelector: cb.ServiceSelector,
 	}
 
 	return listOp
@@ -149,21 +315,32 @@ func (cb *SampleFuncs) getSvcListOptions() v1.ListOptions {
 
 func (cb *SampleFuncs) Activate() {
 	log.Info("Activate")
+	cb.refresh()
 
 	alarmlogger.ClearAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{
-		Name:     "LicenceExpired",
-		ID:       "2",
-		Severity: alarmlogger.Warning,
-		Text:     "Application licence is valid",
+		Name:  alarms.LicenceExpired,
+		Text:  "Application licence is valid",
+		SubDN: monitoring.AlarmSubDN(cb.AppInstance),
 	})
 
 	ns := cb.AppInstance.GetObjectMeta().GetNamespace()
-	cb.AppInstance.Status.AppStatus = cb.Monitor.GetApplicationStatus()
-	if err := cb.RuntimeClient.Status().Update(context.TODO(), cb.AppInstance); nil != err {
+	appStatus := cb.Monitor.GetApplicationStatus()
+	cb.Recorder.Eventf(cb.AppInstance, corev1.EventTypeNormal, app.ReasonLicenceActive,
+		"Application licence has been reactivated, AppStatus changed from %v to %v", cb.AppInstance.Status.AppStatus, appStatus)
+	cb.AppInstance.Status.AppStatus = appStatus
+	metrics.SetAppStatus(types.NamespacedName{Namespace: ns, Name: cb.AppInstance.GetName()}, appStatus)
+	cb.AppInstance.SetCondition(app.ConditionLicenceValid, v1.ConditionTrue, app.ReasonLicenceActive, "Application licence is valid")
+	if err := cb.updateStatus(); nil != err {
 		log.Error(err, "status appStatus update failed", "appStatus", cb.AppInstance.Status.AppStatus)
 	}
 	cb.Monitor.Run()
 
+	if !cb.servicesSaved && cb.Redeploy != nil {
+		log.Info("Services deleted before the restart, redeploy the application")
+		cb.Redeploy()
+	}
+	cb.servicesSaved = false
+
 	for _, svc := range cb.services {
 		result, err := cb.ClientSet.CoreV1().Services(ns).Create(context.TODO(), svc, v1.CreateOptions{})
 		if nil != err {
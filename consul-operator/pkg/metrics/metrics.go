@@ -0,0 +1,97 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package metrics defines the metrics of the operator, they are served on the metrics endpoint of the manager
+// together with the default metrics of controller-runtime
+package metrics
+
+import (
+	"time"
+
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "consul_operator"
+
+// The application statuses reported by the app status gauge
+var appStatuses = []app.AppStatus{app.AppStatusNotSet, app.AppStatusNotRunning, app.AppStatusRunning, app.AppStatusFrozen}
+
+var (
+	resourceGrantSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "platform_resource_grant_seconds",
+		Help:      "Time from the creation of a platform resource request until its approval",
+		//From a second to more than an hour
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	}, []string{"kind"})
+
+	helmCommandSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "helm_command_duration_seconds",
+		Help:      "Duration of the helm commands",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	helmCommandFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "helm_command_failures_total",
+		Help:      "Number of the failed helm commands",
+	}, []string{"command"})
+
+	licenceExpirations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "licence_expirations_total",
+		Help:      "Number of the licence expirations of the Consul instances",
+	}, []string{"namespace", "name"})
+
+	appStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "app_status",
+		Help:      "Application status of the Consul instances, 1 for the current status and 0 for the others",
+	}, []string{"namespace", "name", "status"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(resourceGrantSeconds, helmCommandSeconds, helmCommandFailures, licenceExpirations, appStatus)
+}
+
+// ObserveResourceGrant records the time the platform resource request of the kind has waited for its approval
+func ObserveResourceGrant(kind string, waited time.Duration) {
+	resourceGrantSeconds.WithLabelValues(kind).Observe(waited.Seconds())
+}
+
+// ObserveHelmCommand records the duration and the failure of the helm command
+func ObserveHelmCommand(command string, duration time.Duration, err error) {
+	helmCommandSeconds.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		helmCommandFailures.WithLabelValues(command).Inc()
+	}
+}
+
+// CountLicenceExpiration counts the licence expiration of the Consul instance
+func CountLicenceExpiration(key types.NamespacedName) {
+	licenceExpirations.WithLabelValues(key.Namespace, key.Name).Inc()
+}
+
+// SetAppStatus reports the current application status of the Consul instance
+func SetAppStatus(key types.NamespacedName, status app.AppStatus) {
+	for _, s := range appStatuses {
+		value := 0.0
+		if s == status {
+			value = 1
+		}
+		appStatus.WithLabelValues(key.Namespace, key.Name, string(s)).Set(value)
+	}
+}
+
+// RemoveInstance drops the metrics of the deleted Consul instance
+func RemoveInstance(key types.NamespacedName) {
+	for _, s := range appStatuses {
+		appStatus.DeleteLabelValues(key.Namespace, key.Name, string(s))
+	}
+	licenceExpirations.DeleteLabelValues(key.Namespace, key.Name)
+}
@@ -8,57 +8,155 @@ import (
 	"context"
 	"github.com/pkg/errors"
 	"k8s.io/client-go/util/retry"
+	"sync"
 
 	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
 	kubelib2 "github.com/nokia/industrial-application-framework/consul-operator/libs/kubelib"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/alarms"
+	"github.com/nokia/industrial-application-framework/consul-operator/pkg/metrics"
 
 	"github.com/nokia/industrial-application-framework/alarmlogger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	informersv1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/informers/internalinterfaces"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type Monitor struct {
-	RuntimeClient      client.Client
-	Instance           *app.Consul
-	Namespace          string
-	ClientSet          *kubernetes.Clientset
-	Running            bool
-	RunningCallback    func()
-	NotRunningCallback func()
-	pauseChannel       chan struct{}
+	RuntimeClient client.Client
+	Recorder      record.EventRecorder
+	// Instance is the copy of the Consul CR owned by the monitor, it is refreshed before every status check
+	Instance  *app.Consul
+	Namespace string
+	// PodSelector selects the checked pods of the instance
+	PodSelector string
+	ClientSet   kubernetes.Interface
+	// ExpectedPods returns the number of the checked pods of the running application from the refreshed copy of the
+	// Consul CR
+	ExpectedPods func(instance *app.Consul) int
+	// The callbacks get the refreshed copy of the Consul CR owned by the monitor
+	RunningCallback    func(instance *app.Consul)
+	NotRunningCallback func(instance *app.Consul)
+
+	// mu guards the start and the pause of the watch
+	mu           sync.Mutex
+	running      bool
+	removed      bool
+	pauseChannel chan struct{}
+	// refreshMu serializes the status checks of the consecutive watches
+	refreshMu sync.Mutex
+	// appNotRunningAlarmActive is true while the AppNotRunning alarm of the instance is raised, the alarm raised by the
+	// previous operator is known from the stored AppStatus
+	appNotRunningAlarmActive bool
 }
 
 var (
-	log                        = logf.Log.WithName("monitoring_controller")
-	monitoringInstance         *Monitor
-	isAppNotRunningAlarmActive bool
+	log = logf.Log.WithName("monitoring_controller")
+
+	// newClientSet, raiseAlarm and clearAlarm are replaced by the tests
+	newClientSet = func() kubernetes.Interface { return kubelib2.GetKubeAPI() }
+	raiseAlarm   = alarmlogger.RaiseAlarm
+	clearAlarm   = alarmlogger.ClearAlarm
+
+	monitorsMu sync.Mutex
+	monitors   = make(map[types.NamespacedName]*Monitor)
 )
 
-func NewMonitor(runtimeClient client.Client, instance *app.Consul, namespace string,
-	runningCallback func(), notRunningCallback func()) *Monitor {
-	if monitoringInstance == nil {
-		monitoringInstance = &Monitor{
-			RuntimeClient:      runtimeClient,
-			Instance:           instance,
-			Namespace:          namespace,
-			ClientSet:          kubelib2.GetKubeAPI(),
-			RunningCallback:    runningCallback,
-			NotRunningCallback: notRunningCallback,
-		}
+// NewMonitor returns the monitor of the Consul instance, it is created at the first call for the instance with its own
+// copy of the instance
+func NewMonitor(runtimeClient client.Client, recorder record.EventRecorder, instance *app.Consul, namespace string,
+	podSelector string, expectedPods func(*app.Consul) int, runningCallback func(*app.Consul), notRunningCallback func(*app.Consul)) *Monitor {
+	key := types.NamespacedName{Namespace: namespace, Name: instance.GetName()}
+
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	if m, found := monitors[key]; found {
+		return m
+	}
+	m := &Monitor{
+		RuntimeClient:      runtimeClient,
+		Recorder:           recorder,
+		Instance:           instance.DeepCopy(),
+		Namespace:          namespace,
+		PodSelector:        podSelector,
+		ClientSet:          newClientSet(),
+		ExpectedPods:       expectedPods,
+		RunningCallback:    runningCallback,
+		NotRunningCallback: notRunningCallback,
+		//The alarm raised before the restart of the operator is cleared when the application is running again
+		appNotRunningAlarmActive: instance.Status.AppStatus == app.AppStatusNotRunning,
 	}
-	return monitoringInstance
+	if m.appNotRunningAlarmActive {
+		m.migrateAppNotRunningAlarm()
+	}
+	monitors[key] = m
+	return m
+}
+
+// migrateAppNotRunningAlarm replaces the AppNotRunning alarm raised before the restart of the operator by the alarm of
+// the instance. The earlier versions of the operator raised the alarm without subdn, it is cleared, and the alarm is
+// raised again with the subdn of the instance, which is idempotent if the alarm has been raised with it already.
+func (m *Monitor) migrateAppNotRunningAlarm() {
+	clearAlarm(alarmlogger.AppAlarm, appNotRunningAlarm("", "Replaced by the alarm of the instance"))
+	raiseAlarm(alarmlogger.AppAlarm, appNotRunningAlarm(AlarmSubDN(m.Instance), "Not all components are ready"))
 }
 
+// appNotRunningAlarm returns the AppNotRunning alarm of the instance with the subdn
+func appNotRunningAlarm(subDN, text string) *alarmlogger.AlarmDetails {
+	return &alarmlogger.AlarmDetails{
+		Name:  alarms.AppNotRunning,
+		Text:  text,
+		SubDN: subDN,
+	}
+}
+
+// Get returns the monitor of the Consul instance, nil if it has not been created
+func Get(key types.NamespacedName) *Monitor {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+	return monitors[key]
+}
+
+// Remove stops the monitor of the deleted Consul instance and forgets it. It returns when the status check in
+// progress has finished, so the status of the instance is not reported after the removal.
+func Remove(key types.NamespacedName) {
+	monitorsMu.Lock()
+	m, found := monitors[key]
+	delete(monitors, key)
+	monitorsMu.Unlock()
+
+	if found {
+		m.mu.Lock()
+		m.removed = true
+		m.mu.Unlock()
+		m.Pause()
+		m.refreshMu.Lock()
+		m.refreshMu.Unlock()
+	}
+}
+
+// AlarmSubDN distinguishes the alarms of the Consul instances, the instances of the same name in different namespaces
+// as well
+func AlarmSubDN(instance *app.Consul) string {
+	return "/NS-" + instance.GetNamespace() + "/CONSUL-" + instance.GetName()
+}
+
+// Run starts watching the pods of the application, it does nothing if the watch is running already or the monitor
+// has been removed
 func (m *Monitor) Run() {
-	if m.Running {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running || m.removed {
 		return
 	}
-	m.Running = true
+	m.running = true
 
 	log.Info("Watching application")
 
@@ -71,50 +169,67 @@ func (m *Monitor) Run() {
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
 				log.Info("Pod changed")
-
-				status := m.GetApplicationStatus()
-				if m.Instance.Status.AppStatus != status {
-					switch status {
-					case app.AppStatusRunning:
-						if isAppNotRunningAlarmActive {
-							// clear alarm
-							alarmlogger.ClearAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{
-								Name:     "AppNotRunning",
-								ID:       "1",
-								Severity: alarmlogger.Warning,
-								Text:     "All components are now ready",
-							})
-							isAppNotRunningAlarmActive = false
-						}
-						m.RunningCallback()
-					case app.AppStatusNotRunning:
-						if !isAppNotRunningAlarmActive {
-							// raise alarm
-							alarmlogger.RaiseAlarm(alarmlogger.AppAlarm, &alarmlogger.AlarmDetails{
-								Name:     "AppNotRunning",
-								ID:       "1",
-								Severity: alarmlogger.Warning,
-								Text:     "Not all components are ready",
-							})
-							isAppNotRunningAlarmActive = true
-						}
-						m.NotRunningCallback()
-					}
-				}
-
-				m.Instance.Status.AppStatus = status
-				if err := m.updateAppStatus(m.Instance); nil != err {
-					log.Error(err, "status appStatus update failed")
-				}
-
-				log.Info("UpdateFunc", "status", m.Instance.Status.AppStatus)
+				m.refreshStatus()
+			},
+			AddFunc: func(obj interface{}) {
+				m.refreshStatus()
 			},
-			AddFunc: func(obj interface{}) {},
 		}, m.pauseChannel)
 }
 
+// refreshStatus reports the status of the application if it has changed. It is called for the initial list of the
+// pods as well, so the status is corrected after the restart of the operator.
+func (m *Monitor) refreshStatus() {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	if !m.IsRunning() {
+		//Paused, eg. the licence expired while the event was dispatched
+		return
+	}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: m.Instance.GetName()}
+	if err := m.RuntimeClient.Get(context.TODO(), key, m.Instance); err != nil {
+		log.Error(err, "failed to get the Consul instance")
+		return
+	}
+	status := m.GetApplicationStatus()
+	if m.Instance.Status.AppStatus != status {
+		switch status {
+		case app.AppStatusRunning:
+			m.Recorder.Eventf(m.Instance, corev1.EventTypeNormal, app.ReasonPodsReady, "AppStatus changed from %v to %v", m.Instance.Status.AppStatus, status)
+			if m.appNotRunningAlarmActive {
+				// clear alarm
+				clearAlarm(alarmlogger.AppAlarm, appNotRunningAlarm(AlarmSubDN(m.Instance), "All components are now ready"))
+				m.appNotRunningAlarmActive = false
+			}
+			m.RunningCallback(m.Instance)
+		case app.AppStatusNotRunning:
+			m.Recorder.Eventf(m.Instance, corev1.EventTypeWarning, app.ReasonPodsNotReady, "AppStatus changed from %v to %v", m.Instance.Status.AppStatus, status)
+			if !m.appNotRunningAlarmActive {
+				// raise alarm
+				raiseAlarm(alarmlogger.AppAlarm, appNotRunningAlarm(AlarmSubDN(m.Instance), "Not all components are ready"))
+				m.appNotRunningAlarmActive = true
+			}
+			m.NotRunningCallback(m.Instance)
+		}
+	}
+
+	m.Instance.Status.AppStatus = status
+	metrics.SetAppStatus(types.NamespacedName{Namespace: m.Namespace, Name: m.Instance.GetName()}, status)
+	if status == app.AppStatusRunning {
+		m.Instance.SetCondition(app.ConditionReady, v1.ConditionTrue, app.ReasonPodsReady, "All components are ready")
+	} else {
+		m.Instance.SetCondition(app.ConditionReady, v1.ConditionFalse, app.ReasonPodsNotReady, "Not all components are ready")
+	}
+	if err := m.updateAppStatus(m.Instance); nil != err {
+		log.Error(err, "status appStatus update failed")
+	}
+
+	log.Info("UpdateFunc", "status", m.Instance.Status.AppStatus)
+}
+
 func (m *Monitor) updateAppStatus(instance *app.Consul) error {
 	appStatus := instance.Status.AppStatus
+	ready := meta.FindStatusCondition(instance.Status.Conditions, app.ConditionReady)
 	key := client.ObjectKey{
 		Namespace: instance.GetNamespace(),
 		Name:      instance.GetName(),
@@ -126,6 +241,9 @@ func (m *Monitor) updateAppStatus(instance *app.Consul) error {
 			return err
 		}
 		instance.Status.AppStatus = appStatus
+		if nil != ready {
+			meta.SetStatusCondition(&instance.Status.Conditions, *ready)
+		}
 		err = m.RuntimeClient.Status().Update(context.TODO(), instance)
 		return err
 	})
@@ -138,16 +256,36 @@ func (m *Monitor) updateAppStatus(instance *app.Consul) error {
 }
 
 
+// Pause stops watching the pods of the application
 func (m *Monitor) Pause() {
-	if m.Running {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
 		log.Info("Watching application paused")
-		m.Running = false
+		m.running = false
 		close(m.pauseChannel)
 	}
 }
 
+// IsRunning reports whether the pods of the application are watched
+func (m *Monitor) IsRunning() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// GetApplicationStatus returns RUNNING when all of the expected pods of the application exist and all of their
+// containers are ready
 func (m *Monitor) GetApplicationStatus() app.AppStatus {
-	pods, _ := m.ClientSet.CoreV1().Pods(m.Namespace).List(context.TODO(), v1.ListOptions{LabelSelector: "statusCheck=true"})
+	pods, err := m.ClientSet.CoreV1().Pods(m.Namespace).List(context.TODO(), v1.ListOptions{LabelSelector: m.PodSelector})
+	if err != nil {
+		log.Error(err, "failed to list the pods of the application")
+		return app.AppStatusNotRunning
+	}
+	//The servers which have not been created yet are not running either
+	if len(pods.Items) == 0 || len(pods.Items) < m.ExpectedPods(m.Instance) {
+		return app.AppStatusNotRunning
+	}
 	for _, pod := range pods.Items {
 		if len(pod.Status.ContainerStatuses) == 0 {
 			return app.AppStatusNotRunning
@@ -157,14 +295,13 @@ func (m *Monitor) GetApplicationStatus() app.AppStatus {
 				return app.AppStatusNotRunning
 			}
 		}
-		return app.AppStatusRunning
 	}
-	return app.AppStatusNotRunning
+	return app.AppStatusRunning
 }
 
 func (m *Monitor) watchInformer(eventHandler cache.ResourceEventHandler, stopper chan struct{}) {
 	listOptionsFunc := internalinterfaces.TweakListOptionsFunc(func(options *v1.ListOptions) {
-		options.LabelSelector = "statusCheck=true"
+		options.LabelSelector = m.PodSelector
 	})
 
 	informer := informersv1.NewFilteredPodInformer(
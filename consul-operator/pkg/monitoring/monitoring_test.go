@@ -0,0 +1,208 @@
+// Copyright 2021 Nokia
+// Licensed under the BSD 3-Clause License.
+// SPDX-License-Identifier: BSD-3-Clause
+
+package monitoring
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/nokia/industrial-application-framework/alarmlogger"
+	app "github.com/nokia/industrial-application-framework/consul-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newStatusPod returns the first checked pod of the application
+func newStatusPod(ready bool) *corev1.Pod {
+	return newNamedStatusPod("consul-0", ready)
+}
+
+func newNamedStatusPod(name string, ready bool) *corev1.Pod {
+	return newAppStatusPod("consul", name, ready)
+}
+
+// newAppStatusPod returns the checked pod of the application of the given app label
+func newAppStatusPod(app, name string, ready bool) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{"statusCheck": "true", "app": app}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "consul", Ready: ready},
+		}},
+	}
+}
+
+// withAlarms records the raised and cleared alarms with their subdn and points the monitors to the clientset for the
+// duration of the test
+func withAlarms(t *testing.T, clientSet kubernetes.Interface) *[]string {
+	t.Helper()
+	recorded := new([]string)
+	prevClientSet, prevRaise, prevClear := newClientSet, raiseAlarm, clearAlarm
+	newClientSet = func() kubernetes.Interface { return clientSet }
+	raiseAlarm = func(_ alarmlogger.LogType, alarm *alarmlogger.AlarmDetails) {
+		*recorded = append(*recorded, "raise "+alarm.Name+alarm.SubDN)
+	}
+	clearAlarm = func(_ alarmlogger.LogType, alarm *alarmlogger.AlarmDetails) {
+		*recorded = append(*recorded, "clear "+alarm.Name+alarm.SubDN)
+	}
+	t.Cleanup(func() {
+		newClientSet, raiseAlarm, clearAlarm = prevClientSet, prevRaise, prevClear
+	})
+	return recorded
+}
+
+// newTestMonitor returns the running monitor of the stored instance, the watch of the pods is not started
+func newTestMonitor(t *testing.T, runtimeClient client.Client, instance *app.Consul) *Monitor {
+	t.Helper()
+	key := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	t.Cleanup(func() {
+		Remove(key)
+	})
+	m := NewMonitor(runtimeClient, record.NewFakeRecorder(10), instance, instance.Namespace, "statusCheck=true,app="+instance.Name,
+		func(*app.Consul) int { return 1 }, func(*app.Consul) {}, func(*app.Consul) {})
+	m.running, m.pauseChannel = true, make(chan struct{})
+	return m
+}
+
+// TestMonitorRestart checks the AppNotRunning alarm raised before the restart of the operator, the monitor created by
+// the new operator starts from the stored AppStatus. The alarm raised without subdn by the earlier versions is replaced
+// by the alarm of the instance.
+func TestMonitorRestart(t *testing.T) {
+	const (
+		legacy   = "AppNotRunning"
+		instance = "AppNotRunning/NS-app/CONSUL-consul"
+	)
+	tests := []struct {
+		name      string
+		appStatus app.AppStatus
+		ready     bool
+		alarms    []string
+		expected  app.AppStatus
+	}{
+		{"alarm cleared when running", app.AppStatusNotRunning, true,
+			[]string{"clear " + legacy, "raise " + instance, "clear " + instance}, app.AppStatusRunning},
+		{"alarm kept when not running", app.AppStatusNotRunning, false,
+			[]string{"clear " + legacy, "raise " + instance}, app.AppStatusNotRunning},
+		{"alarm raised when stopped", app.AppStatusRunning, false, []string{"raise " + instance}, app.AppStatusNotRunning},
+		{"no alarm when running", app.AppStatusRunning, true, nil, app.AppStatusRunning},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorded := withAlarms(t, k8sfake.NewSimpleClientset(newStatusPod(test.ready)))
+
+			scheme := runtime.NewScheme()
+			if err := app.AddToScheme(scheme); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			instance := &app.Consul{ObjectMeta: metav1.ObjectMeta{Name: "consul", Namespace: "app"}}
+			instance.Status.AppStatus = test.appStatus
+			runtimeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+
+			newTestMonitor(t, runtimeClient, instance).refreshStatus()
+
+			if !reflect.DeepEqual(*recorded, test.alarms) {
+				t.Errorf("expected the alarms %v, got %v", test.alarms, *recorded)
+			}
+			stored := &app.Consul{}
+			if err := runtimeClient.Get(context.TODO(), client.ObjectKey{Namespace: "app", Name: "consul"}, stored); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stored.Status.AppStatus != test.expected {
+				t.Errorf("expected AppStatus %v, got %v", test.expected, stored.Status.AppStatus)
+			}
+		})
+	}
+}
+
+// TestMonitorNamespaces checks that the alarms of the instances of the same name in different namespaces are distinct
+func TestMonitorNamespaces(t *testing.T) {
+	appPod := newStatusPod(false)
+	otherPod := newStatusPod(false)
+	otherPod.Namespace = "other"
+	clientSet := k8sfake.NewSimpleClientset(appPod, otherPod)
+	recorded := withAlarms(t, clientSet)
+
+	scheme := runtime.NewScheme()
+	if err := app.AddToScheme(scheme); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var instances []*app.Consul
+	for _, namespace := range []string{"app", "other"} {
+		instance := &app.Consul{ObjectMeta: metav1.ObjectMeta{Name: "consul", Namespace: namespace}}
+		instance.Status.AppStatus = app.AppStatusRunning
+		instances = append(instances, instance)
+	}
+	runtimeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instances[0], instances[1]).Build()
+	appMonitor := newTestMonitor(t, runtimeClient, instances[0])
+	otherMonitor := newTestMonitor(t, runtimeClient, instances[1])
+	if appMonitor == otherMonitor {
+		t.Fatal("expected a monitor for each instance")
+	}
+
+	appMonitor.refreshStatus()
+	otherMonitor.refreshStatus()
+	expected := []string{"raise AppNotRunning/NS-app/CONSUL-consul", "raise AppNotRunning/NS-other/CONSUL-consul"}
+	if !reflect.DeepEqual(*recorded, expected) {
+		t.Errorf("expected the alarms %v, got %v", expected, *recorded)
+	}
+
+	//Only the alarm of the instance getting ready is cleared
+	*recorded = nil
+	appPod.Status.ContainerStatuses[0].Ready = true
+	if _, err := clientSet.CoreV1().Pods("app").UpdateStatus(context.TODO(), appPod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	appMonitor.refreshStatus()
+	otherMonitor.refreshStatus()
+	expected = []string{"clear AppNotRunning/NS-app/CONSUL-consul"}
+	if !reflect.DeepEqual(*recorded, expected) {
+		t.Errorf("expected the alarms %v, got %v", expected, *recorded)
+	}
+}
+
+func TestGetApplicationStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		pods     []runtime.Object
+		expected app.AppStatus
+	}{
+		{"no pods", 1, nil, app.AppStatusNotRunning},
+		{"single server ready", 1, []runtime.Object{newNamedStatusPod("consul-0", true)}, app.AppStatusRunning},
+		{"all servers ready", 3, []runtime.Object{newNamedStatusPod("consul-0", true),
+			newNamedStatusPod("consul-1", true), newNamedStatusPod("consul-2", true)}, app.AppStatusRunning},
+		{"second server not ready", 3, []runtime.Object{newNamedStatusPod("consul-0", true),
+			newNamedStatusPod("consul-1", false), newNamedStatusPod("consul-2", true)}, app.AppStatusNotRunning},
+		{"server missing", 3, []runtime.Object{newNamedStatusPod("consul-0", true),
+			newNamedStatusPod("consul-1", true)}, app.AppStatusNotRunning},
+		//The pods of the other instances of the namespace are not checked
+		{"only other instance ready", 1, []runtime.Object{newAppStatusPod("other", "other-0", true)}, app.AppStatusNotRunning},
+		{"other instance not ready", 1, []runtime.Object{newNamedStatusPod("consul-0", true),
+			newAppStatusPod("other", "other-0", false)}, app.AppStatusRunning},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &app.Consul{ObjectMeta: metav1.ObjectMeta{Name: "consul", Namespace: "app"}}
+			instance.Spec.ReplicaCount = test.replicas
+			m := &Monitor{
+				Instance:     instance,
+				Namespace:    "app",
+				PodSelector:  "statusCheck=true,app=consul",
+				ClientSet:    k8sfake.NewSimpleClientset(test.pods...),
+				ExpectedPods: func(instance *app.Consul) int { return instance.Spec.ReplicaCount },
+			}
+			if status := m.GetApplicationStatus(); status != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, status)
+			}
+		})
+	}
+}
@@ -5,17 +5,17 @@
 package platformres
 
 import (
+	"context"
 	kubelib2 "github.com/nokia/industrial-application-framework/consul-operator/libs/kubelib"
 	"github.com/nokia/industrial-application-framework/consul-operator/pkg/k8sdynamic"
 	"github.com/pkg/errors"
 	"io/ioutil"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/tools/cache"
-	"os"
+	"k8s.io/apimachinery/pkg/types"
 	"strings"
-	"sync"
-	"time"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -23,21 +23,63 @@ import (
 var log = logf.Log.WithName("platformres")
 
 const (
-	ResourceRequestPath = "RESREQ_DIR"
+	Group   = "ops.dac.nokia.com"
+	Version = "v1alpha1"
 
 	StatusField         = "status"
 	ApprovalStatusField = "approvalStatus"
 )
 
-func ApplyPlatformResourceRequests(namespace string) ([]k8sdynamic.ResourceDescriptor, error) {
+// ApplyPlatformResourceRequests applies the platform resource requests templated into the directory. If the owner is
+// given it is set as the controller of the requests except the exempt ones, so they are garbage collected with the owner.
+func ApplyPlatformResourceRequests(dir string, namespace string, owner *metav1.OwnerReference, exempt ...string) ([]k8sdynamic.ResourceDescriptor, error) {
 	logger := log.WithName("ApplyPlatformResourceRequests")
 	logger.Info("Called")
 
 	dynClient := k8sdynamic.New(kubelib2.GetKubeAPI())
-	dir := os.Getenv(ResourceRequestPath)
-	if dir == "" {
-		return nil, errors.New(ResourceRequestPath + " is not set")
+	if owner != nil {
+		dynClient = dynClient.WithOwner(*owner, exempt...)
 	}
+	return forEachRequestFile(dir, func(content string) ([]k8sdynamic.ResourceDescriptor, error) {
+		//A file may contain more requests, eg. a Storage for every replica
+		resourceDescs, err := dynClient.ApplyConcatenatedResources(content, namespace)
+		if err != nil {
+			return resourceDescs, errors.Wrap(err, "failed to apply the request in k8s")
+		}
+		return resourceDescs, nil
+	})
+}
+
+// DescribePlatformResourceRequests returns the descriptors of the platform resource requests templated into the
+// directory without applying them
+func DescribePlatformResourceRequests(dynClient k8sdynamic.K8sDynClient, dir string, namespace string) ([]k8sdynamic.ResourceDescriptor, error) {
+	return forEachRequestFile(dir, func(content string) ([]k8sdynamic.ResourceDescriptor, error) {
+		return dynClient.DescribeConcatenatedResources(content, namespace)
+	})
+}
+
+// ListOwnedPlatformResources returns the platform resources in the namespace which are owned by the given owner, the
+// kinds which are not installed are skipped
+func ListOwnedPlatformResources(dynClient k8sdynamic.K8sDynClient, namespace string, owner types.UID) ([]k8sdynamic.ResourceDescriptor, error) {
+	var descList []k8sdynamic.ResourceDescriptor
+	for _, kind := range Kinds {
+		gvk := schema.GroupVersionKind{Group: Group, Version: Version, Kind: kind}
+		resourceDescs, err := dynClient.ListOwnedResources(gvk, namespace, owner)
+		if errors.Is(err, k8sdynamic.ErrResourceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the %v platform resources", kind)
+		}
+		descList = append(descList, resourceDescs...)
+	}
+	return descList, nil
+}
+
+// forEachRequestFile calls the handler with the content of the templated request files of the directory and collects
+// the descriptors
+func forEachRequestFile(dir string, handler func(content string) ([]k8sdynamic.ResourceDescriptor, error)) ([]k8sdynamic.ResourceDescriptor, error) {
+	logger := log.WithName("forEachRequestFile")
 
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -56,145 +98,81 @@ func ApplyPlatformResourceRequests(namespace string) ([]k8sdynamic.ResourceDescr
 				logger.Info("File is empty skip it", "path", dir+"/"+file.Name())
 				continue
 			}
-			resourceDesc, err := dynClient.ApplyYamlResource(string(fileContent), namespace)
-			descList = append(descList, resourceDesc)
+			resourceDescs, err := handler(string(fileContent))
+			descList = append(descList, resourceDescs...)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to apply the request in k8s")
+				return nil, err
 			}
 		}
 	}
 
 	return descList, nil
 }
-func ApplyPnaResourceRequests(namespace string) ([]k8sdynamic.ResourceDescriptor, error) {
-	logger := log.WithName("ApplyPnaResourceRequests")
-	logger.Info("Called")
 
-	dynClient := k8sdynamic.New(kubelib2.GetKubeAPI())
-	dir := os.Getenv(ResourceRequestPath)
-	if dir == "" {
-		return nil, errors.New(ResourceRequestPath + " is not set")
-	}
+const (
+	ApprovalStatusApproved = "Approved"
+	ApprovalStatusRejected = "Rejected"
+)
 
-	var descList []k8sdynamic.ResourceDescriptor
-	fileContent, err := ioutil.ReadFile(dir + "/private-network-access.yaml")
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read file")
-	}
+// Kinds of the platform resource requests, their approval status is watched by the controller
+var Kinds = []string{"Resourcerequest", "Storage", "PrivateNetworkAccess", "MetricsEndpoint"}
 
-	resourceDesc, err := dynClient.ApplyYamlResource(string(fileContent), namespace)
-	descList = append(descList, resourceDesc)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to apply the request in k8s")
-	}
-	return descList, nil
+// StorageResource is the resource of the Storage requests, the claims granted for them hold the data of the application
+const StorageResource = "storages"
+
+// IsStorage reports whether the platform resource request is a Storage, deleting it deletes the data stored on it
+func IsStorage(resource k8sdynamic.ResourceDescriptor) bool {
+	return resource.Gvr.Group == Group && resource.Gvr.Resource == StorageResource
 }
 
-func WaitUntilResourcesGranted(resourceList []k8sdynamic.ResourceDescriptor, timeout time.Duration) error {
-	logger := log.WithName("WaitUntilResourcesGranted")
+// ErrResourceRequestRejected is returned when a platform resource request has been rejected, retrying it does not help
+var ErrResourceRequestRejected = errors.New("platform resource request has been rejected")
 
-	var stopperList []chan struct{}
-	var waitGroup sync.WaitGroup
-	var results []*bool
+// CheckResourcesGranted reads the approval status of the applied platform resource requests without waiting for them.
+// It returns the requests approved so far and the ones still waiting for their approval, all of them have been
+// approved when none of them is pending.
+func CheckResourcesGranted(resourceList []k8sdynamic.ResourceDescriptor) ([]*unstructured.Unstructured, []*unstructured.Unstructured, error) {
+	logger := log.WithName("CheckResourcesGranted")
 
+	dynClient := k8sdynamic.GetDynamicK8sClient()
+	var approved, pending []*unstructured.Unstructured
 	for _, resource := range resourceList {
-		stopper := make(chan struct{})
-		waitGroup.Add(1)
-		var result bool
-		results = append(results, &result)
-		startWatchResourceRequest(
-			resource.Name,
-			resource.Namespace,
-			"",
-			resource.Gvr.GetGvr(),
-			stopper,
-			&waitGroup,
-			&result,
-		)
-		stopperList = append(stopperList, stopper)
-	}
-
-	if waitTimeout(&waitGroup, timeout) {
-		for _, stopper := range stopperList {
-			close(stopper)
+		obj, err := dynClient.Resource(resource.Gvr.GetGvr()).Namespace(resource.Namespace).Get(context.TODO(), resource.Name, metav1.GetOptions{})
+		if err != nil {
+			return approved, pending, errors.Wrapf(err, "failed to get the platform resource request %v", resource.Name)
 		}
-		return errors.New("waiting for the approval of the platform resource requests timed out")
-	} else {
-		for _, result := range results {
-			if !*result {
-				return errors.New("some of the platform resource request(s) has rejected")
-			}
+		switch value, _ := getApprovalStatus(obj); value {
+		case ApprovalStatusApproved:
+			approved = append(approved, obj)
+		case ApprovalStatusRejected:
+			return approved, pending, errors.Wrap(ErrResourceRequestRejected, resource.Name)
+		default:
+			logger.V(1).Info("Resource request is not approved yet", "resource", resource.Name)
+			pending = append(pending, obj)
 		}
 	}
-	logger.Info("All of the requested platform resources have been granted")
-	return nil
+	return approved, pending, nil
 }
 
-const (
-	ApprovalStatusApproved = "Approved"
-	ApprovalStatusRejected = "Rejected"
-)
-
-func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
-	finished := make(chan struct{})
-	go func() {
-		defer close(finished)
-		wg.Wait()
-	}()
-	select {
-	case <-finished:
-		return false // completed normally
-	case <-time.After(timeout):
-		return true // timed out
+// IsResourceReleased reports whether the deleted platform resource request has disappeared
+func IsResourceReleased(resource k8sdynamic.ResourceDescriptor) (bool, error) {
+	_, err := k8sdynamic.GetDynamicK8sClient().Resource(resource.Gvr.GetGvr()).Namespace(resource.Namespace).Get(context.TODO(), resource.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get the platform resource request %v", resource.Name)
 	}
+	return false, nil
 }
 
-func startWatchResourceRequest(name string, namespace string, resourceVersion string, gvr schema.GroupVersionResource, stopper chan struct{}, waitGroup *sync.WaitGroup, result *bool) {
-	logger := log.WithName("StartWatchResourceRequest").WithValues("resource", name)
-
-	logger.Info("Watching resource")
-
-	go k8sdynamic.WatchInformer(
-		name, namespace, resourceVersion, gvr,
-		cache.ResourceEventHandlerFuncs{
-			DeleteFunc: func(obj interface{}) {
-				logger.V(1).Info("Delete resource detected")
-				close(stopper)
-				waitGroup.Done()
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				logger.V(1).Info("Resource request modification detected")
-
-				newValue, _ := getApprovalStatus(newObj)
-				oldValue, _ := getApprovalStatus(oldObj)
-
-				if oldValue != newValue {
-					switch newValue {
-					case ApprovalStatusApproved:
-						*result = true
-						logger.Info("Resource approved")
-						break
-					default:
-						*result = false
-						logger.Info("Cannot create resource")
-						break
-					}
-					waitGroup.Done()
-					close(stopper)
-				}
-			},
-			AddFunc: func(obj interface{}) {
-				logger.V(1).Info("Add resource detected")
-				value, _ := getApprovalStatus(obj)
-				if value == ApprovalStatusApproved {
-					*result = true
-					waitGroup.Done()
-					close(stopper)
-				}
-			},
-		},
-		stopper)
+// ApprovalStatusChanged reports whether the approval status differs in the two versions of a platform resource request
+func ApprovalStatusChanged(oldObj, newObj interface{}) bool {
+	oldValue, _ := getApprovalStatus(oldObj)
+	newValue, _ := getApprovalStatus(newObj)
+	return oldValue != newValue
 }
+
 func getApprovalStatus(obj interface{}) (string, bool) {
 	unstructObj := obj.(*unstructured.Unstructured)
 	value, found, _ := unstructured.NestedString(unstructObj.Object, StatusField, ApprovalStatusField)
@@ -20,6 +20,7 @@ import (
 type Templater struct {
 	Data          interface{}
 	Namespace     string
+	Name          string
 	DeploymentDir string
 	DirName       string
 	SourceDir     string
@@ -30,10 +31,29 @@ var log = logf.Log.WithName("template_controller")
 
 const DeploymentDir = "DEPLOYMENT_DIR"
 
-func NewTemplater(data interface{}, namespace string, dirName string) (*Templater, error) {
+//Functions available in the templates besides the builtin ones
+var funcs = templ.FuncMap{
+	//until returns the numbers from 0 to n-1, eg. [[ range $i := until .ReplicaCount ]]
+	"until": func(n int) []int {
+		seq := make([]int, 0, n)
+		for i := 0; i < n; i++ {
+			seq = append(seq, i)
+		}
+		return seq
+	},
+}
+
+// WorkDir returns the directory the files of the deployment directory are generated into for the named deployment of
+// the namespace, so the deployments do not overwrite the files of each other
+func WorkDir(deploymentDir, dirName, namespace, name string) string {
+	return filepath.Join(deploymentDir, dirName+"-generated", namespace, name)
+}
+
+func NewTemplater(data interface{}, namespace string, name string, dirName string) (*Templater, error) {
 	t := &Templater{
 		Data:      data,
 		Namespace: namespace,
+		Name:      name,
 		DirName:   dirName,
 	}
 	if t.DeploymentDir = os.Getenv(DeploymentDir); t.DeploymentDir == "" {
@@ -41,7 +61,7 @@ func NewTemplater(data interface{}, namespace string, dirName string) (*Template
 	}
 
 	t.SourceDir = filepath.Join(t.DeploymentDir, t.DirName)
-	t.WorkDir = filepath.Join(t.DeploymentDir, t.DirName+"-generated")
+	t.WorkDir = WorkDir(t.DeploymentDir, t.DirName, t.Namespace, t.Name)
 
 	if err := t.copyDeploymentYamls(); err != nil {
 		return nil, err
@@ -79,7 +99,7 @@ func (t *Templater) templateFile(workDir string, file os.FileInfo, joinSeparator
 	if strings.Contains(file.Name(), "yaml") {
 		log.Info("templating", "file", file.Name())
 
-		template, err := templ.New(file.Name()).Delims("[[", "]]").ParseFiles(filepath.Join(workDir, file.Name()))
+		template, err := templ.New(file.Name()).Delims("[[", "]]").Funcs(funcs).ParseFiles(filepath.Join(workDir, file.Name()))
 		if err != nil {
 			return "", err
 		}